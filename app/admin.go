@@ -0,0 +1,28 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"litepay/models"
+	u "litepay/util"
+)
+
+//Only let admins through. Must run after GinJwt
+func AdminMiddleWare() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		id, ok := c.Get("user")
+		if !ok {
+			c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+			return
+		}
+
+		user, ok := id . (uint)
+		if !ok || !models.IsAdmin(user) {
+			c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -14,7 +14,8 @@ var GinJwt = func(c *gin.Context) {
 	noAuth := []string {
 		"/ws/connect",
 		"/api/user/login",
-		"/api/user/new"}
+		"/api/user/new",
+		"/webhooks/paystack"}
 
 	path := c.Request.RequestURI
 
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"github.com/gin-gonic/gin"
+	"litepay/models"
+	u "litepay/util"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+//Keeps a copy of everything the handler writes so it can be stored for replay
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+//Handlers answer a request they turned down with status false, often with a 200. Those did nothing, and
+//many are worth retrying ("Please retry", "try again later"), so they are not stored against the key
+func failedResponse(body []byte) bool {
+
+	response := &struct {
+		Status *bool `json:"status"`
+	}{}
+
+	err := json.Unmarshal(body, response)
+	return err == nil && response.Status != nil && !*response.Status
+}
+
+//Makes retries of a request carrying an Idempotency-Key header safe. The first request runs and its
+//response is stored, identical retries get that response back, a retry with a different body is rejected.
+//Failed requests are not stored, so a retry runs them again. Requests without the header are passed
+//through untouched. Must run after GinJwt
+func IdempotencyMiddleWare() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(400, u.Message(false, "Idempotency-Key is too long"))
+			return
+		}
+
+		id, ok := c.Get("user")
+		if !ok {
+			c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+			return
+		}
+
+		user, ok := id . (uint)
+		if !ok {
+			c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, u.InvalidRequestMessage())
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+		sum := sha256.Sum256(body)
+		record, replay, err := models.BeginIdempotentRequest(user, key, c.Request.Method, c.Request.URL.Path, hex.EncodeToString(sum[:]))
+		switch err {
+		case nil:
+		case models.ErrIdempotencyKeyReused:
+			c.AbortWithStatusJSON(422, u.Message(false, err.Error()))
+			return
+		case models.ErrIdempotencyKeyInProgress:
+			c.AbortWithStatusJSON(409, u.Message(false, err.Error()))
+			return
+		default:
+			c.AbortWithStatusJSON(500, u.Message(false, err.Error()))
+			return
+		}
+
+		if replay {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.StatusCode, "application/json; charset=utf-8", []byte(record.Response))
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = recorder
+
+		completed := false
+		defer func() {
+			//The handler panicked or failed on our side. Let the client retry
+			if !completed {
+				models.ReleaseIdempotencyKey(record)
+			}
+		}()
+
+		c.Next()
+
+		if recorder.Status() >= 500 || failedResponse(recorder.body.Bytes()) {
+			return
+		}
+
+		err = models.CompleteIdempotentRequest(record, recorder.Status(), recorder.body.Bytes())
+		completed = err == nil
+	}
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"github.com/gin-gonic/gin"
+	"litepay/models"
+	u "litepay/util"
+)
+
+func TestFailedResponse(t *testing.T) {
+
+	cases := []struct {
+		body string
+		failed bool
+	}{
+		{`{"status":false,"message":"Cannot withdraw at this time. Please retry"}`, true},
+		{`{"message":"Too many requests. Please try again later","status":false}`, true},
+		{`{"status":true,"message":"success","data":{"status":false}}`, false},
+		{`{"message":"no status"}`, false},
+		{`not json`, false},
+		{``, false},
+	}
+
+	for _, c := range cases {
+		if failedResponse([]byte(c.body)) != c.failed {
+			t.Errorf("failedResponse(%s) = %v, want %v", c.body, !c.failed, c.failed)
+		}
+	}
+}
+
+//A request that failed runs again on retry with the same key. Once it succeeds, retries get its response
+func TestIdempotencyRetriesFailures(t *testing.T) {
+
+	if models.Db == nil || models.Db.DB().Ping() != nil {
+		t.Skip("DATABASE_URL does not point at a reachable database")
+	}
+
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user", uint(1))
+	}, IdempotencyMiddleWare())
+	router.POST("/pay", func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.JSON(200, u.Message(false, "Cannot pay at this time. Please retry"))
+			return
+		}
+		c.JSON(200, u.Message(true, fmt.Sprintf("paid on call %d", calls)))
+	})
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{"amount":"10"}`))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	send()
+	second := send()
+	third := send()
+
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want 2", calls)
+	}
+
+	if !strings.Contains(second.Body.String(), "paid on call 2") {
+		t.Errorf("retry after a failure answered %s", second.Body.String())
+	}
+
+	if third.Header().Get("Idempotent-Replayed") != "true" || third.Body.String() != second.Body.String() {
+		t.Errorf("retry after a success answered %s, want the stored response", third.Body.String())
+	}
+}
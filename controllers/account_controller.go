@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"litepay/models"
 	u "litepay/util"
+	"strconv"
 )
 
 var NewAccount = func(c *gin.Context) {
@@ -124,11 +125,35 @@ var GetWallet = func(c *gin.Context) {
 	}
 
 	wallet := models.GetWallet(account)
+	if wallet != nil {
+		wallet.Holds = models.GetActiveHolds(account)
+	}
+
 	r := u.Message(true, "success")
 	r["data"] = wallet
 	c.JSON(200, r)
 }
 
+var GetWalletStatement = func(c *gin.Context) {
+
+	user, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	account, ok := user . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	data := models.GetWalletStatement(account)
+	r := u.Message(true, "success")
+	r["data"] = data
+	c.JSON(200, r)
+}
+
 var AddCard = func(c *gin.Context) {
 
 	user, ok := c.Get("user")
@@ -143,21 +168,22 @@ var AddCard = func(c *gin.Context) {
 		return
 	}
 
-	card := &models.Card{}
-	err := c.ShouldBind(card)
+	payload := &models.CardPayload{}
+	err := c.ShouldBind(payload)
 	if err != nil {
 		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
 		return
 	}
 
-	card.Account = account
-	err = models.AddCard(card)
+	card, err := models.AddCard(account, payload)
 	if err != nil {
 		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
 		return
 	}
 
-	c.JSON(200, u.Message(true, "success"))
+	r := u.Message(true, "success")
+	r["data"] = card
+	c.JSON(200, r)
 }
 
 var GetCards = func(c *gin.Context) {
@@ -180,3 +206,134 @@ var GetCards = func(c *gin.Context) {
 	c.JSON(200, r)
 }
 
+var DeleteCard = func(c *gin.Context) {
+
+	user, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	account, ok := user . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	err = models.DeleteCard(account, uint(id))
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	c.JSON(200, u.Message(true, "success"))
+}
+
+var SetDefaultCard = func(c *gin.Context) {
+
+	user, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	account, ok := user . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	card, err := models.SetDefaultCard(account, uint(id))
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = card
+	c.JSON(200, r)
+}
+
+var RenameCard = func(c *gin.Context) {
+
+	user, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	account, ok := user . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	payload := &models.CardUpdatePayload{}
+	err = c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	card, err := models.RenameCard(account, uint(id), payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = card
+	c.JSON(200, r)
+}
+
+var SetHandle = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	payload := &struct {
+		Handle string `json:"handle"`
+	}{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	account, err := models.SetHandle(user, payload.Handle)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = account
+	c.JSON(200, r)
+}
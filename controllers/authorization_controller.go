@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"litepay/models"
+	u "litepay/util"
+	"strconv"
+)
+
+var GetAuthorizations = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	data := models.GetAuthorizationsFor(user)
+	r := u.Message(true, "success")
+	r["data"] = data
+	c.JSON(200, r)
+}
+
+var ChargeAuthorization = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	authId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	request := &models.WalletTopUpRequest{}
+	err = c.ShouldBind(request)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	amount, err := request.AmountValue()
+	if err != nil || !amount.IsPositive() {
+		c.AbortWithStatusJSON(200, u.Message(false, "Invalid amount"))
+		return
+	}
+
+	intent, err := models.ChargeAuthorization(user, uint(authId), amount)
+	if err != nil {
+		c.AbortWithStatusJSON(200, limitMessage(err))
+		return
+	}
+
+	message := "Wallet funded successfully"
+	if intent.Status == models.IntentPending {
+		message = "Your card is being charged. Your wallet will be funded once the charge completes"
+	}
+
+	r := u.Message(true, message)
+	r["data"] = intent
+	c.JSON(200, r)
+}
+
+var DeleteAuthorization = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	authId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	err = models.DeleteAuthorization(user, uint(authId))
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	c.JSON(200, u.Message(true, "success"))
+}
+
+var GetAutoTopUpRule = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = models.GetAutoTopUpRule(user)
+	c.JSON(200, r)
+}
+
+var SaveAutoTopUpRule = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	payload := &models.AutoTopUpRulePayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	rule, err := models.SaveAutoTopUpRule(user, payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = rule
+	c.JSON(200, r)
+}
+
+var DeleteAutoTopUpRule = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(403, u.UnAuthorizedMessage())
+		return
+	}
+
+	err := models.DeleteAutoTopUpRule(user)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	c.JSON(200, u.Message(true, "success"))
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	u "litepay/util"
+	"litepay/models"
+	"strconv"
+)
+
+var QuoteFee = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	payload := &models.FeeQuotePayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	amount, err := models.ParseMoney(payload.Amount, models.DefaultCurrency)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, "Invalid amount"))
+		return
+	}
+
+	quote, err := models.QuoteFee(payload.Operation, user, amount)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = quote
+	c.JSON(200, r)
+}
+
+var ApplyFeePromo = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	payload := &models.FeePromoPayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	enrollment, err := models.ApplyFeePromo(user, payload.Code)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = enrollment
+	c.JSON(200, r)
+}
+
+var GetFeeRules = func(c *gin.Context) {
+
+	r := u.Message(true, "success")
+	r["data"] = models.GetFeeRules()
+	c.JSON(200, r)
+}
+
+var CreateFeeRule = func(c *gin.Context) {
+
+	payload := &models.FeeRulePayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	rule, err := models.CreateFeeRule(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = rule
+	c.JSON(200, r)
+}
+
+var DeleteFeeRule = func(c *gin.Context) {
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	err = models.DeleteFeeRule(uint(id))
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	c.JSON(200, u.Message(true, "success"))
+}
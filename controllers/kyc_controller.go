@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	u "litepay/util"
+	"litepay/models"
+	"strconv"
+)
+
+var VerifyIdentity = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	payload := &models.VerifyIdentityPayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	verification, err := models.VerifyIdentity(user, payload)
+	if err != nil {
+		r := u.Message(false, err.Error())
+		if verification != nil {
+			r["data"] = verification
+		}
+		c.AbortWithStatusJSON(200, r)
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = verification
+	c.JSON(200, r)
+}
+
+var GetKycStatus = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = models.GetKycStatus(user)
+	c.JSON(200, r)
+}
+
+var GetAccountKycStatus = func(c *gin.Context) {
+
+	account, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	data := models.GetKycStatus(uint(account))
+	if data == nil {
+		c.AbortWithStatusJSON(200, u.Message(false, "Account not found"))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = data
+	c.JSON(200, r)
+}
+
+var DowngradeTier = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	admin, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	account, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	payload := &models.DowngradeTierPayload{}
+	err = c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	data, err := models.DowngradeTier(admin, uint(account), payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = data
+	c.JSON(200, r)
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	u "litepay/util"
+	"litepay/models"
+)
+
+//Error response for operations that can run into an account limit. A limit error carries what is left
+func limitMessage(err error) map[string] interface{} {
+
+	r := u.Message(false, err.Error())
+	if limit, ok := err.(*models.LimitError); ok {
+		r["limit"] = limit
+	}
+
+	return r
+}
+
+var GetLimits = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	data, err := models.GetLimits(user)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = data
+	c.JSON(200, r)
+}
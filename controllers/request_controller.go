@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	u "litepay/util"
+	"litepay/models"
+)
+
+var CreateMoneyRequest = func(c *gin.Context) {
+
+	payload := &models.MoneyRequestPayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	token, err := models.CreateMoneyRequest(user, payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = token
+	c.JSON(200, r)
+}
+
+var GetMoneyRequestInbox = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = models.GetMoneyRequestInbox(user)
+	c.JSON(200, r)
+}
+
+var GetSentMoneyRequests = func(c *gin.Context) {
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = models.GetSentMoneyRequests(user)
+	c.JSON(200, r)
+}
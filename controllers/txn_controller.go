@@ -4,8 +4,6 @@ import (
 	"github.com/gin-gonic/gin"
 	u "litepay/util"
 	"litepay/models"
-	"github.com/rpip/paystack-go"
-	"os"
 )
 
 var InitWalletTopUp = func(c *gin.Context) {
@@ -35,25 +33,21 @@ var InitWalletTopUp = func(c *gin.Context) {
 		return
 	}
 
-	txn := &paystack.TransactionRequest{}
-	txn.Amount = request.AmountValueInKobo()
-	txn.Email = account.Email
-
-	ps := paystack.NewClient(os.Getenv("PS_KEY"), nil)
-	response, err := ps.Transaction.Initialize(txn)
-	if err != nil {
-		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+	amount, err := request.AmountValue()
+	if err != nil || !amount.IsPositive() {
+		c.AbortWithStatusJSON(200, u.Message(false, "Invalid amount"))
 		return
 	}
 
-	accessCode, ok := response["access_code"] . (string)
-	if !ok {
-		c.AbortWithStatusJSON(200, u.Message(false, "Failed to top up wallet at this time. Please retry"))
+	intent, err := models.InitTopUp(account, amount)
+	if err != nil {
+		c.AbortWithStatusJSON(200, limitMessage(err))
 		return
 	}
 
 	r := u.Message(true, "success")
-	r["data"] = accessCode
+	r["data"] = intent.AccessCode
+	r["reference"] = intent.Reference
 	c.JSON(200, r)
 }
 
@@ -82,39 +76,25 @@ var VerifyTransaction = func(c *gin.Context) {
 		return
 	}
 
-	txn, err := models.VerifyTransaction(ref)
-	if err != nil {
-		c.AbortWithStatusJSON(200, u.Message(false, "Failed to verify transaction at this time. Please, retry"))
+	intent, err := models.VerifyTopUp(account.ID, ref)
+	if err == models.ErrUnknownTopUp {
+		c.AbortWithStatusJSON(400, u.Message(false, err.Error()))
 		return
 	}
 
-	txRef := models.GetTxRef(ref)
-	if txRef != nil {
-		c.AbortWithStatusJSON(400,u.Message(false, "Attempt to reuse an" +
-			" already used transaction reference"))
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
 		return
 	}
 
-	if txn.Status == "success" {
-		amountInNaira := txn.Amount / 100
-		models.FundAccount(ref, account, amountInNaira)
-
-		if txn.Authorization.Resusable {
-
-			auth := &models.AuthorizationCode{}
-			auth.Code = txn.Authorization.AuthorizationCode
-			auth.Email = account.Email
-
-			_ = models.CreateAuthorization(auth)
-		}
-	}
-
-	var message string = "Failed to verify transaction. Please retry"
-	if txn.Status == "success" {
-		message = "Transaction verification successful"
+	if intent.Status != models.IntentCompleted {
+		c.JSON(200, u.Message(false, "Failed to verify transaction. Please retry"))
+		return
 	}
 
-	c.JSON(200, u.Message(txn.Status == "success", message))
+	r := u.Message(true, "Transaction verification successful")
+	r["data"] = intent
+	c.JSON(200, r)
 }
 
 
@@ -131,7 +111,17 @@ var InitPay = func(c *gin.Context) {
 		return
 	}
 
-	token, err := models.CreateToken(id)
+	//GET creates an open token, POST one with limits
+	payload := &models.CreateTokenPayload{}
+	if c.Request.Method == "POST" {
+		err := c.ShouldBind(payload)
+		if err != nil {
+			c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+			return
+		}
+	}
+
+	token, err := models.CreateToken(id, payload)
 	if err != nil {
 		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
 		return
@@ -163,12 +153,18 @@ var Pay = func(c *gin.Context) {
 		return
 	}
 
-	err = models.RedeemToken(user, data.Token, data.AmountValue())
+	amount, err := data.AmountValue()
 	if err != nil {
 		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
 		return
 	}
 
+	err = models.RedeemToken(user, data.Token, amount)
+	if err != nil {
+		c.AbortWithStatusJSON(200, limitMessage(err))
+		return
+	}
+
 	c.JSON(200, u.Message(true, "success"))
 }
 
@@ -195,7 +191,7 @@ var AuthorizePayment = func(c *gin.Context) {
 
 	err = models.AuthorizePayment(user, payload)
 	if err != nil {
-		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		c.AbortWithStatusJSON(200, limitMessage(err))
 		return
 	}
 
@@ -221,3 +217,115 @@ var TxnHistory = func(c *gin.Context) {
 	r["data"] = data
 	c.JSON(200, data)
 }
+
+var DeclinePayment = func(c *gin.Context) {
+	closePayment(c, models.DeclineToken)
+}
+
+var CancelPayment = func(c *gin.Context) {
+	closePayment(c, models.CancelToken)
+}
+
+func closePayment(c *gin.Context, action func(uint, *models.CloseTokenPayload) (*models.TxToken, error)) {
+
+	payload := &models.CloseTokenPayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	token, err := action(user, payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = token
+	c.JSON(200, r)
+}
+
+var SendTransfer = func(c *gin.Context) {
+
+	payload := &models.TransferPayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	token, err := models.SendTransfer(user, payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, limitMessage(err))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = token
+	c.JSON(200, r)
+}
+
+var RefundPayment = func(c *gin.Context) {
+	refundPayment(c, models.RefundPayment)
+}
+
+var ForceReversal = func(c *gin.Context) {
+	refundPayment(c, models.ForceReversal)
+}
+
+func refundPayment(c *gin.Context, action func(uint, *models.RefundPayload) (*models.Refund, error)) {
+
+	payload := &models.RefundPayload{}
+	err := c.ShouldBind(payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.InvalidRequestMessage())
+		return
+	}
+
+	id, ok := c.Get("user")
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	user, ok := id . (uint)
+	if !ok {
+		c.AbortWithStatusJSON(200, u.UnAuthorizedMessage())
+		return
+	}
+
+	refund, err := action(user, payload)
+	if err != nil {
+		c.AbortWithStatusJSON(200, u.Message(false, err.Error()))
+		return
+	}
+
+	r := u.Message(true, "success")
+	r["data"] = refund
+	c.JSON(200, r)
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"litepay/models"
+	u "litepay/util"
+)
+
+var PaystackWebhook = func(c *gin.Context) {
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, u.InvalidRequestMessage())
+		return
+	}
+
+	if !models.VerifyPaystackSignature(body, c.GetHeader("x-paystack-signature")) {
+		c.AbortWithStatusJSON(401, u.Message(false, "Invalid signature"))
+		return
+	}
+
+	//A non 200 response makes paystack deliver the event again later
+	err = models.HandlePaystackWebhook(body)
+	if err != nil {
+		c.AbortWithStatusJSON(500, u.Message(false, err.Error()))
+		return
+	}
+
+	c.JSON(200, u.Message(true, "success"))
+}
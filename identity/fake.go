@@ -0,0 +1,66 @@
+package identity
+
+import (
+	"sync"
+)
+
+//In-memory IdentityProvider for tests and local development. It knows only the identities added to it
+type FakeProvider struct {
+	mu sync.Mutex
+	records map[string] *Identity
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		records: make(map[string] *Identity),
+	}
+}
+
+//A fake that knows one BVN and one NIN for "Test User", born 1990-01-01, so an account with that
+//name can reach the top tier locally
+func NewSeededFakeProvider() *FakeProvider {
+
+	fake := NewFakeProvider()
+	fake.AddIdentity(&Identity{IdType: IdBVN, Number: "22222222222", FirstName: "Test", LastName: "User",
+		DateOfBirth: "1990-01-01", Phone: "08000000000"})
+	fake.AddIdentity(&Identity{IdType: IdNIN, Number: "11111111111", FirstName: "Test", LastName: "User",
+		DateOfBirth: "1990-01-01", Phone: "08000000000"})
+
+	return fake
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+func fakeRecordKey(idType, number string) string {
+	return idType + ":" + number
+}
+
+func (f *FakeProvider) AddIdentity(identity *Identity) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	record := *identity
+	f.records[fakeRecordKey(identity.IdType, identity.Number)] = &record
+}
+
+func (f *FakeProvider) Lookup(idType, number string) (*Identity, error) {
+
+	err := ValidNumber(idType, number)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	record, ok := f.records[fakeRecordKey(idType, number)]
+	if !ok {
+		return nil, ErrIdentityNotFound
+	}
+
+	result := *record
+	return &result, nil
+}
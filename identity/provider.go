@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+//Kinds of national identity number
+const (
+	IdBVN = "bvn" //Bank Verification Number
+	IdNIN = "nin" //National Identification Number
+)
+
+//Layout of dates of birth everywhere in this package
+const DateLayout = "2006-01-02"
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+//Everything LitePay needs from an identity verification service
+type IdentityProvider interface {
+	Name() string
+	Lookup(idType, number string) (*Identity, error)
+}
+
+//A person as the BVN or NIN registry knows them
+type Identity struct {
+	IdType string `json:"id_type"`
+	Number string `json:"number"`
+	FirstName string `json:"first_name"`
+	MiddleName string `json:"middle_name"`
+	LastName string `json:"last_name"`
+	DateOfBirth string `json:"date_of_birth"`
+	Phone string `json:"phone"`
+}
+
+//Both BVNs and NINs are 11 digits
+func ValidNumber(idType, number string) error {
+
+	if idType != IdBVN && idType != IdNIN {
+		return fmt.Errorf("unknown identity type '%s'", idType)
+	}
+
+	if len(number) != 11 {
+		return fmt.Errorf("%s should be 11 digits", strings.ToUpper(idType))
+	}
+
+	for _, d := range number {
+		if d < '0' || d > '9' {
+			return fmt.Errorf("%s should be 11 digits", strings.ToUpper(idType))
+		}
+	}
+
+	return nil
+}
+
+//How well a claimed name and date of birth agree with a registry record
+type Match struct {
+	NameMatch bool `json:"name_match"`
+	DateOfBirthMatch bool `json:"date_of_birth_match"`
+}
+
+func nameParts(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+}
+
+//Compare a record with what the user told us. Names match when the record's first and last names are
+//both in the claimed name, in any order, since surnames are often written first. Middle names are ignored
+func Compare(record *Identity, fullname, dateOfBirth string) *Match {
+
+	claimed := make(map[string] bool)
+	for _, part := range nameParts(fullname) {
+		claimed[part] = true
+	}
+
+	first, last := nameParts(record.FirstName), nameParts(record.LastName)
+	match := &Match{}
+	match.NameMatch = len(first) > 0 && len(last) > 0
+	for _, part := range append(first, last...) {
+		if !claimed[part] {
+			match.NameMatch = false
+		}
+	}
+
+	match.DateOfBirthMatch = record.DateOfBirth != "" && record.DateOfBirth == strings.TrimSpace(dateOfBirth)
+	return match
+}
+
+//Build the provider named by IDENTITY_PROVIDER. There is no real registry behind it yet, so a fake has to
+//be asked for by name: "fake" knows no one, "fake-seeded" knows the test identities for local development
+func NewProviderFromEnv() (IdentityProvider, error) {
+
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("IDENTITY_PROVIDER")))
+	switch name {
+	case "":
+		return nil, errors.New("IDENTITY_PROVIDER is not set, identity verification is disabled")
+	case "fake":
+		return NewFakeProvider(), nil
+	case "fake-seeded":
+		return NewSeededFakeProvider(), nil
+	}
+
+	return nil, fmt.Errorf("unknown identity provider '%s'", name)
+}
@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+
+	record := &Identity{FirstName: "Adaeze", MiddleName: "Chioma", LastName: "Okafor", DateOfBirth: "1990-01-01"}
+	cases := []struct {
+		name string
+		fullname string
+		dob string
+		nameMatch bool
+		dobMatch bool
+	}{
+		{"exact", "Adaeze Okafor", "1990-01-01", true, true},
+		{"surname first", "Okafor Adaeze", "1990-01-01", true, true},
+		{"with middle name", "Adaeze Chioma Okafor", "1990-01-01", true, true},
+		{"other middle name", "Adaeze Ngozi Okafor", "1990-01-01", true, true},
+		{"case and punctuation", "  OKAFOR, adaeze ", "1990-01-01", true, true},
+		{"first name only", "Adaeze", "1990-01-01", false, true},
+		{"last name only", "Okafor", "1990-01-01", false, true},
+		{"first and middle", "Adaeze Chioma", "1990-01-01", false, true},
+		{"someone else", "Emeka Okafor", "1990-01-01", false, true},
+		{"part of a name", "Ada Okafor", "1990-01-01", false, true},
+		{"wrong date of birth", "Adaeze Okafor", "1990-01-02", true, false},
+		{"no date of birth", "Adaeze Okafor", "", true, false},
+		{"date of birth with spaces", "Adaeze Okafor", " 1990-01-01 ", true, true},
+	}
+
+	for _, c := range cases {
+		match := Compare(record, c.fullname, c.dob)
+		if match.NameMatch != c.nameMatch || match.DateOfBirthMatch != c.dobMatch {
+			t.Errorf("%s: name %v date of birth %v, want %v %v", c.name, match.NameMatch, match.DateOfBirthMatch,
+				c.nameMatch, c.dobMatch)
+		}
+	}
+}
+
+//A record missing a name or date of birth cannot match on it
+func TestCompareIncompleteRecord(t *testing.T) {
+
+	cases := []struct {
+		name string
+		record *Identity
+	}{
+		{"no first name", &Identity{LastName: "Okafor", DateOfBirth: ""}},
+		{"no last name", &Identity{FirstName: "Adaeze", DateOfBirth: ""}},
+		{"no names", &Identity{}},
+	}
+
+	for _, c := range cases {
+		match := Compare(c.record, "Adaeze Okafor", "")
+		if match.NameMatch || match.DateOfBirthMatch {
+			t.Errorf("%s: matched %+v", c.name, match)
+		}
+	}
+}
+
+func TestValidNumber(t *testing.T) {
+
+	cases := []struct {
+		idType string
+		number string
+		valid bool
+	}{
+		{IdBVN, "22222222222", true},
+		{IdNIN, "11111111111", true},
+		{IdBVN, "2222222222", false},
+		{IdBVN, "222222222222", false},
+		{IdNIN, "1111111111a", false},
+		{"passport", "22222222222", false},
+		{IdBVN, "", false},
+	}
+
+	for _, c := range cases {
+		if err := ValidNumber(c.idType, c.number); (err == nil) != c.valid {
+			t.Errorf("ValidNumber(%s, %s) = %v, want valid %v", c.idType, c.number, err, c.valid)
+		}
+	}
+}
+
+func TestNewProviderFromEnv(t *testing.T) {
+
+	previous, set := os.LookupEnv("IDENTITY_PROVIDER")
+	defer func() {
+		if set {
+			os.Setenv("IDENTITY_PROVIDER", previous)
+		} else {
+			os.Unsetenv("IDENTITY_PROVIDER")
+		}
+	}()
+
+	cases := []struct {
+		env string
+		ok bool
+		seeded bool
+	}{
+		{"", false, false},
+		{"fake", true, false},
+		{"FAKE-SEEDED", true, true},
+		{"registry", false, false},
+	}
+
+	for _, c := range cases {
+		os.Setenv("IDENTITY_PROVIDER", c.env)
+		provider, err := NewProviderFromEnv()
+		if (err == nil) != c.ok {
+			t.Errorf("IDENTITY_PROVIDER=%q returned %v", c.env, err)
+			continue
+		}
+
+		if !c.ok {
+			continue
+		}
+
+		_, err = provider.Lookup(IdBVN, "22222222222")
+		if (err == nil) != c.seeded {
+			t.Errorf("IDENTITY_PROVIDER=%q looked up the test BVN with %v, want seeded %v", c.env, err, c.seeded)
+		}
+	}
+}
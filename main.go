@@ -37,20 +37,70 @@ func main() {
 		}
 	})
 
+	r.POST("/webhooks/paystack", controllers.PaystackWebhook)
+
 	g := r.Group("/api")
 	g.POST("/user/new", controllers.NewAccount)
 	g.POST("/user/login", controllers.Authenticate)
-	g.POST("/txn/init", controllers.InitWalletTopUp)
+	g.POST("/txn/init", app.IdempotencyMiddleWare(), controllers.InitWalletTopUp)
 	g.GET("/txn/verify/:ref", controllers.VerifyTransaction)
 	g.POST("/me/pin/new", controllers.CreatePin)
 	g.POST("/me/pin/verify", controllers.VerifyPin)
 	g.GET("/me/payment/init", controllers.InitPay)
-	g.POST("/payment/recv", controllers.Pay)
-	g.POST("/payment/authorize", controllers.AuthorizePayment).Use(app.RateLimiterMiddleWare())
+	g.POST("/me/payment/init", controllers.InitPay)
+	g.POST("/payment/recv", app.IdempotencyMiddleWare(), controllers.Pay)
+	g.POST("/payment/authorize", app.IdempotencyMiddleWare(), controllers.AuthorizePayment).Use(app.RateLimiterMiddleWare())
+	g.POST("/payment/decline", controllers.DeclinePayment)
+	g.POST("/payment/cancel", controllers.CancelPayment)
+	g.POST("/payment/refund", app.IdempotencyMiddleWare(), controllers.RefundPayment)
+	g.POST("/transfers", app.IdempotencyMiddleWare(), controllers.SendTransfer)
+	g.POST("/me/handle", controllers.SetHandle)
+	g.POST("/requests", controllers.CreateMoneyRequest)
+	g.GET("/me/requests", controllers.GetMoneyRequestInbox)
+	g.GET("/me/requests/sent", controllers.GetSentMoneyRequests)
+	g.POST("/splits", app.IdempotencyMiddleWare(), controllers.CreateSplit)
+	g.GET("/me/splits", controllers.GetSplits)
+	g.GET("/splits/:id", controllers.GetSplit)
+	g.POST("/splits/:id/remind", controllers.RemindSplit)
+	g.POST("/me/schedules", controllers.CreateScheduledTransfer)
+	g.GET("/me/schedules", controllers.GetScheduledTransfers)
+	g.GET("/me/schedules/:id", controllers.GetScheduledTransfer)
+	g.POST("/me/schedules/:id", controllers.UpdateScheduledTransfer)
+	g.DELETE("/me/schedules/:id", controllers.DeleteScheduledTransfer)
+	g.GET("/banks/resolve", controllers.ResolveBankAccount)
+	g.POST("/me/beneficiaries", controllers.AddBeneficiary)
+	g.GET("/me/beneficiaries", controllers.GetBeneficiaries)
+	g.DELETE("/me/beneficiaries/:id", controllers.DeleteBeneficiary)
+	g.POST("/withdrawals", app.IdempotencyMiddleWare(), controllers.CreateWithdrawal)
+	g.GET("/me/withdrawals", controllers.GetWithdrawals)
+	g.GET("/me/withdrawals/:id", controllers.GetWithdrawal)
+	g.POST("/fees/quote", controllers.QuoteFee)
+	g.POST("/fees/promos", controllers.ApplyFeePromo)
+	g.GET("/me/authorizations", controllers.GetAuthorizations)
+	g.POST("/me/authorizations/:id/charge", app.IdempotencyMiddleWare(), controllers.ChargeAuthorization)
+	g.DELETE("/me/authorizations/:id", controllers.DeleteAuthorization)
+	g.GET("/me/topup/rule", controllers.GetAutoTopUpRule)
+	g.POST("/me/topup/rule", controllers.SaveAutoTopUpRule)
+	g.DELETE("/me/topup/rule", controllers.DeleteAutoTopUpRule)
 	g.GET("/me/txn/history", controllers.TxnHistory)
 	g.GET("/me/wallet", controllers.GetWallet)
+	g.GET("/me/wallet/statement", controllers.GetWalletStatement)
+	g.GET("/me/limits", controllers.GetLimits)
+	g.GET("/me/kyc", controllers.GetKycStatus)
+	g.POST("/me/kyc/verify", controllers.VerifyIdentity)
 	g.POST("/card/new", controllers.AddCard)
 	g.GET("/me/cards", controllers.GetCards)
+	g.DELETE("/me/cards/:id", controllers.DeleteCard)
+	g.POST("/me/cards/:id/default", controllers.SetDefaultCard)
+	g.POST("/me/cards/:id/nickname", controllers.RenameCard)
+
+	admin := r.Group("/api/admin", app.AdminMiddleWare())
+	admin.POST("/payment/reverse", app.IdempotencyMiddleWare(), controllers.ForceReversal)
+	admin.GET("/fees", controllers.GetFeeRules)
+	admin.POST("/fees", controllers.CreateFeeRule)
+	admin.DELETE("/fees/:id", controllers.DeleteFeeRule)
+	admin.GET("/accounts/:id/kyc", controllers.GetAccountKycStatus)
+	admin.POST("/accounts/:id/tier", controllers.DowngradeTier)
 
 	port := os.Getenv("PORT")
 	if port == "" {
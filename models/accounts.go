@@ -7,6 +7,8 @@ import (
 	u "litepay/util"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"regexp"
 )
 
 type Account struct {
@@ -14,6 +16,9 @@ type Account struct {
 	Email string `json:"email"`
 	Fullname string `json:"fullname"`
 	Phone string `json:"phone"`
+	Handle string `json:"handle" gorm:"index"`
+	IsAdmin bool `json:"-"`
+	Tier int `json:"tier"`
 	Password string `json:"password"`
 	Token string `sql:"-" gorm:"-" json:"token"`
 }
@@ -107,48 +112,105 @@ func AuthenticateUser(email, password string) (*Account, error) {
 	return account, nil
 }
 
-func FundAccount(ref string, user *Account, amount float32) (error) {
+//Credit a top up of amount paid in under ref, less fee
+func FundAccount(ref string, user *Account, amount, fee Money) (error) {
 
-	if amount <= 0 {
+	if !amount.IsPositive() {
 		return errors.New("Amount should be > 0")
 	}
 
-	wallet := GetWallet(user.ID)
-	if wallet != nil {
-		wallet.Balance += float64(amount)
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return err
+	}
 
-		tx := Db.Begin()
-		err := tx.Error
-		if err != nil {
-			return err
-		}
+	wallet, err := lockWallet(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-		err = tx.Table("wallets").Where("user_id = ?", user.ID).Update(wallet).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+	//The unique index on ps_tx_ref makes a second credit for the same reference fail here
+	txRef := &TxRef{}
+	txRef.PsTxRef = ref
+	txRef.Amount = amount
+	txRef.UserId = user.ID
+
+	err = tx.Create(txRef).Error
+	if isUniqueViolation(err) {
+		tx.Rollback()
+		return ErrTxRefUsed
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-		txRef := &TxRef{}
-		txRef.PsTxRef = ref
-		txRef.Amount = amount
-		txRef.UserId = user.ID
+	//The money has been paid, so it cannot be turned away. Past the account's limits it is parked to be
+	//refunded and the reference is spent all the same
+	err = checkInflowLimits(tx, user.ID, wallet, amount, NewMoney(0, amount.Currency))
+	if limit, ok := err.(*LimitError); ok {
+		fmt.Printf("Top up %s of %s for user %d is past their limits and held for refund. %s\n",
+			ref, amount, user.ID, limit.Error())
+		err = holdTopUp(tx, ref, amount, limit.Error())
+		if err == nil {
+			err = tx.Commit().Error
+		}
 
-		err = tx.Create(txRef).Error
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 
-		tx.Commit()
 		mail := &MailRequest{}
-		mail.Body = "Your account has been funded successfully. Amount = " + fmt.Sprintf("%2f", amount)
-		mail.Subject = "LitePay - Account Funded"
+		mail.Body = fmt.Sprintf("Your payment of %s (%s) was not added to your wallet. %s. It will be refunded to you.",
+			amount, ref, limit.Error())
+		mail.Subject = "LitePay - Top Up Held"
 		mail.To = user.Email
 
 		MailQueue <- mail
+		return ErrTopUpHeld
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = creditWallet(tx, wallet, amount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = postTopUp(tx, wallet, ref, amount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = chargeFee(tx, wallet, ref, "Top up fee", fee)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+
+	mail := &MailRequest{}
+	mail.Body = "Your account has been funded successfully. Amount = " + amount.String()
+	if fee.IsPositive() {
+		mail.Body += ". Fee = " + fee.String()
 	}
+	mail.Subject = "LitePay - Account Funded"
+	mail.To = user.Email
 
+	MailQueue <- mail
 	return nil
 }
 
@@ -159,10 +221,6 @@ func AuthorizePayment(user uint, payload *AuthorizePaymentPayload) error {
 		return errors.New(fmt.Sprintf("Token %s not found", payload.Token))
 	}
 
-	if token.Status == 1 {
-		return errors.New("Token has already been redeemed")
-	}
-
 	if user != token.UserId { //Someone else tried to authorize a token that doesn't belong to them
 		return errors.New("unAuthorized")
 	}
@@ -172,49 +230,154 @@ func AuthorizePayment(user uint, payload *AuthorizePaymentPayload) error {
 		return err
 	}
 
-	userWallet := GetWallet(token.User.ID)
-	recvWallet := GetWallet(token.Recv.ID)
-
-	if userWallet.Balance < token.Amount {
-		return errors.New("Insufficient funds")
-	}
-
-	//locked area
+	//locked area. The token is locked before the wallets, and re-read, so that status,
+	//amount and payee cannot change between the checks below and the commit
 	tx := Db.Begin()
 	err = tx.Error
 	if err != nil {
 		return err
 	}
 
-	userWallet.Balance -= token.Amount
-	recvWallet.Balance += token.Amount
-
-	err = tx.Table("wallets").Where("id = ?", userWallet.ID).UpdateColumn("balance",
-		userWallet.Balance).Error
-
+	locked, err := lockToken(tx, token.Token)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	err = tx.Table("wallets").Where("id = ?", recvWallet.ID).UpdateColumn("balance",
-		recvWallet.Balance).Error
+	expired, err := checkTokenExpiry(tx, locked)
+	if err == ErrTokenExpired {
+		commitExpiry(tx, locked, expired)
+		return err
+	}
 
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	err = tx.Table("tx_tokens").Where("token = ?", token.Token).UpdateColumn("status", 1).Error
+	err = settleToken(tx, locked, user, "")
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	tx.Commit()
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsTokenAuthorized
+	wsMessage.Account = GetAccount(user)
+	wsMessage.Amount = locked.Amount
+	wsMessage.Token = locked.Token
+	SendWsMessageTo(locked.RecvBy, wsMessage)
+	notifySplitProgress(locked)
+
+	go TriggerAutoTopUp(locked.UserId)
 	return nil
 }
 
+//Move the amount of a claimed token, locked in tx, from the payer's wallet to the payee's and mark it authorized
+func settleToken(tx *gorm.DB, locked *TxToken, actor uint, reason string) error {
+
+	if !CanTransition(locked.Status, TokenAuthorized) {
+		return &TransitionError{Token: locked.Token, From: locked.Status, To: TokenAuthorized}
+	}
+
+	wallets, err := lockWallets(tx, locked.UserId, locked.RecvBy)
+	if err != nil {
+		return err
+	}
+
+	userWallet, recvWallet := wallets[locked.UserId], wallets[locked.RecvBy]
+	err = checkOutflowLimits(tx, locked.UserId, userWallet, locked.Amount)
+	if err != nil {
+		return err
+	}
+
+	err = checkInflowLimits(tx, locked.RecvBy, recvWallet, locked.Amount, NewMoney(0, locked.Amount.Currency))
+	if err != nil {
+		return err
+	}
+
+	//Money a claim set aside was promised to the payee, so it is taken even if a forced reversal has
+	//since left the wallet short of it. Claims from before holds existed need the money to be there
+	held, err := hasActiveHold(tx, locked)
+	if err != nil {
+		return err
+	}
+
+	debit, charge := debitWallet, chargeFee
+	if held {
+		debit, charge = overdrawWallet, chargeHeldFee
+	}
+
+	//Authorizing captures the claim's hold, which frees the money for the debit below
+	err = transitionToken(tx, locked, TokenAuthorized, actor, reason, nil)
+	if err != nil {
+		return err
+	}
+
+	err = debit(tx, userWallet, locked.Amount)
+	if err != nil {
+		return err
+	}
+
+	err = creditWallet(tx, recvWallet, locked.Amount)
+	if err != nil {
+		return err
+	}
+
+	err = postPayment(tx, userWallet, recvWallet, locked.Token, locked.Amount)
+	if err != nil {
+		return err
+	}
+
+	//Tokens from before fees were quoted at claim time
+	fee := locked.Fee
+	if fee.Currency == "" {
+		fee = feeFor(FeeTransfer, locked.UserId, locked.Amount)
+	}
+
+	return charge(tx, userWallet, locked.Token, "Payment fee", fee)
+}
+
+//Money paid in through paystack sits in our paystack float and is owed to the wallet owner
+func postTopUp(tx *gorm.DB, wallet *Wallet, ref string, amount Money) error {
+
+	float, err := GetSystemAccount(tx, PaystackFloatAccount)
+	if err != nil {
+		return err
+	}
+
+	account, err := GetWalletLedgerAccount(tx, wallet)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryTopUp, ref, "Wallet top up")
+	entry.Debit(float, amount).Credit(account, amount)
+	return PostJournalEntry(tx, entry)
+}
+
+func postPayment(tx *gorm.DB, from, to *Wallet, ref string, amount Money) error {
+
+	payer, err := GetWalletLedgerAccount(tx, from)
+	if err != nil {
+		return err
+	}
+
+	payee, err := GetWalletLedgerAccount(tx, to)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryPayment, ref, "Payment")
+	entry.Debit(payer, amount).Credit(payee, amount)
+	return PostJournalEntry(tx, entry)
+}
+
 func GetAccount(user uint) (*Account) {
 
 	account := &Account{}
@@ -223,24 +386,87 @@ func GetAccount(user uint) (*Account) {
 		return nil
 	}
 
+	account.Password = "" //Accounts looked up here end up in responses and websocket messages
 	return account
 }
 
-type AuthorizationCode struct {
-	gorm.Model
-	Code string `json:"code"`
-	Email string `json:"email"`
+//Find an account by @handle, email address, phone number, handle or user id
+func FindAccount(identifier string) *Account {
+
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(identifier, "@") {
+		return findAccountBy("handle = ?", strings.ToLower(identifier[1:]))
+	}
+
+	if strings.Contains(identifier, "@") {
+		return findAccountBy("LOWER(email) = LOWER(?)", identifier)
+	}
+
+	account := findAccountBy("phone = ?", identifier)
+	if account == nil {
+		account = findAccountBy("handle = ?", strings.ToLower(identifier))
+	}
+
+	if account == nil {
+		id, err := strconv.ParseUint(identifier, 10, 32)
+		if err == nil {
+			account = GetAccount(uint(id))
+		}
+	}
+
+	return account
 }
 
-func CreateAuthorization(auth *AuthorizationCode) error {
+func findAccountBy(query string, value string) *Account {
 
-	temp := &AuthorizationCode{}
-	err := Db.Table("authorization_codes").Where("email = ?", auth.Email).First(temp).Error
-	if err == gorm.ErrRecordNotFound {
-		return Db.Create(auth).Error
+	account := &Account{}
+	err := Db.Table("accounts").Where(query, value).First(account).Error
+	if err != nil {
+		return nil
 	}
 
-	return Db.Table("authorization_codes").Where("email = ?", auth.Email).UpdateColumn("code", auth.Code).Error
+	account.Password = ""
+	return account
+}
+
+//Handles start with a letter so they can never be mistaken for a phone number or user id
+var handleRegexp = regexp.MustCompile("^[a-z][a-z0-9_]{2,19}$")
+
+//Give an account the handle others can send money to, e.g @ada
+func SetHandle(user uint, handle string) (*Account, error) {
+
+	handle = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+	if !handleRegexp.MatchString(handle) {
+		return nil, errors.New("A handle should be 3 to 20 letters, digits or underscores, starting with a letter")
+	}
+
+	account := GetAccount(user)
+	if account == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	owner := findAccountBy("handle = ?", handle)
+	if owner != nil && owner.ID != user {
+		return nil, errors.New(fmt.Sprintf("@%s is already taken", handle))
+	}
+
+	//The unique index catches two users racing for the same handle
+	err := Db.Table("accounts").Where("id = ?", user).UpdateColumn("handle", handle).Error
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("@%s is already taken", handle))
+	}
+
+	account.Handle = handle
+	return account, nil
+}
+
+//Handles are optional, so only the ones that are set have to be unique
+func MigrateAccountHandles() error {
+	return Db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS uix_accounts_handle ON accounts (handle) WHERE handle <> ''").Error
 }
 
 type Pin struct {
@@ -291,36 +517,3 @@ func VerifyPin(user uint, code string) (error) {
 
 	return nil
 }
-
-type Card struct {
-	gorm.Model
-	Account uint `json:"account"`
-	CardNo string `json:"card_no"`
-	Cvv string `json:"cvv"`
-	ExpiryMonth string `json:"expiry_month"`
-	ExpiryYear string `json:"expiry_year"`
-}
-
-func AddCard(card *Card) error {
-
-	if card.Account <= 0 {
-		return errors.New("Account not found")
-	}
-
-	if len(card.Cvv) == 0 {
-		return errors.New("Invalid cvv")
-	}
-
-	return Db.Create(card).Error
-}
-
-func GetCardsFor(user uint) []*Card {
-
-	data := make([]*Card, 0)
-	err := Db.Table("cards").Where("account = ?", user).Find(&data).Error
-	if err != nil {
-		return nil
-	}
-
-	return data
-}
\ No newline at end of file
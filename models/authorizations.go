@@ -0,0 +1,193 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"litepay/payments"
+	"fmt"
+)
+
+var ErrAuthorizationNotFound = errors.New("Saved card not found")
+
+//A reusable card authorization returned by the payment provider after a successful charge.
+//The code itself never leaves the server, clients only see the masked card details
+type AuthorizationCode struct {
+	gorm.Model
+	UserId uint `json:"user_id"`
+	Code string `json:"-"`
+	Email string `json:"email"`
+	Signature string `json:"-"`
+	Bin string `json:"-"`
+	Last4 string `json:"last4"`
+	ExpMonth string `json:"exp_month"`
+	ExpYear string `json:"exp_year"`
+	Brand string `json:"brand"`
+	CardType string `json:"card_type"`
+	Bank string `json:"bank"`
+	MaskedPan string `sql:"-" gorm:"-" json:"masked_pan"`
+}
+
+func (auth *AuthorizationCode) mask() *AuthorizationCode {
+
+	bin := auth.Bin
+	if len(bin) > 6 {
+		bin = bin[:6]
+	}
+
+	auth.MaskedPan = fmt.Sprintf("%s******%s", bin, auth.Last4)
+	return auth
+}
+
+//Save the authorization from a successful charge. The same card charged again replaces its old code
+func CreateAuthorization(auth *AuthorizationCode) error {
+
+	if auth.Code == "" || auth.UserId <= 0 {
+		return errors.New("Invalid authorization")
+	}
+
+	temp := &AuthorizationCode{}
+	query := Db.Table("authorization_codes").Where("user_id = ?", auth.UserId)
+	if auth.Signature != "" {
+		query = query.Where("signature = ?", auth.Signature)
+	} else {
+		query = query.Where("code = ?", auth.Code)
+	}
+
+	err := query.First(temp).Error
+	if err == gorm.ErrRecordNotFound {
+		return Db.Create(auth).Error
+	}
+
+	if err != nil {
+		return err
+	}
+
+	auth.ID = temp.ID
+	return Db.Table("authorization_codes").Where("id = ?", temp.ID).UpdateColumns(map[string] interface{} {
+		"code" : auth.Code, "exp_month" : auth.ExpMonth, "exp_year" : auth.ExpYear}).Error
+}
+
+func saveAuthorization(account *Account, a payments.Authorization) error {
+
+	if !a.Reusable || a.AuthorizationCode == "" {
+		return nil
+	}
+
+	auth := &AuthorizationCode{}
+	auth.UserId = account.ID
+	auth.Email = account.Email
+	auth.Code = a.AuthorizationCode
+	auth.Signature = a.Signature
+	auth.Bin = a.Bin
+	auth.Last4 = a.Last4
+	auth.ExpMonth = a.ExpMonth
+	auth.ExpYear = a.ExpYear
+	auth.Brand = a.Brand
+	auth.CardType = a.CardType
+	auth.Bank = a.Bank
+
+	return CreateAuthorization(auth)
+}
+
+func GetAuthorizationsFor(user uint) []*AuthorizationCode {
+
+	data := make([]*AuthorizationCode, 0)
+	err := Db.Table("authorization_codes").Where("user_id = ? AND deleted_at IS NULL", user).Order("id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	for _, auth := range data {
+		auth.mask()
+	}
+
+	return data
+}
+
+func GetAuthorization(user, id uint) *AuthorizationCode {
+
+	auth := &AuthorizationCode{}
+	err := Db.Table("authorization_codes").Where("id = ? AND user_id = ? AND deleted_at IS NULL", id, user).First(auth).Error
+	if err != nil {
+		return nil
+	}
+
+	return auth.mask()
+}
+
+func DeleteAuthorization(user, id uint) error {
+
+	auth := GetAuthorization(user, id)
+	if auth == nil {
+		return ErrAuthorizationNotFound
+	}
+
+	return Db.Delete(auth).Error
+}
+
+//Top up a wallet by charging a saved card. The charge goes through the same intent, verification and
+//FundAccount path as a checkout top up. A charge the provider is still processing is left pending and
+//completed by the charge.success webhook. So is a charge we could not confirm, which is why it is answered
+//as pending rather than failed: a client retrying a failure would charge the card a second time
+func ChargeAuthorization(user, id uint, amount Money) (*TopUpIntent, error) {
+
+	intent, err := chargeAuthorization(user, id, amount, TopUpSavedCard)
+	if err != nil && intent != nil && intent.Status == IntentPending {
+		return intent, nil
+	}
+
+	return intent, err
+}
+
+func chargeAuthorization(user, id uint, amount Money, source string) (*TopUpIntent, error) {
+
+	account := GetAccount(user)
+	if account == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	auth := GetAuthorization(user, id)
+	if auth == nil {
+		return nil, ErrAuthorizationNotFound
+	}
+
+	intent, err := CreateTopUpIntent(user, amount, source)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &payments.ChargeRequest{}
+	req.Reference = intent.Reference
+	req.Email = account.Email
+	req.AuthorizationCode = auth.Code
+	req.Amount = amount.Kobo
+	req.Currency = amount.Currency
+
+	txn, err := Provider.ChargeAuthorization(req)
+	if payments.IsRejected(err) {
+		fmt.Println(err)
+		setTopUpStatus(intent, IntentFailed, "Charge failed")
+		return intent, errors.New("Failed to charge card at this time. Please retry")
+	}
+
+	//The card may have been charged. The intent stays pending for the charge.success webhook or a verify to settle
+	if err != nil {
+		fmt.Printf("Charge for top up %s could not be confirmed. %s\n", intent.Reference, err.Error())
+		return intent, errors.New("Could not confirm the charge. Your wallet will be credited if it went through")
+	}
+
+	if txn.Status == payments.StatusPending {
+		return intent, nil
+	}
+
+	err = CompleteTopUp(intent, txn)
+	if err != nil {
+		if intent.Status == IntentFailed {
+			return intent, errors.New(fmt.Sprintf("Card ending in %s was declined", auth.Last4))
+		}
+
+		return intent, err
+	}
+
+	return intent, nil
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+)
+
+//A charge that may have gone through is reported as pending, so the client does not charge again
+func TestUnconfirmedChargeIsPending(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 0)
+	auth := newTestCard(t, account)
+
+	Provider = &unreachableCharges{fake}
+	intent, err := ChargeAuthorization(account.ID, auth.ID, naira(500))
+	if err != nil {
+		t.Fatalf("unconfirmed charge returned %v, want it pending", err)
+	}
+
+	if intent.Status != IntentPending {
+		t.Errorf("unconfirmed charge is %s, want pending", intent.Status)
+	}
+
+	if balance := balanceOf(t, account.ID); balance != 0 {
+		t.Errorf("wallet credited %d before the charge was confirmed", balance)
+	}
+}
+
+func TestDeclinedChargeFails(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 0)
+	auth := newTestCard(t, account)
+
+	fake.DeclineAuthorization(auth.Code)
+	intent, err := ChargeAuthorization(account.ID, auth.ID, naira(500))
+	if err == nil || intent == nil || intent.Status != IntentFailed {
+		t.Errorf("declined charge returned %v, want a failed intent", err)
+	}
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"time"
+	"fmt"
+)
+
+//Consecutive declines after which a rule turns itself off
+const MaxAutoTopUpFailures = 3
+
+//A charge that has not finished after this long is assumed dead, so the rule can fire again
+const autoTopUpLease = 5 * time.Minute
+
+//"When my wallet drops below Threshold, top up Amount from saved card AuthorizationId",
+//never charging more than DailyCap in a day. A user has at most one rule
+type AutoTopUpRule struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"unique_index"`
+	AuthorizationId uint `json:"authorization_id"`
+	Threshold Money `json:"threshold" gorm:"embedded;embedded_prefix:threshold_"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	DailyCap Money `json:"daily_cap" gorm:"embedded;embedded_prefix:daily_cap_"`
+	Enabled bool `json:"enabled"`
+	ChargingSince *time.Time `json:"-"`
+	Failures int `json:"failures"`
+	DisabledReason string `json:"disabled_reason"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at"`
+}
+
+type AutoTopUpRulePayload struct {
+	AuthorizationId uint `json:"authorization_id"`
+	Threshold json.Number `json:"threshold"`
+	Amount json.Number `json:"amount"`
+	DailyCap json.Number `json:"daily_cap"`
+}
+
+//Create or replace the auto top up rule of a user
+func SaveAutoTopUpRule(user uint, payload *AutoTopUpRulePayload) (*AutoTopUpRule, error) {
+
+	if GetAuthorization(user, payload.AuthorizationId) == nil {
+		return nil, ErrAuthorizationNotFound
+	}
+
+	threshold, err := ParseMoney(payload.Threshold, DefaultCurrency)
+	if err != nil || !threshold.IsPositive() {
+		return nil, errors.New("Invalid threshold")
+	}
+
+	amount, err := ParseMoney(payload.Amount, DefaultCurrency)
+	if err != nil || !amount.IsPositive() {
+		return nil, errors.New("Invalid top up amount")
+	}
+
+	dailyCap := amount
+	if payload.DailyCap != "" {
+		dailyCap, err = ParseMoney(payload.DailyCap, DefaultCurrency)
+		if err != nil || dailyCap.LessThan(amount) {
+			return nil, errors.New("Daily cap should be at least the top up amount")
+		}
+	}
+
+	rule := GetAutoTopUpRule(user)
+	if rule == nil {
+		rule = &AutoTopUpRule{}
+		rule.UserId = user
+	}
+
+	rule.AuthorizationId = payload.AuthorizationId
+	rule.Threshold = threshold
+	rule.Amount = amount
+	rule.DailyCap = dailyCap
+	rule.Enabled = true
+	rule.Failures = 0
+	rule.DisabledReason = ""
+
+	//The lease belongs to a charge that may be running, so saving the rule leaves it alone
+	err = Db.Omit("charging_since").Save(rule).Error
+	if err != nil {
+		return nil, errors.New("Failed to save top up rule at this time. Please retry")
+	}
+
+	return rule, nil
+}
+
+func GetAutoTopUpRule(user uint) *AutoTopUpRule {
+
+	rule := &AutoTopUpRule{}
+	err := Db.Table("auto_top_up_rules").Where("user_id = ? AND deleted_at IS NULL", user).First(rule).Error
+	if err != nil {
+		return nil
+	}
+
+	return rule
+}
+
+func DeleteAutoTopUpRule(user uint) error {
+
+	rule := GetAutoTopUpRule(user)
+	if rule == nil {
+		return errors.New("No top up rule found")
+	}
+
+	return Db.Unscoped().Delete(rule).Error
+}
+
+//Total of today's automatic top ups that succeeded or may still succeed
+func autoTopUpsToday(user uint) (Money, error) {
+
+	type result struct {
+		Total int64
+	}
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	r := &result{}
+	err := Db.Table("top_up_intents").Select("COALESCE(SUM(amount_kobo), 0) AS total").
+		Where("user_id = ? AND source = ? AND status IN (?) AND created_at >= ?",
+		user, TopUpAuto, []string {IntentPending, IntentCompleted}, midnight).Scan(r).Error
+
+	return Kobo(r.Total), err
+}
+
+//Run the user's rule after their wallet has been debited. Call it in a goroutine, it talks to the payment provider
+func TriggerAutoTopUp(user uint) {
+
+	rule := GetAutoTopUpRule(user)
+	if rule == nil || !rule.Enabled {
+		return
+	}
+
+	wallet := GetWallet(user)
+	if wallet == nil || !wallet.Balance.SameCurrency(rule.Threshold) || !wallet.Available.LessThan(rule.Threshold) {
+		return
+	}
+
+	//Claim the rule so that debits landing close together charge the card once. The claim is a lease,
+	//so a charge cut short by a restart does not keep the rule claimed for good
+	claimed := time.Now()
+	r := Db.Table("auto_top_up_rules").Where("id = ? AND (charging_since IS NULL OR charging_since < ?)",
+		rule.ID, claimed.Add(-autoTopUpLease)).UpdateColumn("charging_since", claimed)
+	if r.Error != nil || r.RowsAffected != 1 {
+		return
+	}
+	defer Db.Table("auto_top_up_rules").Where("id = ? AND charging_since = ?", rule.ID, claimed).
+		UpdateColumn("charging_since", gorm.Expr("NULL"))
+
+	today, err := autoTopUpsToday(user)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if today.Add(rule.Amount).GreaterThan(rule.DailyCap) {
+		return
+	}
+
+	now := time.Now()
+	Db.Table("auto_top_up_rules").Where("id = ?", rule.ID).UpdateColumn("last_triggered_at", now)
+
+	intent, err := chargeAuthorization(user, rule.AuthorizationId, rule.Amount, TopUpAuto)
+	if err == nil {
+		Db.Table("auto_top_up_rules").Where("id = ?", rule.ID).UpdateColumn("failures", 0)
+		return
+	}
+
+	//Only a card that was declined, or has been removed, counts against the rule. Limits, a held top up
+	//and a charge whose outcome is not known yet say nothing about the card. A pending charge is settled
+	//by the charge.success webhook or a verify
+	declined := intent != nil && intent.Status == IntentFailed
+	if !declined && err != ErrAuthorizationNotFound {
+		fmt.Printf("Auto top up for user %d did not go through. %s\n", user, err.Error())
+		return
+	}
+
+	account := GetAccount(user)
+	if account == nil {
+		return
+	}
+
+	rule.Failures += 1
+	reason := err.Error()
+	if err == ErrAuthorizationNotFound {
+		rule.Failures = MaxAutoTopUpFailures
+		reason = "The saved card for this rule was removed"
+	}
+
+	updates := map[string] interface{} {"failures" : rule.Failures}
+	if rule.Failures >= MaxAutoTopUpFailures {
+		updates["enabled"] = false
+		updates["disabled_reason"] = reason
+	}
+	Db.Table("auto_top_up_rules").Where("id = ?", rule.ID).UpdateColumns(updates)
+
+	ref := ""
+	if intent != nil {
+		ref = intent.Reference
+	}
+	fmt.Printf("Auto top up %s for user %d failed. %s\n", ref, user, reason)
+
+	mail := &MailRequest{}
+	mail.Subject = "LitePay - Automatic Top Up Failed"
+	mail.Body = fmt.Sprintf("We could not top up your wallet with %s. %s", rule.Amount, reason)
+	if rule.Failures >= MaxAutoTopUpFailures {
+		mail.Body += ". Your automatic top up has been turned off. Update it in the app to turn it back on"
+	}
+	mail.To = account.Email
+	mail.Name = account.Fullname
+
+	MailQueue <- mail
+}
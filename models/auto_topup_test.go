@@ -0,0 +1,113 @@
+package models
+
+import (
+	"litepay/payments"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+//A fake provider whose charges never come back
+type unreachableCharges struct {
+	*payments.FakeProvider
+}
+
+func (p *unreachableCharges) ChargeAuthorization(req *payments.ChargeRequest) (*payments.Transaction, error) {
+	return nil, errors.New("dial tcp: i/o timeout")
+}
+
+//Save a card for account the way a successful checkout would
+func newTestCard(t *testing.T, account *Account) *AuthorizationCode {
+
+	t.Helper()
+	err := saveAuthorization(account, payments.FakeAuthorization("TEST-" + GenUniqueKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auths := GetAuthorizationsFor(account.ID)
+	if len(auths) == 0 {
+		t.Fatal("card was not saved")
+	}
+
+	return auths[0]
+}
+
+//An empty wallet with a saved card and a rule topping it up by amount naira
+func newTestRule(t *testing.T, amount string) (*Account, *AuthorizationCode) {
+
+	t.Helper()
+	account := newTestAccount(t, 0)
+	auth := newTestCard(t, account)
+
+	_, err := SaveAutoTopUpRule(account.ID, &AutoTopUpRulePayload{AuthorizationId: auth.ID,
+		Threshold: "1000", Amount: json.Number(amount), DailyCap: "1000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account, auth
+}
+
+func requireRule(t *testing.T, user uint, enabled bool, failures int) {
+
+	t.Helper()
+	rule := GetAutoTopUpRule(user)
+	if rule == nil {
+		t.Fatal("rule not found")
+	}
+
+	if rule.Enabled != enabled || rule.Failures != failures {
+		t.Errorf("rule enabled %v with %d failures, want enabled %v with %d", rule.Enabled, rule.Failures, enabled, failures)
+	}
+}
+
+func TestAutoTopUpIgnoresUnknownOutcomes(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account, _ := newTestRule(t, "500")
+
+	Provider = &unreachableCharges{fake}
+	for i := 0; i < MaxAutoTopUpFailures + 1; i++ {
+		TriggerAutoTopUp(account.ID)
+	}
+
+	requireRule(t, account.ID, true, 0)
+}
+
+func TestAutoTopUpIgnoresLimits(t *testing.T) {
+
+	requireDb(t)
+	useFakeProvider(t)
+	account, _ := newTestRule(t, "30000")
+
+	//Past tier 0's daily inflow, so every charge is refused before the card is tried
+	err := Db.Table("accounts").Where("id = ?", account.ID).UpdateColumn("tier", 0).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < MaxAutoTopUpFailures + 1; i++ {
+		TriggerAutoTopUp(account.ID)
+	}
+
+	requireRule(t, account.ID, true, 0)
+}
+
+func TestAutoTopUpTurnsOffAfterDeclines(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account, auth := newTestRule(t, "500")
+
+	fake.DeclineAuthorization(auth.Code)
+	for i := 0; i < MaxAutoTopUpFailures - 1; i++ {
+		TriggerAutoTopUp(account.ID)
+	}
+
+	requireRule(t, account.ID, true, MaxAutoTopUpFailures - 1)
+
+	TriggerAutoTopUp(account.ID)
+	requireRule(t, account.ID, false, MaxAutoTopUpFailures)
+}
@@ -14,11 +14,15 @@ import (
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 	"github.com/dgrijalva/jwt-go"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
-	"github.com/rpip/paystack-go"
+	"github.com/lib/pq"
+	"litepay/payments"
+	"litepay/identity"
 )
 
 var (
 	Db *gorm.DB
+	Provider payments.PaymentProvider
+	IdentityVerifier identity.IdentityProvider
 	SmsQueue = make(chan *SmsRequest, 10)
 	MailQueue = make(chan *MailRequest, 10)
 )
@@ -38,11 +42,86 @@ func init() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	provider, err := payments.NewProviderFromEnv()
+	if err != nil {
+		fmt.Println(err)
+		provider = payments.NewPaystackProvider(os.Getenv("PS_KEY"))
+	}
+	Provider = provider
+
+	//Without a verifier accounts stay at their tier rather than being checked against a fake
+	verifier, err := identity.NewProviderFromEnv()
+	if err != nil {
+		fmt.Println(err)
+	}
+	IdentityVerifier = verifier
+
 	Db = conn
 	Db.Debug().AutoMigrate(&Account{}, &TxToken{},
-	&Wallet{}, &Pin{}, &Card{}, &TxRef{})
+	&Wallet{}, &Pin{}, &Card{}, &TxRef{},
+	&LedgerAccount{}, &JournalEntry{}, &JournalLine{}, &IdempotencyKey{},
+	&TopUpIntent{}, &WebhookEvent{}, &AuthorizationCode{},
+	&AutoTopUpRule{}, &TxTokenTransition{}, &Split{}, &SplitShare{},
+	&ScheduledTransfer{}, &Hold{}, &Refund{},
+	&BankBeneficiary{}, &Withdrawal{}, &FeeRule{}, &FeePromoEnrollment{},
+	&IdentityVerification{}, &TierChange{})
+
+	err = MigrateMoneyColumns()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = MigrateTokenStatuses()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = OpenLedgerForExistingWallets()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = MaskIdentityEvidence()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = MigrateVerifiedIdentities()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	//Card numbers must not stay in plaintext, so a card migration that cannot run stops startup
+	err = MigrateCardColumns()
+	if err != nil {
+		panic(fmt.Sprintf("Cannot migrate card columns. %s", err.Error()))
+	}
+
+	err = RotateCardKeys()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = MigrateWalletHolds()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = MigrateAccountHandles()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	err = MigrateTokenKinds()
+	if err != nil {
+		fmt.Println(err)
+	}
 
 	go MessageWorker()
+	go IdempotencyKeyWorker()
+	go TokenExpiryWorker()
+	go ScheduledTransferWorker()
+	go WithdrawalWorker()
 }
 
 type Token struct {
@@ -72,6 +151,13 @@ func GetDB() *gorm.DB {
 	return Db
 }
 
+//Whether err is postgres refusing a row that breaks a unique index
+func isUniqueViolation(err error) bool {
+
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
 type AuthCode struct {
 	Code json.Number `json:"code"`
 }
@@ -148,7 +234,6 @@ func GenJWT(user uint) string {
 }
 
 
-func VerifyTransaction(ref string) ( *paystack.Transaction, error ) {
-	ps := paystack.NewClient(os.Getenv("PS_KEY"), nil)
-	return ps.Transaction.Verify(ref)
+func VerifyTransaction(ref string) ( *payments.Transaction, error ) {
+	return Provider.Verify(ref)
 }
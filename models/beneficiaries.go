@@ -0,0 +1,143 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"litepay/payments"
+	"strings"
+	"fmt"
+)
+
+//A Nigerian bank account a user withdraws to. The account name comes from the bank, never from the user
+type BankBeneficiary struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"index"`
+	BankCode string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+	AccountName string `json:"account_name"`
+	Nickname string `json:"nickname"`
+	RecipientCode string `json:"-"`
+}
+
+type BeneficiaryPayload struct {
+	BankCode string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+	Nickname string `json:"nickname"`
+}
+
+var ErrBeneficiaryNotFound = errors.New("Beneficiary not found")
+
+//NUBAN account numbers are 10 digits
+func validateBankAccount(accountNumber, bankCode string) error {
+
+	if len(accountNumber) != 10 || !isDigits(accountNumber) {
+		return errors.New("Account number should be 10 digits")
+	}
+
+	if bankCode == "" || !isDigits(bankCode) {
+		return errors.New("Invalid bank code")
+	}
+
+	return nil
+}
+
+//Look up the name on a bank account before it is saved
+func ResolveBankAccount(accountNumber, bankCode string) (*payments.BankAccount, error) {
+
+	accountNumber, bankCode = strings.TrimSpace(accountNumber), strings.TrimSpace(bankCode)
+	err := validateBankAccount(accountNumber, bankCode)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := Provider.ResolveAccount(accountNumber, bankCode)
+	if err != nil {
+		fmt.Println(err)
+		return nil, errors.New("Could not resolve this account. Check the account number and bank")
+	}
+
+	return account, nil
+}
+
+func AddBeneficiary(user uint, payload *BeneficiaryPayload) (*BankBeneficiary, error) {
+
+	accountNumber, bankCode := strings.TrimSpace(payload.AccountNumber), strings.TrimSpace(payload.BankCode)
+	nickname, err := validateNickname(payload.Nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	err = Db.Table("bank_beneficiaries").Where("user_id = ? AND bank_code = ? AND account_number = ? AND deleted_at IS NULL",
+		user, bankCode, accountNumber).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, errors.New("This account has already been added")
+	}
+
+	account, err := ResolveBankAccount(accountNumber, bankCode)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := Provider.CreateRecipient(&payments.RecipientRequest{
+		Name: account.AccountName,
+		AccountNumber: accountNumber,
+		BankCode: bankCode,
+		Currency: DefaultCurrency,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, errors.New("Cannot add this account at this time. Please retry")
+	}
+
+	beneficiary := &BankBeneficiary{}
+	beneficiary.UserId = user
+	beneficiary.BankCode = bankCode
+	beneficiary.AccountNumber = accountNumber
+	beneficiary.AccountName = account.AccountName
+	beneficiary.Nickname = nickname
+	beneficiary.RecipientCode = recipient.RecipientCode
+
+	err = Db.Create(beneficiary).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return beneficiary, nil
+}
+
+func GetBeneficiary(user, id uint) *BankBeneficiary {
+
+	beneficiary := &BankBeneficiary{}
+	err := Db.Table("bank_beneficiaries").Where("id = ? AND user_id = ? AND deleted_at IS NULL", id, user).First(beneficiary).Error
+	if err != nil {
+		return nil
+	}
+
+	return beneficiary
+}
+
+func GetBeneficiariesFor(user uint) []*BankBeneficiary {
+
+	data := make([]*BankBeneficiary, 0)
+	err := Db.Table("bank_beneficiaries").Where("user_id = ? AND deleted_at IS NULL", user).Order("id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func DeleteBeneficiary(user, id uint) error {
+
+	beneficiary := GetBeneficiary(user, id)
+	if beneficiary == nil {
+		return ErrBeneficiaryNotFound
+	}
+
+	return Db.Delete(beneficiary).Error
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//Card brands we can tell apart from the BIN
+const (
+	BrandVerve = "verve"
+	BrandVisa = "visa"
+	BrandMastercard = "mastercard"
+	BrandUnknown = "unknown"
+)
+
+//Inclusive 6 digit BIN ranges issued to Verve (5060, 5061, 5078, 5079 and 6500 prefixes)
+var verveRanges = [][2]int {
+	{506000, 506199},
+	{507800, 507999},
+	{650000, 650099},
+}
+
+func isDigits(value string) bool {
+
+	if value == "" {
+		return false
+	}
+
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Luhn (mod 10) checksum every card number carries in its last digit
+func luhnValid(pan string) bool {
+
+	sum := 0
+	double := false
+	for i := len(pan) - 1; i >= 0; i-- {
+
+		d := int(pan[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return sum % 10 == 0
+}
+
+//Brand from the BIN. Verve is checked first since some of its ranges sit inside Mastercard's 5 prefix
+func cardBrand(pan string) string {
+
+	if len(pan) >= 6 {
+		bin, _ := strconv.Atoi(pan[:6])
+		for _, r := range verveRanges {
+			if bin >= r[0] && bin <= r[1] {
+				return BrandVerve
+			}
+		}
+
+		prefix, _ := strconv.Atoi(pan[:4])
+		if prefix >= 2221 && prefix <= 2720 {
+			return BrandMastercard
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(pan, "4"):
+		return BrandVisa
+	case len(pan) >= 2 && pan[:2] >= "51" && pan[:2] <= "55":
+		return BrandMastercard
+	}
+
+	return BrandUnknown
+}
+
+//Check length, digits, the Luhn checksum and that we support the brand
+func ValidateCardNumber(pan string) error {
+
+	if !isDigits(pan) || len(pan) < 12 || len(pan) > 19 {
+		return errors.New("Invalid card number")
+	}
+
+	if !luhnValid(pan) {
+		return errors.New("Invalid card number. Please check the number and retry")
+	}
+
+	if cardBrand(pan) == BrandUnknown {
+		return errors.New("Only Verve, Visa and Mastercard cards are supported")
+	}
+
+	return nil
+}
+
+//A card is usable up to the last day of its expiry month. Accepts 2 or 4 digit years and
+//returns the month and year normalized to MM and YYYY
+func ValidateCardExpiry(month, year string, now time.Time) (string, string, error) {
+
+	month, year = strings.TrimSpace(month), strings.TrimSpace(year)
+	m, err := strconv.Atoi(month)
+	if err != nil || m < 1 || m > 12 {
+		return "", "", errors.New("Invalid expiry month")
+	}
+
+	y, err := strconv.Atoi(year)
+	if err != nil || (len(year) != 2 && len(year) != 4) {
+		return "", "", errors.New("Invalid expiry year")
+	}
+
+	if len(year) == 2 {
+		y += 2000
+	}
+
+	if y < now.Year() || (y == now.Year() && m < int(now.Month())) {
+		return "", "", errors.New("Card has expired")
+	}
+
+	if y > now.Year() + 20 {
+		return "", "", errors.New("Invalid expiry year")
+	}
+
+	return strconv.Itoa(100 + m)[1:], strconv.Itoa(y), nil
+}
+
+func validateNickname(nickname string) (string, error) {
+
+	nickname = strings.TrimSpace(nickname)
+	if utf8.RuneCountInString(nickname) > 32 {
+		return "", errors.New("Card nickname should be at most 32 characters")
+	}
+
+	return nickname, nil
+}
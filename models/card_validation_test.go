@@ -0,0 +1,83 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateCardNumber(t *testing.T) {
+
+	cases := []struct {
+		pan string
+		brand string
+		luhn bool
+		valid bool
+	}{
+		{"4111111111111111", BrandVisa, true, true},
+		{"4084084084084081", BrandVisa, true, true},
+		{"5555555555554444", BrandMastercard, true, true},
+		{"5105105105105100", BrandMastercard, true, true},
+		{"2223003122003222", BrandMastercard, true, true},
+		{"5060990580000217499", BrandVerve, true, true},
+		{"5078000000000006", BrandVerve, true, true},
+		{"6500000000000002", BrandVerve, true, true},
+		{"4111111111111112", BrandVisa, false, false},
+		{"378282246310005", BrandUnknown, true, false},
+		{"6011111111111117", BrandUnknown, true, false},
+		{"2721000000000004", BrandUnknown, true, false},
+		{"4111 1111 1111 1111", BrandVisa, false, false},
+		{"41111111112", BrandVisa, true, false},
+		{"", BrandUnknown, true, false},
+	}
+
+	for _, c := range cases {
+		if brand := cardBrand(c.pan); brand != c.brand {
+			t.Errorf("cardBrand(%q) = %s, want %s", c.pan, brand, c.brand)
+		}
+
+		if isDigits(c.pan) && luhnValid(c.pan) != c.luhn {
+			t.Errorf("luhnValid(%q) = %v, want %v", c.pan, !c.luhn, c.luhn)
+		}
+
+		if err := ValidateCardNumber(c.pan); (err == nil) != c.valid {
+			t.Errorf("ValidateCardNumber(%q) returned %v, want valid %v", c.pan, err, c.valid)
+		}
+	}
+}
+
+func TestValidateCardExpiry(t *testing.T) {
+
+	now := time.Date(2026, time.March, 31, 23, 59, 0, 0, time.UTC)
+	cases := []struct {
+		month, year string
+		wantMonth, wantYear string
+		valid bool
+	}{
+		{"3", "2026", "03", "2026", true},
+		{"03", "26", "03", "2026", true},
+		{"12", "26", "12", "2026", true},
+		{"1", "27", "01", "2027", true},
+		{" 04 ", " 2030 ", "04", "2030", true},
+		{"12", "2046", "12", "2046", true},
+		{"2", "2026", "", "", false},
+		{"12", "25", "", "", false},
+		{"1", "2047", "", "", false},
+		{"0", "2027", "", "", false},
+		{"13", "2027", "", "", false},
+		{"ab", "2027", "", "", false},
+		{"1", "202", "", "", false},
+		{"1", "twenty", "", "", false},
+	}
+
+	for _, c := range cases {
+		month, year, err := ValidateCardExpiry(c.month, c.year, now)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateCardExpiry(%q, %q) returned %v, want valid %v", c.month, c.year, err, c.valid)
+			continue
+		}
+
+		if month != c.wantMonth || year != c.wantYear {
+			t.Errorf("ValidateCardExpiry(%q, %q) = %s/%s, want %s/%s", c.month, c.year, month, year, c.wantMonth, c.wantYear)
+		}
+	}
+}
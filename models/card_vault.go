@@ -0,0 +1,127 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"fmt"
+)
+
+var ErrCardVaultNotConfigured = errors.New("Card storage is not configured")
+
+//Keys used to encrypt card numbers at rest, loaded from the environment
+//	CARD_ENCRYPTION_KEYS=v1:<base64 32 bytes>,v2:<base64 32 bytes>
+//	CARD_ENCRYPTION_KEY_ID=v2
+//	CARD_FINGERPRINT_KEY=<any secret>
+//New cards are encrypted with the active key. Old keys stay listed until RotateCardKeys
+//has re-encrypted every card under the active one
+type cardVault struct {
+	keys map[string] []byte
+	active string
+	fingerprintKey []byte
+}
+
+func loadCardVault() (*cardVault, error) {
+
+	vault := &cardVault{keys: make(map[string] []byte)}
+	for _, pair := range strings.Split(os.Getenv("CARD_ENCRYPTION_KEYS"), ",") {
+
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Malformed CARD_ENCRYPTION_KEYS")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != 32 {
+			return nil, errors.New(fmt.Sprintf("Card encryption key %s should be 32 bytes of base64", parts[0]))
+		}
+
+		vault.keys[parts[0]] = key
+	}
+
+	vault.active = os.Getenv("CARD_ENCRYPTION_KEY_ID")
+	if _, ok := vault.keys[vault.active]; !ok {
+		return nil, ErrCardVaultNotConfigured
+	}
+
+	vault.fingerprintKey = []byte(os.Getenv("CARD_FINGERPRINT_KEY"))
+	if len(vault.fingerprintKey) == 0 {
+		return nil, ErrCardVaultNotConfigured
+	}
+
+	return vault, nil
+}
+
+//Encrypt a card number with AES-256-GCM under the active key. Returns the key id with the ciphertext
+func (vault *cardVault) encrypt(pan string) (keyId, ciphertext string, err error) {
+
+	gcm, err := vault.gcm(vault.active)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(pan), nil)
+	return vault.active, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (vault *cardVault) decrypt(keyId, ciphertext string) (string, error) {
+
+	gcm, err := vault.gcm(keyId)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", errors.New("Malformed card ciphertext")
+	}
+
+	pan, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pan), nil
+}
+
+func (vault *cardVault) gcm(keyId string) (cipher.AEAD, error) {
+
+	key, ok := vault.keys[keyId]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown card encryption key '%s'", keyId))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+//A keyed hash of the card number. Lets us recognise the same card without decrypting anything,
+//and does not change when the encryption keys are rotated
+func (vault *cardVault) fingerprint(pan string) string {
+
+	mac := hmac.New(sha256.New, vault.fingerprintKey)
+	mac.Write([]byte(pan))
+	return hex.EncodeToString(mac.Sum(nil))
+}
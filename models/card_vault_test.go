@@ -0,0 +1,148 @@
+package models
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testVaultKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32)))
+}
+
+//A vault with keys v1 and v2, encrypting under active
+func useTestVault(t *testing.T, active string) *cardVault {
+
+	t.Helper()
+	t.Setenv("CARD_ENCRYPTION_KEYS", "v1:" + testVaultKey('a') + ", v2:" + testVaultKey('b'))
+	t.Setenv("CARD_ENCRYPTION_KEY_ID", active)
+	t.Setenv("CARD_FINGERPRINT_KEY", "fingerprint-secret")
+
+	vault, err := loadCardVault()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vault
+}
+
+func TestCardVaultRoundTrip(t *testing.T) {
+
+	vault := useTestVault(t, "v2")
+	pan := "4111111111111111"
+
+	keyId, first, err := vault.encrypt(pan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keyId != "v2" {
+		t.Errorf("encrypted under %s, want the active key v2", keyId)
+	}
+
+	if strings.Contains(first, pan) {
+		t.Error("ciphertext holds the card number")
+	}
+
+	_, second, err := vault.encrypt(pan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Error("the same card encrypted twice gave the same ciphertext")
+	}
+
+	decrypted, err := vault.decrypt(keyId, first)
+	if err != nil || decrypted != pan {
+		t.Errorf("decrypted %q with %v, want %q", decrypted, err, pan)
+	}
+}
+
+//Cards encrypted before a rotation still open with the key they were sealed under, and with no other
+func TestCardVaultOldKeys(t *testing.T) {
+
+	old := useTestVault(t, "v1")
+	_, ciphertext, err := old.encrypt("5555555555554444")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vault := useTestVault(t, "v2")
+	pan, err := vault.decrypt("v1", ciphertext)
+	if err != nil || pan != "5555555555554444" {
+		t.Errorf("decrypted %q with %v after rotation", pan, err)
+	}
+
+	_, err = vault.decrypt("v2", ciphertext)
+	if err == nil {
+		t.Error("a card opened under a key it was not sealed with")
+	}
+
+	_, err = vault.decrypt("v3", ciphertext)
+	if err == nil {
+		t.Error("a card opened under a key that does not exist")
+	}
+}
+
+func TestCardVaultTamperedCiphertext(t *testing.T) {
+
+	vault := useTestVault(t, "v1")
+	_, ciphertext, err := vault.encrypt("4111111111111111")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, _ := base64.StdEncoding.DecodeString(ciphertext)
+	sealed[len(sealed) - 1] ^= 1
+
+	for _, bad := range []string {base64.StdEncoding.EncodeToString(sealed), "not base64!", "", "c2hvcnQ="} {
+		if pan, err := vault.decrypt("v1", bad); err == nil {
+			t.Errorf("decrypt(%q) = %q, want an error", bad, pan)
+		}
+	}
+}
+
+func TestCardVaultFingerprint(t *testing.T) {
+
+	vault := useTestVault(t, "v1")
+	fingerprint := vault.fingerprint("4111111111111111")
+	if strings.Contains(fingerprint, "4111111111111111") || len(fingerprint) != 64 {
+		t.Errorf("fingerprint %s", fingerprint)
+	}
+
+	if fingerprint == vault.fingerprint("4111111111111112") {
+		t.Error("two cards share a fingerprint")
+	}
+
+	//Rotating the encryption keys leaves fingerprints alone
+	if useTestVault(t, "v2").fingerprint("4111111111111111") != fingerprint {
+		t.Error("fingerprint changed with the encryption key")
+	}
+}
+
+func TestLoadCardVault(t *testing.T) {
+
+	cases := []struct {
+		name string
+		keys string
+		active string
+		fingerprint string
+	}{
+		{"nothing set", "", "", ""},
+		{"active key missing", "v1:" + testVaultKey('a'), "v2", "secret"},
+		{"no fingerprint key", "v1:" + testVaultKey('a'), "v1", ""},
+		{"short key", "v1:" + base64.StdEncoding.EncodeToString([]byte("short")), "v1", "secret"},
+		{"not base64", "v1:%%%", "v1", "secret"},
+		{"no key id", testVaultKey('a'), "v1", "secret"},
+	}
+
+	for _, c := range cases {
+		t.Setenv("CARD_ENCRYPTION_KEYS", c.keys)
+		t.Setenv("CARD_ENCRYPTION_KEY_ID", c.active)
+		t.Setenv("CARD_FINGERPRINT_KEY", c.fingerprint)
+		if _, err := loadCardVault(); err == nil {
+			t.Errorf("%s: vault loaded", c.name)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+	"fmt"
+)
+
+//A card saved by a user. The card number is only kept encrypted (see card_vault.go) and the CVV is never
+//stored. Everything a client can see is the BIN, last 4 digits, brand and expiry
+type Card struct {
+	gorm.Model
+	Account uint `json:"account"`
+	Bin string `json:"bin"`
+	Last4 string `json:"last4"`
+	Brand string `json:"brand"`
+	ExpiryMonth string `json:"expiry_month"`
+	ExpiryYear string `json:"expiry_year"`
+	Nickname string `json:"nickname"`
+	IsDefault bool `json:"is_default"`
+	Fingerprint string `json:"-" gorm:"index"`
+	PanKeyId string `json:"-"`
+	PanCiphertext string `json:"-" gorm:"type:text"`
+	MaskedPan string `sql:"-" gorm:"-" json:"masked_pan"`
+}
+
+//What a client sends to add a card. Cvv is checked and then dropped
+type CardPayload struct {
+	CardNo string `json:"card_no"`
+	Cvv string `json:"cvv"`
+	ExpiryMonth string `json:"expiry_month"`
+	ExpiryYear string `json:"expiry_year"`
+	Nickname string `json:"nickname"`
+}
+
+type CardUpdatePayload struct {
+	Nickname string `json:"nickname"`
+}
+
+var ErrCardNotFound = errors.New("Card not found")
+
+func (card *Card) mask() *Card {
+	card.MaskedPan = fmt.Sprintf("%s******%s", card.Bin, card.Last4)
+	return card
+}
+
+//Strip the spaces and dashes people type into card numbers
+func normalizePan(pan string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(pan))
+}
+
+//Fill in everything derived from the card number and encrypt it
+func sealCard(vault *cardVault, card *Card, pan string) error {
+
+	keyId, ciphertext, err := vault.encrypt(pan)
+	if err != nil {
+		return err
+	}
+
+	card.Bin = pan[:6]
+	card.Last4 = pan[len(pan) - 4:]
+	card.Brand = cardBrand(pan)
+	card.Fingerprint = vault.fingerprint(pan)
+	card.PanKeyId = keyId
+	card.PanCiphertext = ciphertext
+	return nil
+}
+
+func AddCard(user uint, payload *CardPayload) (*Card, error) {
+
+	if user <= 0 {
+		return nil, errors.New("Account not found")
+	}
+
+	pan := normalizePan(payload.CardNo)
+	err := ValidateCardNumber(pan)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isDigits(payload.Cvv) || len(payload.Cvv) < 3 || len(payload.Cvv) > 4 {
+		return nil, errors.New("Invalid cvv")
+	}
+
+	month, year, err := ValidateCardExpiry(payload.ExpiryMonth, payload.ExpiryYear, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	nickname, err := validateNickname(payload.Nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := loadCardVault()
+	if err != nil {
+		fmt.Println(err)
+		return nil, errors.New("Cannot add cards at this time. Please retry")
+	}
+
+	count := 0
+	err = Db.Table("cards").Where("account = ? AND fingerprint = ? AND deleted_at IS NULL",
+		user, vault.fingerprint(pan)).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, errors.New("This card has already been added to your account")
+	}
+
+	card := &Card{}
+	card.Account = user
+	card.ExpiryMonth = month
+	card.ExpiryYear = year
+	card.Nickname = nickname
+	err = sealCard(vault, card, pan)
+	if err != nil {
+		fmt.Println(err)
+		return nil, errors.New("Cannot add cards at this time. Please retry")
+	}
+
+	//The first card a user adds is their default
+	existing := 0
+	Db.Table("cards").Where("account = ? AND deleted_at IS NULL", user).Count(&existing)
+	card.IsDefault = existing == 0
+
+	err = Db.Create(card).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return card.mask(), nil
+}
+
+func GetCard(user, id uint) *Card {
+
+	card := &Card{}
+	err := Db.Table("cards").Where("id = ? AND account = ? AND deleted_at IS NULL", id, user).First(card).Error
+	if err != nil {
+		return nil
+	}
+
+	return card.mask()
+}
+
+//Remove a card. If it was the default, the most recently added remaining card takes its place
+func DeleteCard(user, id uint) error {
+
+	card := GetCard(user, id)
+	if card == nil {
+		return ErrCardNotFound
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return err
+	}
+
+	//Nothing about a removed card needs to be kept, least of all its number
+	err = tx.Table("cards").Where("id = ?", card.ID).UpdateColumns(map[string] interface{} {
+		"pan_ciphertext" : "", "is_default" : false, "deleted_at" : time.Now()}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if card.IsDefault {
+		next := &Card{}
+		err = tx.Table("cards").Where("account = ? AND deleted_at IS NULL", user).Order("id desc").First(next).Error
+		if err == nil {
+			err = tx.Table("cards").Where("id = ?", next.ID).UpdateColumn("is_default", true).Error
+		}
+
+		if err != nil && err != gorm.ErrRecordNotFound {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func SetDefaultCard(user, id uint) (*Card, error) {
+
+	card := GetCard(user, id)
+	if card == nil {
+		return nil, ErrCardNotFound
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Table("cards").Where("account = ? AND id <> ?", user, card.ID).UpdateColumn("is_default", false).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Table("cards").Where("id = ?", card.ID).UpdateColumn("is_default", true).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	card.IsDefault = true
+	return card, nil
+}
+
+func RenameCard(user, id uint, payload *CardUpdatePayload) (*Card, error) {
+
+	card := GetCard(user, id)
+	if card == nil {
+		return nil, ErrCardNotFound
+	}
+
+	nickname, err := validateNickname(payload.Nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	err = Db.Table("cards").Where("id = ?", card.ID).UpdateColumn("nickname", nickname).Error
+	if err != nil {
+		return nil, err
+	}
+
+	card.Nickname = nickname
+	return card, nil
+}
+
+func GetCardsFor(user uint) []*Card {
+
+	data := make([]*Card, 0)
+	err := Db.Table("cards").Where("account = ? AND deleted_at IS NULL", user).Order("is_default desc, id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	for _, card := range data {
+		card.mask()
+	}
+
+	return data
+}
+
+//Re-encrypt every card still sealed under a key other than the active one. Once this has run,
+//the old key can be removed from CARD_ENCRYPTION_KEYS
+func RotateCardKeys() error {
+
+	vault, err := loadCardVault()
+	if err != nil {
+		return err
+	}
+
+	cards := make([]*Card, 0)
+	err = Db.Unscoped().Table("cards").Where("pan_key_id <> ? AND pan_ciphertext <> ''", vault.active).Find(&cards).Error
+	if err != nil {
+		return err
+	}
+
+	for _, card := range cards {
+
+		pan, err := vault.decrypt(card.PanKeyId, card.PanCiphertext)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Cannot decrypt card %d. %s", card.ID, err.Error()))
+		}
+
+		keyId, ciphertext, err := vault.encrypt(pan)
+		if err != nil {
+			return err
+		}
+
+		err = Db.Table("cards").Where("id = ?", card.ID).UpdateColumns(map[string] interface{} {
+			"pan_key_id" : keyId, "pan_ciphertext" : ciphertext}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Cards used to be stored with the raw card number and cvv. Drop the cvv outright, and encrypt the card
+//number of every existing row before dropping that column too
+func MigrateCardColumns() error {
+
+	hasCvv, hasCardNo := Db.Dialect().HasColumn("cards", "cvv"), Db.Dialect().HasColumn("cards", "card_no")
+	if !hasCvv && !hasCardNo {
+		return nil
+	}
+
+	//Nothing is touched until the card numbers can be encrypted, so a missing key never leaves the table half migrated
+	var vault *cardVault
+	if hasCardNo {
+		var err error
+		vault, err = loadCardVault()
+		if err != nil {
+			return err
+		}
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return err
+	}
+
+	err = migrateCardColumns(tx, vault, hasCvv, hasCardNo)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func migrateCardColumns(tx *gorm.DB, vault *cardVault, hasCvv, hasCardNo bool) error {
+
+	if hasCvv {
+		err := tx.Exec("ALTER TABLE cards DROP COLUMN cvv").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if !hasCardNo {
+		return nil
+	}
+
+	type oldCard struct {
+		ID uint
+		CardNo string
+	}
+
+	rows := make([]*oldCard, 0)
+	err := tx.Table("cards").Select("id, card_no").Where("card_no IS NOT NULL AND card_no <> ''").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+
+		card := &Card{}
+		pan := normalizePan(row.CardNo)
+		if !isDigits(pan) || len(pan) < 12 {
+			//Not a card number we can do anything with. Keep nothing of it
+			err = tx.Exec("UPDATE cards SET card_no = NULL, deleted_at = NOW() WHERE id = ?", row.ID).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = sealCard(vault, card, pan)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Table("cards").Where("id = ?", row.ID).UpdateColumns(map[string] interface{} {
+			"bin" : card.Bin, "last4" : card.Last4, "brand" : card.Brand, "fingerprint" : card.Fingerprint,
+			"pan_key_id" : card.PanKeyId, "pan_ciphertext" : card.PanCiphertext, "card_no" : gorm.Expr("NULL")}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Exec("ALTER TABLE cards DROP COLUMN card_no").Error
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/olahol/melody"
 	"encoding/json"
+	"sync"
 )
 
 var (
@@ -12,6 +13,21 @@ var (
 							"R", "S", "T", "U", "V", "W", "X", "Z"}
 
 	sessions = make(map[string] *melody.Session)
+	sessionsMu sync.Mutex
+)
+
+//Events pushed to clients over the websocket
+const (
+	WsTokenClaimed = "token.claimed"
+	WsTokenExpired = "token.expired"
+	WsTokenAuthorized = "token.authorized"
+	WsTransferReceived = "transfer.received"
+	WsTransferSent = "transfer.sent"
+	WsRequestReceived = "request.received"
+	WsSplitProgress = "split.progress"
+	WsTokenRefunded = "token.refunded"
+	WsTokenDeclined = "token.declined"
+	WsTokenCancelled = "token.cancelled"
 )
 
 func GenUniqueKey() (string) {
@@ -33,22 +49,30 @@ type IncomingMessage struct {
 
 type WsMessage struct {
 
+	Event string `json:"event"`
 	Account *Account `json:"account"`
-	Amount float64 `json:"amount"`
+	Amount Money `json:"amount"`
 	Token string `json:"token"`
+	Reason string `json:"reason,omitempty"`
+	Memo string `json:"memo,omitempty"`
+	Split *Split `json:"split,omitempty"`
 
 }
 
 func CreateWsSubscription(ws *IncomingMessage, sess *melody.Session) {
 	key := fmt.Sprintf("account%d", ws.UniqueId)
+	sessionsMu.Lock()
 	sessions[key] = sess
+	sessionsMu.Unlock()
 }
 
 func SendWsMessageTo(user uint, message *WsMessage) {
 
 	data, _ := json.Marshal(message)
 	key := fmt.Sprintf("account%d", user)
+	sessionsMu.Lock()
 	sess, ok := sessions[key]
+	sessionsMu.Unlock()
 	if ok && sess != nil {
 		err := sess.Write(data)
 		fmt.Println(err)
@@ -57,16 +81,11 @@ func SendWsMessageTo(user uint, message *WsMessage) {
 
 type WalletTopUpRequest struct {
 	Amount json.Number `json:"amount"`
+	Currency string `json:"currency"`
 }
 
-func (w *WalletTopUpRequest) AmountValueInKobo() float32 {
-
-	data, err := w.Amount.Float64()
-	if err != nil {
-		return 0
-	}
-
-	return float32(data * 100)
+func (w *WalletTopUpRequest) AmountValue() (Money, error) {
+	return ParseMoney(w.Amount, w.Currency)
 }
 
 
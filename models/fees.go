@@ -0,0 +1,479 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"strings"
+	"time"
+	"fmt"
+)
+
+//What a fee is charged on
+const (
+	FeeTopUp = "topup"
+	FeeTransfer = "p2p" //payments, transfers and money requests, charged to the payer
+	FeeWithdrawal = "withdrawal"
+)
+
+//How a fee is worked out
+const (
+	FeeFlat = "flat"
+	FeePercentage = "percentage"
+	FeeTiered = "tiered"
+)
+
+//One band of a tiered fee. Applies to amounts up to UpTo, or any amount when UpTo is 0
+type FeeBand struct {
+	UpTo int64 `json:"up_to"` //kobo
+	Flat int64 `json:"flat"` //kobo
+	BasisPoints int64 `json:"basis_points"`
+}
+
+//A rule for the fee on an operation. When several rules match, a running promo wins over everything
+//else, then a rule for the user's tier over one for all tiers, then the highest priority.
+//A promo rule only applies to users who have entered its code.
+//Waived rules charge nothing, which is how a tier or promo goes fee free
+type FeeRule struct {
+	gorm.Model
+	Operation string `json:"operation" gorm:"index"`
+	Kind string `json:"kind"`
+	Flat Money `json:"flat" gorm:"embedded;embedded_prefix:flat_"`
+	BasisPoints int64 `json:"basis_points"` //1% is 100
+	Bands string `json:"-" gorm:"type:text"`
+	Min Money `json:"min" gorm:"embedded;embedded_prefix:min_"`
+	Cap Money `json:"cap" gorm:"embedded;embedded_prefix:cap_"` //zero for no cap
+	Tier *int `json:"tier"` //nil for every tier
+	Promo string `json:"promo"`
+	Waived bool `json:"waived"`
+	Priority int `json:"priority"`
+	Active bool `json:"active"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt *time.Time `json:"ends_at"`
+
+	FeeBands []FeeBand `sql:"-" gorm:"-" json:"bands,omitempty"`
+}
+
+type FeeRulePayload struct {
+	Operation string `json:"operation"`
+	Kind string `json:"kind"`
+	Flat json.Number `json:"flat"`
+	BasisPoints int64 `json:"basis_points"`
+	Bands []FeeBand `json:"bands"`
+	Min json.Number `json:"min"`
+	Cap json.Number `json:"cap"`
+	Tier *int `json:"tier"`
+	Promo string `json:"promo"`
+	Waived bool `json:"waived"`
+	Priority int `json:"priority"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt *time.Time `json:"ends_at"`
+}
+
+//What a user is shown before confirming an operation
+type FeeQuote struct {
+	Operation string `json:"operation"`
+	Amount Money `json:"amount"`
+	Fee Money `json:"fee"`
+	Total Money `json:"total"` //what leaves the wallet, or for top ups what reaches it
+	RuleId uint `json:"rule_id"`
+	Promo string `json:"promo,omitempty"`
+}
+
+type FeeQuotePayload struct {
+	Operation string `json:"operation"`
+	Amount json.Number `json:"amount"`
+}
+
+//A user who has entered a promo code, and so pays the fees of the rules running under it
+type FeePromoEnrollment struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"unique_index:idx_fee_promo_user"`
+	Promo string `json:"promo" gorm:"unique_index:idx_fee_promo_user"`
+}
+
+type FeePromoPayload struct {
+	Code string `json:"code"`
+}
+
+func validFeeOperation(operation string) bool {
+
+	switch operation {
+	case FeeTopUp, FeeTransfer, FeeWithdrawal:
+		return true
+	}
+
+	return false
+}
+
+//Parse an optional amount, zero when empty
+func optionalMoney(n json.Number, name string) (Money, error) {
+
+	if n == "" {
+		return Kobo(0), nil
+	}
+
+	m, err := ParseMoney(n, DefaultCurrency)
+	if err != nil || m.IsNegative() {
+		return Kobo(0), errors.New(fmt.Sprintf("Invalid %s", name))
+	}
+
+	return m, nil
+}
+
+func CreateFeeRule(payload *FeeRulePayload) (*FeeRule, error) {
+
+	if !validFeeOperation(payload.Operation) {
+		return nil, errors.New("Operation should be topup, p2p or withdrawal")
+	}
+
+	rule := &FeeRule{}
+	rule.Operation = payload.Operation
+	rule.Kind = payload.Kind
+	rule.Tier = payload.Tier
+	rule.Promo = normalizePromo(payload.Promo)
+	rule.Waived = payload.Waived
+	rule.Priority = payload.Priority
+	rule.Active = true
+	rule.StartsAt = payload.StartsAt
+	rule.EndsAt = payload.EndsAt
+
+	var err error
+	rule.Flat, err = optionalMoney(payload.Flat, "flat fee")
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Min, err = optionalMoney(payload.Min, "minimum fee")
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Cap, err = optionalMoney(payload.Cap, "fee cap")
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Cap.IsPositive() && rule.Min.GreaterThan(rule.Cap) {
+		return nil, errors.New("Minimum fee cannot be more than the cap")
+	}
+
+	if payload.BasisPoints < 0 || payload.BasisPoints > 10000 {
+		return nil, errors.New("Basis points should be between 0 and 10000")
+	}
+	rule.BasisPoints = payload.BasisPoints
+
+	switch rule.Kind {
+	case FeeFlat, FeePercentage:
+	case FeeTiered:
+		if len(payload.Bands) == 0 {
+			return nil, errors.New("A tiered fee needs at least one band")
+		}
+
+		for i, band := range payload.Bands {
+			last := i == len(payload.Bands) - 1
+			if band.Flat < 0 || band.BasisPoints < 0 || band.BasisPoints > 10000 {
+				return nil, errors.New(fmt.Sprintf("Invalid band %d", i + 1))
+			}
+
+			if (band.UpTo <= 0 && !last) || (i > 0 && band.UpTo > 0 && band.UpTo <= payload.Bands[i - 1].UpTo) {
+				return nil, errors.New("Bands should be in increasing order, with only the last one open ended")
+			}
+		}
+
+		bands, _ := json.Marshal(payload.Bands)
+		rule.Bands = string(bands)
+	default:
+		if !rule.Waived {
+			return nil, errors.New("Kind should be flat, percentage or tiered")
+		}
+	}
+
+	if rule.StartsAt != nil && rule.EndsAt != nil && rule.EndsAt.Before(*rule.StartsAt) {
+		return nil, errors.New("A rule cannot end before it starts")
+	}
+
+	err = Db.Create(rule).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return rule.load(), nil
+}
+
+func (rule *FeeRule) load() *FeeRule {
+
+	rule.FeeBands = nil
+	if rule.Bands != "" {
+		json.Unmarshal([]byte(rule.Bands), &rule.FeeBands)
+	}
+
+	return rule
+}
+
+func GetFeeRules() []*FeeRule {
+
+	data := make([]*FeeRule, 0)
+	err := Db.Table("fee_rules").Where("deleted_at IS NULL").Order("operation asc, priority desc, id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	for _, rule := range data {
+		rule.load()
+	}
+
+	return data
+}
+
+func DeleteFeeRule(id uint) error {
+
+	rule := &FeeRule{}
+	err := Db.Table("fee_rules").Where("id = ? AND deleted_at IS NULL", id).First(rule).Error
+	if err != nil {
+		return errors.New("Fee rule not found")
+	}
+
+	return Db.Delete(rule).Error
+}
+
+func normalizePromo(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+//Enroll user in the promo with code, so its rules apply to them. Entering a code twice is not an error
+func ApplyFeePromo(user uint, code string) (*FeePromoEnrollment, error) {
+
+	code = normalizePromo(code)
+	if code == "" {
+		return nil, errors.New("Promo code is required")
+	}
+
+	running := 0
+	err := Db.Table("fee_rules").Where("UPPER(promo) = ? AND active = ? AND deleted_at IS NULL", code, true).
+		Where("ends_at IS NULL OR ends_at > ?", time.Now()).Count(&running).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if running == 0 {
+		return nil, errors.New("Promo code is invalid or has ended")
+	}
+
+	enrollment := &FeePromoEnrollment{}
+	err = Db.Table("fee_promo_enrollments").Where("user_id = ? AND promo = ?", user, code).First(enrollment).Error
+	if err == nil {
+		return enrollment, nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	enrollment.UserId = user
+	enrollment.Promo = code
+	err = Db.Create(enrollment).Error
+	if isUniqueViolation(err) {
+		existing := &FeePromoEnrollment{}
+		err = Db.Table("fee_promo_enrollments").Where("user_id = ? AND promo = ?", user, code).First(existing).Error
+		return existing, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return enrollment, nil
+}
+
+//The rule that decides the fee on operation for user, who is on tier, nil when nothing applies
+func matchFeeRule(operation string, user uint, tier int, now time.Time) (*FeeRule, error) {
+
+	data := make([]*FeeRule, 0)
+	err := Db.Table("fee_rules").Where("operation = ? AND active = ? AND deleted_at IS NULL", operation, true).
+		Where("tier IS NULL OR tier = ?", tier).
+		Where("COALESCE(promo, '') = '' OR UPPER(promo) IN (SELECT promo FROM fee_promo_enrollments " +
+			"WHERE user_id = ? AND deleted_at IS NULL)", user).
+		Where("starts_at IS NULL OR starts_at <= ?", now).
+		Where("ends_at IS NULL OR ends_at > ?", now).Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+
+	best := bestFeeRule(data)
+	if best != nil {
+		best.load()
+	}
+
+	return best, nil
+}
+
+func bestFeeRule(rules []*FeeRule) *FeeRule {
+
+	var best *FeeRule
+	for _, rule := range rules {
+		if best == nil || rule.outranks(best) {
+			best = rule
+		}
+	}
+
+	return best
+}
+
+func (rule *FeeRule) outranks(other *FeeRule) bool {
+
+	if (rule.Promo != "") != (other.Promo != "") {
+		return rule.Promo != ""
+	}
+
+	if (rule.Tier != nil) != (other.Tier != nil) {
+		return rule.Tier != nil
+	}
+
+	if rule.Priority != other.Priority {
+		return rule.Priority > other.Priority
+	}
+
+	return rule.ID > other.ID
+}
+
+//Basis points of amount, rounded half up to the nearest kobo
+func percentOf(amount Money, bps int64) int64 {
+	return (amount.Kobo * bps + 5000) / 10000
+}
+
+func (rule *FeeRule) feeOn(amount Money) Money {
+
+	if rule.Waived {
+		return NewMoney(0, amount.Currency)
+	}
+
+	var kobo int64
+	switch rule.Kind {
+	case FeeFlat:
+		kobo = rule.Flat.Kobo
+	case FeePercentage:
+		kobo = percentOf(amount, rule.BasisPoints)
+	case FeeTiered:
+		for _, band := range rule.FeeBands {
+			if band.UpTo <= 0 || amount.Kobo <= band.UpTo {
+				kobo = band.Flat + percentOf(amount, band.BasisPoints)
+				break
+			}
+		}
+	}
+
+	if kobo < rule.Min.Kobo {
+		kobo = rule.Min.Kobo
+	}
+
+	if rule.Cap.IsPositive() && kobo > rule.Cap.Kobo {
+		kobo = rule.Cap.Kobo
+	}
+
+	return NewMoney(kobo, amount.Currency)
+}
+
+//Work out the fee user pays on an operation for amount
+func QuoteFee(operation string, user uint, amount Money) (*FeeQuote, error) {
+
+	if !validFeeOperation(operation) {
+		return nil, errors.New("Operation should be topup, p2p or withdrawal")
+	}
+
+	if !amount.IsPositive() {
+		return nil, errors.New("Amount should be > 0")
+	}
+
+	account := GetAccount(user)
+	if account == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	rule, err := matchFeeRule(operation, user, account.Tier, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &FeeQuote{}
+	quote.Operation = operation
+	quote.Amount = amount
+	quote.Fee = NewMoney(0, amount.Currency)
+	if rule != nil {
+		quote.Fee = rule.feeOn(amount)
+		quote.RuleId = rule.ID
+		quote.Promo = rule.Promo
+	}
+
+	//A top up fee comes out of the money paid in, so it can never be more than that
+	if operation == FeeTopUp {
+		if quote.Fee.GreaterThan(amount) {
+			quote.Fee = amount
+		}
+		quote.Total = amount.Sub(quote.Fee)
+	} else {
+		quote.Total = amount.Add(quote.Fee)
+	}
+
+	return quote, nil
+}
+
+//Fee on an operation, or nothing when the quote fails. Fees never block a payment
+func feeFor(operation string, user uint, amount Money) Money {
+
+	quote, err := QuoteFee(operation, user, amount)
+	if err != nil {
+		fmt.Println(err)
+		return NewMoney(0, amount.Currency)
+	}
+
+	return quote.Fee
+}
+
+//Take fee from wallet, locked in tx, and post it to the fees revenue account under ref
+func chargeFee(tx *gorm.DB, wallet *Wallet, ref, memo string, fee Money) error {
+
+	if !fee.IsPositive() {
+		return nil
+	}
+
+	err := debitWallet(tx, wallet, fee)
+	if err != nil {
+		return err
+	}
+
+	return postFee(tx, wallet, ref, memo, fee)
+}
+
+//Take a fee that a captured hold set aside. Like the money the hold was for, it is taken even if the
+//wallet has since been overdrawn
+func chargeHeldFee(tx *gorm.DB, wallet *Wallet, ref, memo string, fee Money) error {
+
+	if !fee.IsPositive() {
+		return nil
+	}
+
+	err := overdrawWallet(tx, wallet, fee)
+	if err != nil {
+		return err
+	}
+
+	return postFee(tx, wallet, ref, memo, fee)
+}
+
+func postFee(tx *gorm.DB, wallet *Wallet, ref, memo string, fee Money) error {
+
+	account, err := GetWalletLedgerAccount(tx, wallet)
+	if err != nil {
+		return err
+	}
+
+	fees, err := GetSystemAccount(tx, FeesAccount)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryFee, ref, memo)
+	entry.Debit(account, fee).Credit(fees, fee)
+	return PostJournalEntry(tx, entry)
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFeeOn(t *testing.T) {
+
+	bands := []FeeBand {{UpTo: 500000, Flat: 1000}, {UpTo: 5000000, Flat: 2500}, {BasisPoints: 50}}
+	encoded, _ := json.Marshal(bands)
+	tiered := (&FeeRule{Kind: FeeTiered, Bands: string(encoded)}).load()
+
+	cases := []struct {
+		name string
+		rule *FeeRule
+		amount Money
+		fee int64
+	}{
+		{"flat", &FeeRule{Kind: FeeFlat, Flat: naira(100)}, naira(5000), 10000},
+		{"percentage", &FeeRule{Kind: FeePercentage, BasisPoints: 150}, naira(1000), 1500},
+		{"percentage rounds half up", &FeeRule{Kind: FeePercentage, BasisPoints: 150}, Kobo(3300), 50},
+		{"percentage rounds down", &FeeRule{Kind: FeePercentage, BasisPoints: 150}, Kobo(3299), 49},
+		{"percentage rounds up", &FeeRule{Kind: FeePercentage, BasisPoints: 150}, Kobo(3333), 50},
+		{"percentage of a kobo", &FeeRule{Kind: FeePercentage, BasisPoints: 150}, Kobo(1), 0},
+		{"raised to the minimum", &FeeRule{Kind: FeePercentage, BasisPoints: 100, Min: naira(10)}, naira(500), 1000},
+		{"above the minimum", &FeeRule{Kind: FeePercentage, BasisPoints: 100, Min: naira(10)}, naira(5000), 5000},
+		{"capped", &FeeRule{Kind: FeePercentage, BasisPoints: 100, Cap: naira(2000)}, naira(1000000), 200000},
+		{"under the cap", &FeeRule{Kind: FeePercentage, BasisPoints: 100, Cap: naira(2000)}, naira(1000), 1000},
+		{"min and cap", &FeeRule{Kind: FeeFlat, Flat: naira(1), Min: naira(5), Cap: naira(50)}, naira(1000), 500},
+		{"waived", &FeeRule{Kind: FeeFlat, Flat: naira(100), Min: naira(10), Waived: true}, naira(5000), 0},
+		{"first band", tiered, Kobo(1), 1000},
+		{"top of a band", tiered, Kobo(500000), 1000},
+		{"second band", tiered, Kobo(500001), 2500},
+		{"open ended band", tiered, Kobo(5000001), 25000},
+		{"no band covers it", (&FeeRule{Kind: FeeTiered, Bands: `[{"up_to":100,"flat":10}]`}).load(), Kobo(101), 0},
+	}
+
+	for _, c := range cases {
+		fee := c.rule.feeOn(c.amount)
+		if fee.Kobo != c.fee || fee.Currency != c.amount.Currency {
+			t.Errorf("%s: fee %d %s, want %d %s", c.name, fee.Kobo, fee.Currency, c.fee, c.amount.Currency)
+		}
+	}
+}
+
+func TestBestFeeRule(t *testing.T) {
+
+	tier := 1
+	rule := func(id uint, promo string, tier *int, priority int) *FeeRule {
+		r := &FeeRule{Promo: promo, Tier: tier, Priority: priority}
+		r.ID = id
+		return r
+	}
+
+	cases := []struct {
+		name string
+		rules []*FeeRule
+		best uint
+	}{
+		{"nothing", nil, 0},
+		{"promo over tier", []*FeeRule{rule(1, "", &tier, 10), rule(2, "LAUNCH", nil, 0)}, 2},
+		{"tier over all tiers", []*FeeRule{rule(1, "", nil, 10), rule(2, "", &tier, 0)}, 2},
+		{"priority", []*FeeRule{rule(1, "", nil, 5), rule(2, "", nil, 1)}, 1},
+		{"newest on a tie", []*FeeRule{rule(1, "", nil, 0), rule(2, "", nil, 0)}, 2},
+		{"promos by tier", []*FeeRule{rule(1, "LAUNCH", nil, 0), rule(2, "LAUNCH", &tier, 0)}, 2},
+		{"promos by priority", []*FeeRule{rule(1, "A", nil, 3), rule(2, "B", nil, 2)}, 1},
+	}
+
+	for _, c := range cases {
+		//The winner cannot depend on the order rules come back in
+		for _, order := range [][]*FeeRule {c.rules, reversed(c.rules)} {
+			best := bestFeeRule(order)
+			if (best == nil && c.best != 0) || (best != nil && best.ID != c.best) {
+				t.Errorf("%s: picked %+v, want rule %d", c.name, best, c.best)
+			}
+		}
+	}
+}
+
+func reversed(rules []*FeeRule) []*FeeRule {
+
+	out := make([]*FeeRule, len(rules))
+	for i, r := range rules {
+		out[len(rules) - 1 - i] = r
+	}
+
+	return out
+}
+
+//Every one of these is turned away before the rule is saved
+func TestCreateFeeRuleValidation(t *testing.T) {
+
+	start := time.Now()
+	end := start.Add(-time.Hour)
+	cases := []struct {
+		name string
+		payload *FeeRulePayload
+	}{
+		{"unknown operation", &FeeRulePayload{Operation: "airtime", Kind: FeeFlat, Flat: "10"}},
+		{"unknown kind", &FeeRulePayload{Operation: FeeTopUp, Kind: "sliding"}},
+		{"negative flat fee", &FeeRulePayload{Operation: FeeTopUp, Kind: FeeFlat, Flat: "-10"}},
+		{"too precise", &FeeRulePayload{Operation: FeeTopUp, Kind: FeeFlat, Flat: "10.001"}},
+		{"minimum over the cap", &FeeRulePayload{Operation: FeeTransfer, Kind: FeePercentage, BasisPoints: 100,
+			Min: "100", Cap: "50"}},
+		{"negative basis points", &FeeRulePayload{Operation: FeeTransfer, Kind: FeePercentage, BasisPoints: -1}},
+		{"over 100%", &FeeRulePayload{Operation: FeeTransfer, Kind: FeePercentage, BasisPoints: 10001}},
+		{"tiered without bands", &FeeRulePayload{Operation: FeeWithdrawal, Kind: FeeTiered}},
+		{"open band before the last", &FeeRulePayload{Operation: FeeWithdrawal, Kind: FeeTiered,
+			Bands: []FeeBand{{Flat: 100}, {UpTo: 500000, Flat: 200}}}},
+		{"bands out of order", &FeeRulePayload{Operation: FeeWithdrawal, Kind: FeeTiered,
+			Bands: []FeeBand{{UpTo: 500000, Flat: 100}, {UpTo: 100000, Flat: 200}, {Flat: 300}}}},
+		{"negative band", &FeeRulePayload{Operation: FeeWithdrawal, Kind: FeeTiered,
+			Bands: []FeeBand{{UpTo: 500000, Flat: -100}, {Flat: 300}}}},
+		{"ends before it starts", &FeeRulePayload{Operation: FeeTopUp, Kind: FeeFlat, Flat: "10",
+			StartsAt: &start, EndsAt: &end}},
+	}
+
+	for _, c := range cases {
+		rule, err := CreateFeeRule(c.payload)
+		if err == nil {
+			t.Errorf("%s: created rule %d", c.name, rule.ID)
+		}
+	}
+}
+
+//A promo only changes the fee for users who entered its code
+func TestFeePromoNeedsItsCode(t *testing.T) {
+
+	requireDb(t)
+	code := "TEST" + GenUniqueKey()
+	rule, err := CreateFeeRule(&FeeRulePayload{Operation: FeeTransfer, Waived: true, Promo: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := newTestAccount(t, 0)
+	other := newTestAccount(t, 0)
+	for _, user := range []uint {account.ID, other.ID} {
+		quote, err := QuoteFee(FeeTransfer, user, naira(1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if quote.RuleId == rule.ID {
+			t.Fatalf("promo %s applied to user %d without the code", code, user)
+		}
+	}
+
+	_, err = ApplyFeePromo(account.ID, "nope-" + code)
+	if err == nil {
+		t.Error("an unknown promo code was accepted")
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = ApplyFeePromo(account.ID, " " + code + " ")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	quote, err := QuoteFee(FeeTransfer, account.ID, naira(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if quote.RuleId != rule.ID || !quote.Fee.IsZero() || quote.Promo != rule.Promo {
+		t.Errorf("quote after entering the code %+v, want the waived promo", quote)
+	}
+
+	quote, err = QuoteFee(FeeTransfer, other.ID, naira(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if quote.RuleId == rule.ID {
+		t.Error("one user's promo code applied to another")
+	}
+}
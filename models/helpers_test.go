@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPin = "1234"
+
+var testAccounts int64
+
+//Tests in this package talk to the database named by DATABASE_URL and are skipped without one
+func requireDb(t *testing.T) {
+
+	t.Helper()
+	if Db == nil || Db.DB().Ping() != nil {
+		t.Skip("DATABASE_URL does not point at a reachable database")
+	}
+}
+
+//A top tier account with a pin, funded with kobo
+func newTestAccount(t *testing.T, kobo int64) *Account {
+
+	t.Helper()
+	n := atomic.AddInt64(&testAccounts, 1)
+	email := fmt.Sprintf("test-%d-%d@example.com", time.Now().UnixNano(), n)
+	account, err := CreateAccount(email, fmt.Sprintf("Test Account %d", n), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CreatePin(account.ID, testPin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Db.Table("accounts").Where("id = ?", account.ID).UpdateColumn("tier", MaxTier).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.Tier = MaxTier
+
+	if kobo > 0 {
+		err = FundAccount("TEST-" + GenUniqueKey(), account, Kobo(kobo), Kobo(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return account
+}
+
+func walletOf(t *testing.T, user uint) *Wallet {
+
+	t.Helper()
+	wallet := GetWallet(user)
+	if wallet == nil {
+		t.Fatalf("no wallet for user %d", user)
+	}
+
+	return wallet
+}
+
+func balanceOf(t *testing.T, user uint) int64 {
+	t.Helper()
+	return walletOf(t, user).Balance.Kobo
+}
+
+//Fail if a wallet's stored balance disagrees with its ledger
+func requireReconciled(t *testing.T, users ...uint) {
+
+	t.Helper()
+	for _, user := range users {
+		mismatch, err := CheckWalletBalance(walletOf(t, user))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mismatch != nil {
+			t.Errorf("wallet of user %d holds %s but its ledger says %s", user, mismatch.Balance, mismatch.LedgerBalance)
+		}
+	}
+}
+
+//Pay kobo from payer to payee with a token the payee claims and the payer authorizes
+func payWithToken(t *testing.T, payer, payee *Account, kobo int64) *TxToken {
+
+	t.Helper()
+	token := claimToken(t, payer, payee, kobo)
+	err := AuthorizePayment(payer.ID, &AuthorizePaymentPayload{Token: token.Token, Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return GetTxToken(token.Token)
+}
+
+//A token from payer claimed by payee for kobo, holding the money in the payer's wallet
+func claimToken(t *testing.T, payer, payee *Account, kobo int64) *TxToken {
+
+	t.Helper()
+	token, err := CreateToken(payer.ID, &CreateTokenPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RedeemToken(payee.ID, token.Token, Kobo(kobo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return GetTxToken(token.Token)
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	HoldActive = "active"
+	HoldReleased = "released" //the token was declined, cancelled or expired, or the withdrawal failed
+	HoldCaptured = "captured" //the token was authorized or the withdrawal paid out, and the money moved
+)
+
+//Money set aside in a wallet for a claimed token or a pending withdrawal. It still counts towards the
+//wallet's ledger balance but not its available balance, so it cannot be spent twice
+type Hold struct {
+	gorm.Model
+	WalletId uint `json:"wallet_id" gorm:"index"`
+	UserId uint `json:"user_id"`
+	TokenId uint `json:"token_id,omitempty" gorm:"index"`
+	Token string `json:"token,omitempty"`
+	WithdrawalId uint `json:"withdrawal_id,omitempty" gorm:"index"`
+	Reference string `json:"reference,omitempty"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Status string `json:"status" gorm:"index"`
+	ClosedAt *time.Time `json:"closed_at"`
+}
+
+//Set amount aside in wallet, locked in tx, for whatever hold says it is for. Fails with
+//ErrInsufficientFunds when the available balance does not cover it
+func placeHold(tx *gorm.DB, wallet *Wallet, amount Money, hold *Hold) error {
+
+	if !wallet.Balance.SameCurrency(amount) {
+		return errors.New("Cannot hold " + amount.Currency + " in a " + wallet.Balance.Currency + " wallet")
+	}
+
+	r := tx.Exec("UPDATE wallets SET held_kobo = held_kobo + ?, updated_at = NOW() WHERE id = ? AND balance_kobo - held_kobo >= ?",
+		amount.Kobo, wallet.ID, amount.Kobo)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected != 1 {
+		return ErrInsufficientFunds
+	}
+
+	hold.WalletId = wallet.ID
+	hold.UserId = wallet.UserId
+	hold.Amount = amount
+	hold.Status = HoldActive
+
+	err := tx.Create(hold).Error
+	if err != nil {
+		return err
+	}
+
+	wallet.Held = wallet.Held.Add(amount)
+	return nil
+}
+
+func holdForToken(token *TxToken) *Hold {
+	return &Hold{TokenId: token.ID, Token: token.Token}
+}
+
+func holdForWithdrawal(withdrawal *Withdrawal) *Hold {
+	return &Hold{WithdrawalId: withdrawal.ID, Reference: withdrawal.Reference}
+}
+
+//Close the active hold of token, if it has one, giving the money back to the available balance.
+//status says whether it was released or captured
+func closeHold(tx *gorm.DB, token *TxToken, status string) error {
+	return closeHoldWhere(tx, "token_id = ?", token.ID, status)
+}
+
+//Whether token still has money set aside for it. Claims made before holds existed have none
+func hasActiveHold(tx *gorm.DB, token *TxToken) (bool, error) {
+
+	count := 0
+	err := tx.Table("holds").Where("token_id = ? AND status = ?", token.ID, HoldActive).Count(&count).Error
+	return count > 0, err
+}
+
+func closeWithdrawalHold(tx *gorm.DB, withdrawal *Withdrawal, status string) error {
+	return closeHoldWhere(tx, "withdrawal_id = ?", withdrawal.ID, status)
+}
+
+func closeHoldWhere(tx *gorm.DB, query string, id uint, status string) error {
+
+	hold := &Hold{}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Table("holds").
+		Where(query + " AND status = ?", id, HoldActive).First(hold).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("UPDATE wallets SET held_kobo = held_kobo - ?, updated_at = NOW() WHERE id = ?",
+		hold.Amount.Kobo, hold.WalletId).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Table("holds").Where("id = ?", hold.ID).UpdateColumns(map[string] interface{} {
+		"status" : status, "closed_at" : time.Now()}).Error
+}
+
+func GetActiveHolds(user uint) []*Hold {
+
+	data := make([]*Hold, 0)
+	err := Db.Table("holds").Where("user_id = ? AND status = ?", user, HoldActive).Order("id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+//Wallets created before holds existed have nothing held
+func MigrateWalletHolds() error {
+	return Db.Exec("UPDATE wallets SET held_kobo = 0, held_currency = balance_currency WHERE held_kobo IS NULL").Error
+}
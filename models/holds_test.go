@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+)
+
+//A claim sets its money aside, so a second claim cannot spend it, and paying the claim takes exactly that
+func TestClaimHoldsFunds(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, naira(1000).Kobo)
+	payee := newTestAccount(t, 0)
+	other := newTestAccount(t, 0)
+
+	first := claimToken(t, payer, payee, naira(600).Kobo)
+	held := naira(600).Kobo + first.Fee.Kobo
+
+	wallet := walletOf(t, payer.ID)
+	if wallet.Balance.Kobo != naira(1000).Kobo || wallet.Held.Kobo != held || wallet.Available.Kobo != naira(1000).Kobo - held {
+		t.Errorf("after a claim the wallet holds %s with %s held and %s available", wallet.Balance, wallet.Held, wallet.Available)
+	}
+
+	holds := GetActiveHolds(payer.ID)
+	if len(holds) != 1 || holds[0].Amount.Kobo != held {
+		t.Errorf("active holds %+v, want one of %d", holds, held)
+	}
+
+	second, err := CreateToken(payer.ID, &CreateTokenPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RedeemToken(other.ID, second.Token, naira(600))
+	if err == nil {
+		t.Error("a second claim spent money already held for the first")
+	}
+
+	err = AuthorizePayment(payer.ID, &AuthorizePaymentPayload{Token: first.Token, Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wallet = walletOf(t, payer.ID)
+	if wallet.Balance.Kobo != naira(1000).Kobo - held || !wallet.Held.IsZero() {
+		t.Errorf("after paying the claim the wallet holds %s with %s held", wallet.Balance, wallet.Held)
+	}
+
+	if holds := GetActiveHolds(payer.ID); len(holds) != 0 {
+		t.Errorf("%d holds still active after the claim was paid", len(holds))
+	}
+
+	requireReconciled(t, payer.ID, payee.ID)
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"time"
+	"fmt"
+)
+
+//How long a stored response can be replayed for
+const IdempotencyKeyTTL = 24 * time.Hour
+
+var (
+	ErrIdempotencyKeyReused = errors.New("Idempotency-Key has already been used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("A request with this Idempotency-Key is still being processed")
+)
+
+//A client supplied Idempotency-Key and the response of the first request made with it
+type IdempotencyKey struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"unique_index:idx_idempotency_user_key"`
+	Key string `json:"key" gorm:"unique_index:idx_idempotency_user_key"`
+	Method string `json:"method"`
+	Path string `json:"path"`
+	RequestHash string `json:"request_hash"`
+	Completed bool `json:"completed"`
+	StatusCode int `json:"status_code"`
+	Response string `json:"response" gorm:"type:text"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+//Claim key for a request. replay is true when the request was seen before and its stored response should be
+//sent back as is. A key reused for a different request, or for one that has not finished, returns an error
+func BeginIdempotentRequest(user uint, key, method, path, hash string) (record *IdempotencyKey, replay bool, err error) {
+
+	record = &IdempotencyKey{}
+	record.UserId = user
+	record.Key = key
+	record.Method = method
+	record.Path = path
+	record.RequestHash = hash
+	record.ExpiresAt = time.Now().Add(IdempotencyKeyTTL)
+
+	//The unique index on (user_id, key) decides which of two concurrent requests gets the key
+	err = Db.Create(record).Error
+	if err == nil {
+		return record, false, nil
+	}
+
+	existing := &IdempotencyKey{}
+	err = Db.Table("idempotency_keys").Where("user_id = ? AND key = ?", user, key).First(existing).Error
+	if err != nil {
+		return nil, false, errors.New("Failed to process request at this time. Please retry")
+	}
+
+	if existing.ExpiresAt.Before(time.Now()) {
+		Db.Unscoped().Delete(existing)
+		return BeginIdempotentRequest(user, key, method, path, hash)
+	}
+
+	if existing.RequestHash != hash || existing.Method != method || existing.Path != path {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+
+	if !existing.Completed {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+
+	return existing, true, nil
+}
+
+//Store the response of a request so that retries get it back
+func CompleteIdempotentRequest(record *IdempotencyKey, status int, response []byte) error {
+
+	record.Completed = true
+	record.StatusCode = status
+	record.Response = string(response)
+
+	return Db.Table("idempotency_keys").Where("id = ?", record.ID).UpdateColumns(map[string] interface{} {
+		"completed" : true, "status_code" : status, "response" : record.Response}).Error
+}
+
+//Give up a claimed key so a retry can run the request again. Used when the request failed before doing anything
+func ReleaseIdempotencyKey(record *IdempotencyKey) error {
+	return Db.Unscoped().Delete(record).Error
+}
+
+func DeleteExpiredIdempotencyKeys() error {
+	return Db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{}).Error
+}
+
+//Garbage collect expired keys every hour
+func IdempotencyKeyWorker() {
+
+	for {
+
+		time.Sleep(time.Hour)
+		err := DeleteExpiredIdempotencyKeys()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
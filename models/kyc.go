@@ -0,0 +1,479 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"litepay/identity"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+	"fmt"
+)
+
+//KYC tiers. An account starts at 0 and each tier raises its limits.
+//	1 - a BVN or NIN whose record carries the account's name
+//	2 - as 1, with the date of birth matching as well
+//	3 - both a BVN and a NIN, each matching name and date of birth
+const MaxTier = 3
+
+const (
+	VerificationVerified = "verified"
+	VerificationFailed = "failed"
+	VerificationRevoked = "revoked" //was verified, until an admin downgraded the account
+	VerificationSuperseded = "superseded" //was verified, until the same number was verified again
+)
+
+//Lookups cost money, so each account gets a few a day
+const MaxVerificationAttemptsPerDay = 5
+
+//One check of a BVN or NIN against the registry, kept as evidence for the tier it led to
+type IdentityVerification struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"index"`
+	IdType string `json:"id_type"`
+	Number string `json:"number"` //masked
+	NumberHash string `json:"-" gorm:"index"`
+	DateOfBirth string `json:"date_of_birth"`
+	Provider string `json:"provider"`
+	Status string `json:"status"`
+	NameMatch bool `json:"name_match"`
+	DateOfBirthMatch bool `json:"date_of_birth_match"`
+	Evidence string `json:"evidence" gorm:"type:text"` //the registry record, number and phone masked
+	FailureReason string `json:"failure_reason"`
+	TierBefore int `json:"tier_before"`
+	TierAfter int `json:"tier_after"`
+}
+
+//Every change of an account's tier, who made it and why
+type TierChange struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"index"`
+	From int `json:"from"`
+	To int `json:"to"`
+	Actor uint `json:"actor"`
+	Reason string `json:"reason"`
+	VerificationId uint `json:"verification_id"`
+}
+
+type VerifyIdentityPayload struct {
+	IdType string `json:"id_type"`
+	Number string `json:"number"`
+	DateOfBirth string `json:"date_of_birth"` //2006-01-02
+}
+
+type DowngradeTierPayload struct {
+	Tier int `json:"tier"`
+	Reason string `json:"reason"`
+}
+
+type KycStatus struct {
+	Tier int `json:"tier"`
+	MaxTier int `json:"max_tier"`
+	Verifications []*IdentityVerification `json:"verifications"`
+	Changes []*TierChange `json:"changes"`
+}
+
+func hashIdentityNumber(idType, number string) string {
+	sum := sha256.Sum256([]byte(idType + ":" + number))
+	return hex.EncodeToString(sum[:])
+}
+
+//All but the last 4 digits starred out
+func maskIdentityNumber(number string) string {
+
+	if len(number) <= 4 {
+		return strings.Repeat("*", len(number))
+	}
+
+	return strings.Repeat("*", len(number) - 4) + number[len(number) - 4:]
+}
+
+//The registry record as it is kept and shown back to the user, with the number and phone masked
+func identityEvidence(record *identity.Identity) string {
+
+	evidence := *record
+	evidence.Number = maskIdentityNumber(evidence.Number)
+	evidence.Phone = maskIdentityNumber(evidence.Phone)
+
+	data, _ := json.Marshal(&evidence)
+	return string(data)
+}
+
+//Mask the evidence of verifications stored before evidence was masked. Safe to run on every start
+func MaskIdentityEvidence() error {
+
+	data := make([]*IdentityVerification, 0)
+	err := Db.Table("identity_verifications").Where("evidence <> '' AND evidence NOT LIKE ?", `%"number":"*%`).
+		Find(&data).Error
+	if err != nil {
+		return err
+	}
+
+	for _, verification := range data {
+
+		record := &identity.Identity{}
+		err = json.Unmarshal([]byte(verification.Evidence), record)
+		if err != nil {
+			return err
+		}
+
+		err = Db.Table("identity_verifications").Where("id = ?", verification.ID).
+			UpdateColumn("evidence", identityEvidence(record)).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func lockAccount(tx *gorm.DB, user uint) (*Account, error) {
+
+	account := &Account{}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Table("accounts").Where("id = ?", user).First(account).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+//The tier a set of verifications earns
+func tierFor(verifications []*IdentityVerification) int {
+
+	tier := 0
+	full := make(map[string] bool)
+	for _, v := range verifications {
+		if v.Status != VerificationVerified || !v.NameMatch {
+			continue
+		}
+
+		if tier < 1 {
+			tier = 1
+		}
+
+		if v.DateOfBirthMatch {
+			tier = 2
+			full[v.IdType] = true
+		}
+	}
+
+	if full[identity.IdBVN] && full[identity.IdNIN] {
+		tier = MaxTier
+	}
+
+	return tier
+}
+
+var ErrIdentityTaken = errors.New("This identity is already linked to another account")
+
+//Call it in the transaction that locks the user's account, so that parallel requests cannot all fit
+//under the cap
+func checkVerificationAttempts(tx *gorm.DB, user uint, hash string) error {
+
+	attempts := 0
+	err := tx.Table("identity_verifications").Where("user_id = ? AND created_at >= ?",
+		user, time.Now().Add(-24 * time.Hour)).Count(&attempts).Error
+	if err != nil {
+		return err
+	}
+
+	if attempts >= MaxVerificationAttemptsPerDay {
+		return errors.New("Too many verification attempts. Please try again tomorrow")
+	}
+
+	taken := 0
+	err = tx.Table("identity_verifications").Where("number_hash = ? AND user_id <> ? AND status = ?",
+		hash, user, VerificationVerified).Count(&taken).Error
+	if err != nil {
+		return err
+	}
+
+	if taken > 0 {
+		return ErrIdentityTaken
+	}
+
+	return nil
+}
+
+//An identity can be verified on one account only. The check in checkVerificationAttempts gives a clear
+//error, this index stops two accounts that verify the same number at once. Accounts that verified a
+//number more than once keep the latest check of it
+func MigrateVerifiedIdentities() error {
+
+	err := Db.Exec("UPDATE identity_verifications v SET status = ? WHERE status = ? AND deleted_at IS NULL " +
+		"AND EXISTS (SELECT 1 FROM identity_verifications n WHERE n.user_id = v.user_id AND n.number_hash = v.number_hash " +
+		"AND n.status = ? AND n.deleted_at IS NULL AND n.id > v.id)",
+		VerificationSuperseded, VerificationVerified, VerificationVerified).Error
+	if err != nil {
+		return err
+	}
+
+	return Db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS uix_identity_verifications_verified_number " +
+		"ON identity_verifications (number_hash) WHERE status = 'verified' AND deleted_at IS NULL").Error
+}
+
+//Look up a BVN or NIN, match it against the account's name and the date of birth given, and raise the
+//account's tier if the result earns it. Failed matches are stored as well and returned with an error
+func VerifyIdentity(user uint, payload *VerifyIdentityPayload) (*IdentityVerification, error) {
+
+	if IdentityVerifier == nil {
+		return nil, errors.New("Identity verification is not available at this time")
+	}
+
+	idType := strings.ToLower(strings.TrimSpace(payload.IdType))
+	number := strings.TrimSpace(payload.Number)
+	err := identity.ValidNumber(idType, number)
+	if err != nil {
+		return nil, errors.New("Id type should be bvn or nin, with an 11 digit number")
+	}
+
+	dob := strings.TrimSpace(payload.DateOfBirth)
+	_, err = time.Parse(identity.DateLayout, dob)
+	if err != nil {
+		return nil, errors.New("Date of birth should look like 1990-12-31")
+	}
+
+	account := GetAccount(user)
+	if account == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	//The account row stays locked through the lookup, so that the attempt cap holds and two verifications
+	//finishing together agree on the tier
+	tx := Db.Begin()
+	err = tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := lockAccount(tx, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	hash := hashIdentityNumber(idType, number)
+	err = checkVerificationAttempts(tx, user, hash)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	verification := &IdentityVerification{}
+	verification.UserId = user
+	verification.IdType = idType
+	verification.Number = maskIdentityNumber(number)
+	verification.NumberHash = hash
+	verification.DateOfBirth = dob
+	verification.Provider = IdentityVerifier.Name()
+	verification.Status = VerificationFailed
+
+	record, err := IdentityVerifier.Lookup(idType, number)
+	switch {
+	case err == identity.ErrIdentityNotFound:
+		verification.FailureReason = fmt.Sprintf("No record found for this %s", strings.ToUpper(idType))
+	case err != nil:
+		tx.Rollback()
+		fmt.Printf("Identity lookup for user %d failed. %s\n", user, err.Error())
+		return nil, errors.New("Cannot verify identity at this time. Please retry")
+	default:
+		verification.Evidence = identityEvidence(record)
+
+		match := identity.Compare(record, account.Fullname, dob)
+		verification.NameMatch = match.NameMatch
+		verification.DateOfBirthMatch = match.DateOfBirthMatch
+		if match.NameMatch {
+			verification.Status = VerificationVerified
+		} else {
+			verification.FailureReason = fmt.Sprintf("The name on this %s does not match your account", strings.ToUpper(idType))
+		}
+	}
+
+	//Checking the same number again replaces the earlier check of it
+	if verification.Status == VerificationVerified {
+		err = tx.Table("identity_verifications").Where("user_id = ? AND number_hash = ? AND status = ?",
+			user, hash, VerificationVerified).UpdateColumn("status", VerificationSuperseded).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	verification.TierBefore = locked.Tier
+	verification.TierAfter = locked.Tier
+	err = tx.Create(verification).Error
+	if isUniqueViolation(err) {
+		tx.Rollback()
+		return nil, ErrIdentityTaken
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("Cannot verify identity at this time. Please retry")
+	}
+
+	verifications := make([]*IdentityVerification, 0)
+	err = tx.Table("identity_verifications").Where("user_id = ? AND status = ?", user, VerificationVerified).
+		Find(&verifications).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tier := tierFor(verifications)
+	if tier > locked.Tier {
+		verification.TierAfter = tier
+		err = changeTier(tx, locked, tier, user, fmt.Sprintf("%s verified", strings.ToUpper(idType)), verification.ID)
+		if err == nil {
+			err = tx.Table("identity_verifications").Where("id = ?", verification.ID).UpdateColumn("tier_after", tier).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	if verification.Status != VerificationVerified {
+		return verification, errors.New(verification.FailureReason)
+	}
+
+	if verification.TierAfter > verification.TierBefore {
+		notifyTierChange(account, verification.TierBefore, verification.TierAfter, "")
+	}
+
+	return verification, nil
+}
+
+func changeTier(tx *gorm.DB, account *Account, tier int, actor uint, reason string, verification uint) error {
+
+	err := tx.Table("accounts").Where("id = ?", account.ID).UpdateColumn("tier", tier).Error
+	if err != nil {
+		return err
+	}
+
+	change := &TierChange{}
+	change.UserId = account.ID
+	change.From = account.Tier
+	change.To = tier
+	change.Actor = actor
+	change.Reason = reason
+	change.VerificationId = verification
+
+	err = tx.Create(change).Error
+	if err != nil {
+		return err
+	}
+
+	account.Tier = tier
+	return nil
+}
+
+//Move an account down to a lower tier. Its verifications are revoked, so it has to verify again to
+//climb back up
+func DowngradeTier(admin, user uint, payload *DowngradeTierPayload) (*Account, error) {
+
+	reason := strings.TrimSpace(payload.Reason)
+	if reason == "" {
+		return nil, errors.New("A reason is required to downgrade an account")
+	}
+
+	if len(reason) > maxCloseReasonLength {
+		return nil, errors.New(fmt.Sprintf("Reason should not be longer than %d characters", maxCloseReasonLength))
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := lockAccount(tx, user)
+	if err == gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return nil, errors.New("Account not found")
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if payload.Tier < 0 || payload.Tier >= locked.Tier {
+		tx.Rollback()
+		return nil, errors.New(fmt.Sprintf("Tier should be between 0 and %d", locked.Tier - 1))
+	}
+
+	from := locked.Tier
+	err = changeTier(tx, locked, payload.Tier, admin, reason, 0)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Table("identity_verifications").Where("user_id = ? AND status = ?", user, VerificationVerified).
+		UpdateColumn("status", VerificationRevoked).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	account := GetAccount(user)
+	if account != nil {
+		notifyTierChange(account, from, payload.Tier, reason)
+	}
+
+	return account, nil
+}
+
+func notifyTierChange(account *Account, from, to int, reason string) {
+
+	mail := &MailRequest{}
+	mail.Subject = "LitePay - Account Tier Changed"
+	if to > from {
+		mail.Body = fmt.Sprintf("Your identity has been verified. Your account is now tier %d and your limits have been raised", to)
+	} else {
+		mail.Body = fmt.Sprintf("Your account has been moved from tier %d to tier %d and your limits have been lowered. Reason: %s",
+			from, to, reason)
+	}
+	mail.To = account.Email
+	mail.Name = account.Fullname
+
+	MailQueue <- mail
+}
+
+//The account's tier with the verifications and changes that led to it, newest first
+func GetKycStatus(user uint) *KycStatus {
+
+	account := GetAccount(user)
+	if account == nil {
+		return nil
+	}
+
+	status := &KycStatus{Tier: account.Tier, MaxTier: MaxTier}
+	status.Verifications = make([]*IdentityVerification, 0)
+	err := Db.Table("identity_verifications").Where("user_id = ?", user).Order("id desc").Find(&status.Verifications).Error
+	if err != nil {
+		return nil
+	}
+
+	status.Changes = make([]*TierChange, 0)
+	err = Db.Table("tier_changes").Where("user_id = ?", user).Order("id desc").Find(&status.Changes).Error
+	if err != nil {
+		return nil
+	}
+
+	return status
+}
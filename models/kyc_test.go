@@ -0,0 +1,174 @@
+package models
+
+import (
+	"litepay/identity"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//Swap in the seeded fake registry, which knows "Test User", for the length of the test
+func useSeededIdentities(t *testing.T) {
+
+	t.Helper()
+	previous := IdentityVerifier
+	IdentityVerifier = identity.NewSeededFakeProvider()
+	t.Cleanup(func() {
+		IdentityVerifier = previous
+	})
+}
+
+func newTestUser(t *testing.T) *Account {
+
+	t.Helper()
+	n := atomic.AddInt64(&testAccounts, 1)
+	account, err := CreateAccount(fmt.Sprintf("user-%d-%d@example.com", time.Now().UnixNano(), n), "Test User", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return account
+}
+
+//Two accounts verifying the same BVN at once cannot both be linked to it
+func TestIdentityVerifiesOnOneAccount(t *testing.T) {
+
+	requireDb(t)
+	useSeededIdentities(t)
+
+	//A number verified by an earlier run would turn every attempt away
+	err := Db.Table("identity_verifications").Where("number_hash = ?", hashIdentityNumber(identity.IdBVN, "22222222222")).
+		UpdateColumn("status", VerificationRevoked).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var verified int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		account := newTestUser(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := VerifyIdentity(account.ID, &VerifyIdentityPayload{IdType: "bvn", Number: "22222222222", DateOfBirth: "1990-01-01"})
+			if err == nil {
+				atomic.AddInt64(&verified, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if verified != 1 {
+		t.Errorf("%d accounts verified the same BVN, want 1", verified)
+	}
+}
+
+//Lookups cost money, so parallel requests cannot get past the daily cap
+func TestVerificationAttemptsAreCappedUnderLoad(t *testing.T) {
+
+	requireDb(t)
+	useSeededIdentities(t)
+	account := newTestUser(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < MaxVerificationAttemptsPerDay * 2; i++ {
+		number := fmt.Sprintf("3%010d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			VerifyIdentity(account.ID, &VerifyIdentityPayload{IdType: "nin", Number: number, DateOfBirth: "1990-01-01"})
+		}()
+	}
+	wg.Wait()
+
+	attempts := 0
+	err := Db.Table("identity_verifications").Where("user_id = ?", account.ID).Count(&attempts).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != MaxVerificationAttemptsPerDay {
+		t.Errorf("%d lookups stored, want %d", attempts, MaxVerificationAttemptsPerDay)
+	}
+}
+
+func TestTierFor(t *testing.T) {
+
+	check := func(idType, status string, name, dob bool) *IdentityVerification {
+		return &IdentityVerification{IdType: idType, Status: status, NameMatch: name, DateOfBirthMatch: dob}
+	}
+
+	bvn, nin := identity.IdBVN, identity.IdNIN
+	cases := []struct {
+		name string
+		verifications []*IdentityVerification
+		tier int
+	}{
+		{"nothing", nil, 0},
+		{"failed", []*IdentityVerification{check(bvn, VerificationFailed, false, false)}, 0},
+		{"name only", []*IdentityVerification{check(bvn, VerificationVerified, true, false)}, 1},
+		{"name and date of birth", []*IdentityVerification{check(nin, VerificationVerified, true, true)}, 2},
+		{"date of birth without name", []*IdentityVerification{check(bvn, VerificationVerified, false, true)}, 0},
+		{"revoked", []*IdentityVerification{check(bvn, VerificationRevoked, true, true)}, 0},
+		{"superseded", []*IdentityVerification{check(bvn, VerificationSuperseded, true, true)}, 0},
+		{"bvn and nin in full", []*IdentityVerification{check(bvn, VerificationVerified, true, true),
+			check(nin, VerificationVerified, true, true)}, MaxTier},
+		{"bvn in full, nin name only", []*IdentityVerification{check(bvn, VerificationVerified, true, true),
+			check(nin, VerificationVerified, true, false)}, 2},
+		{"two bvns", []*IdentityVerification{check(bvn, VerificationVerified, true, true),
+			check(bvn, VerificationVerified, true, true)}, 2},
+		{"nin revoked", []*IdentityVerification{check(bvn, VerificationVerified, true, true),
+			check(nin, VerificationRevoked, true, true)}, 2},
+	}
+
+	for _, c := range cases {
+		if tier := tierFor(c.verifications); tier != c.tier {
+			t.Errorf("%s: tier %d, want %d", c.name, tier, c.tier)
+		}
+	}
+}
+
+func TestMaskIdentityNumber(t *testing.T) {
+
+	cases := map[string] string {
+		"22222222222" : "*******2222",
+		"08012345678" : "*******5678",
+		"12345" : "*2345",
+		"1234" : "****",
+		"12" : "**",
+		"" : "",
+	}
+
+	for number, masked := range cases {
+		if got := maskIdentityNumber(number); got != masked {
+			t.Errorf("maskIdentityNumber(%q) = %q, want %q", number, got, masked)
+		}
+	}
+}
+
+//Evidence keeps the record, but not the number or phone in full
+func TestIdentityEvidence(t *testing.T) {
+
+	record := &identity.Identity{IdType: identity.IdBVN, Number: "22222222222", FirstName: "Test", LastName: "User",
+		DateOfBirth: "1990-01-01", Phone: "08012345678"}
+	evidence := identityEvidence(record)
+
+	for _, full := range []string {"22222222222", "08012345678"} {
+		if strings.Contains(evidence, full) {
+			t.Errorf("evidence %s holds %s in full", evidence, full)
+		}
+	}
+
+	for _, kept := range []string {"*******2222", "*******5678", "Test", "User", "1990-01-01"} {
+		if !strings.Contains(evidence, kept) {
+			t.Errorf("evidence %s is missing %s", evidence, kept)
+		}
+	}
+
+	if record.Number != "22222222222" {
+		t.Error("masking changed the record it was given")
+	}
+}
@@ -0,0 +1,381 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"fmt"
+)
+
+//Kinds of ledger accounts. Assets grow on debit, liabilities and revenue grow on credit
+const (
+	LedgerAsset = "asset"
+	LedgerLiability = "liability"
+	LedgerRevenue = "revenue"
+)
+
+//Codes of the system accounts every wallet movement is balanced against
+const (
+	PaystackFloatAccount = "system:paystack_float"
+	FeesAccount = "system:fees"
+	SuspenseAccount = "system:suspense"
+)
+
+const (
+	Debit = "debit"
+	Credit = "credit"
+)
+
+//Kinds of journal entries
+const (
+	EntryOpeningBalance = "opening_balance"
+	EntryTopUp = "topup"
+	EntryPayment = "payment"
+	EntryRefund = "refund"
+	EntryWithdrawal = "withdrawal"
+	EntryWithdrawalReversal = "withdrawal_reversal"
+	EntryFee = "fee"
+	EntryUnmatchedReceipt = "unmatched_receipt"
+	EntryHeldReceipt = "held_receipt" //a top up paid past the account's limits, parked to be refunded
+)
+
+var systemAccounts = map[string] *LedgerAccount {
+	PaystackFloatAccount : {Name: "Paystack Float", Kind: LedgerAsset},
+	FeesAccount : {Name: "Fees", Kind: LedgerRevenue},
+	SuspenseAccount : {Name: "Suspense", Kind: LedgerAsset},
+}
+
+//An account in the double-entry ledger. Every wallet has exactly one, code 'wallet:{id}'
+type LedgerAccount struct {
+	gorm.Model
+	Code string `json:"code" gorm:"unique_index"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Currency string `json:"currency"`
+	WalletId uint `json:"wallet_id"`
+}
+
+//A journal entry groups the lines of a single money movement. Debits and credits must balance
+type JournalEntry struct {
+	gorm.Model
+	Kind string `json:"kind"`
+	Reference string `json:"reference"`
+	Memo string `json:"memo"`
+
+	Lines []*JournalLine `sql:"-" gorm:"-" json:"lines"`
+}
+
+type JournalLine struct {
+	gorm.Model
+	EntryId uint `json:"entry_id"`
+	AccountId uint `json:"account_id"`
+	Direction string `json:"direction"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+}
+
+func NewJournalEntry(kind, ref, memo string) *JournalEntry {
+
+	entry := &JournalEntry{}
+	entry.Kind = kind
+	entry.Reference = ref
+	entry.Memo = memo
+	entry.Lines = make([]*JournalLine, 0)
+
+	return entry
+}
+
+func (entry *JournalEntry) Debit(account *LedgerAccount, amount Money) *JournalEntry {
+	entry.Lines = append(entry.Lines, &JournalLine{AccountId: account.ID, Direction: Debit, Amount: amount})
+	return entry
+}
+
+func (entry *JournalEntry) Credit(account *LedgerAccount, amount Money) *JournalEntry {
+	entry.Lines = append(entry.Lines, &JournalLine{AccountId: account.ID, Direction: Credit, Amount: amount})
+	return entry
+}
+
+//Check that an entry has at least a debit and a credit, and that they sum to the same amount
+func (entry *JournalEntry) Validate() error {
+
+	if len(entry.Lines) < 2 {
+		return errors.New("Journal entry should have at least two lines")
+	}
+
+	currency := entry.Lines[0].Amount.Currency
+	var debits, credits int64 = 0, 0
+	for _, line := range entry.Lines {
+		if line.AccountId <= 0 {
+			return errors.New("Journal line has no ledger account")
+		}
+
+		if !line.Amount.IsPositive() {
+			return errors.New("Journal line amount should be > 0")
+		}
+
+		if line.Amount.Currency != currency {
+			return errors.New("Journal entry lines should share a currency")
+		}
+
+		switch line.Direction {
+		case Debit:
+			debits += line.Amount.Kobo
+		case Credit:
+			credits += line.Amount.Kobo
+		default:
+			return errors.New(fmt.Sprintf("Unknown journal line direction '%s'", line.Direction))
+		}
+	}
+
+	if debits != credits {
+		return errors.New(fmt.Sprintf("Unbalanced journal entry. debits = %s, credits = %s",
+			NewMoney(debits, currency), NewMoney(credits, currency)))
+	}
+
+	return nil
+}
+
+//Post a journal entry. tx should be the same transaction that mutates the wallets
+func PostJournalEntry(tx *gorm.DB, entry *JournalEntry) error {
+
+	err := entry.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Create(entry).Error
+	if err != nil {
+		return err
+	}
+
+	for _, line := range entry.Lines {
+		line.EntryId = entry.ID
+		err = tx.Create(line).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Find a system account by its code, creating it the first time it is needed
+func GetSystemAccount(tx *gorm.DB, code string) (*LedgerAccount, error) {
+
+	template, ok := systemAccounts[code]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown system account '%s'", code))
+	}
+
+	account := &LedgerAccount{}
+	err := tx.Table("ledger_accounts").Where("code = ?", code).First(account).Error
+	if err == nil {
+		return account, nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	account.Code = code
+	account.Name = template.Name
+	account.Kind = template.Kind
+	account.Currency = DefaultCurrency
+	err = tx.Create(account).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+//Find the ledger account backing a wallet, creating it the first time it is needed
+func GetWalletLedgerAccount(tx *gorm.DB, wallet *Wallet) (*LedgerAccount, error) {
+
+	code := fmt.Sprintf("wallet:%d", wallet.ID)
+	account := &LedgerAccount{}
+	err := tx.Table("ledger_accounts").Where("code = ?", code).First(account).Error
+	if err == nil {
+		return account, nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	account.Code = code
+	account.Name = fmt.Sprintf("Wallet of user %d", wallet.UserId)
+	account.Kind = LedgerLiability
+	account.Currency = wallet.Balance.Currency
+	account.WalletId = wallet.ID
+	err = tx.Create(account).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+//Derive the balance of a ledger account from its journal lines
+func LedgerBalance(db *gorm.DB, account *LedgerAccount) (Money, error) {
+
+	type result struct {
+		Debits int64
+		Credits int64
+	}
+
+	r := &result{}
+	err := db.Table("journal_lines").
+		Select("COALESCE(SUM(CASE WHEN direction = ? THEN amount_kobo ELSE 0 END), 0) AS debits, " +
+			"COALESCE(SUM(CASE WHEN direction = ? THEN amount_kobo ELSE 0 END), 0) AS credits", Debit, Credit).
+		Where("account_id = ? AND amount_currency = ? AND deleted_at IS NULL", account.ID, account.Currency).Scan(r).Error
+	if err != nil {
+		return Money{}, err
+	}
+
+	if account.Kind == LedgerAsset {
+		return NewMoney(r.Debits - r.Credits, account.Currency), nil
+	}
+
+	return NewMoney(r.Credits - r.Debits, account.Currency), nil
+}
+
+//Journal lines posted against a ledger account, most recent first
+func GetLedgerLines(account *LedgerAccount) []*JournalLine {
+
+	data := make([]*JournalLine, 0)
+	err := Db.Table("journal_lines").Where("account_id = ?", account.ID).Order("id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+//Ledger history of a user's wallet
+func GetWalletStatement(user uint) []*JournalLine {
+
+	wallet := GetWallet(user)
+	if wallet == nil {
+		return nil
+	}
+
+	account, err := GetWalletLedgerAccount(Db, wallet)
+	if err != nil {
+		return nil
+	}
+
+	return GetLedgerLines(account)
+}
+
+type LedgerMismatch struct {
+	WalletId uint `json:"wallet_id"`
+	UserId uint `json:"user_id"`
+	Balance Money `json:"balance"`
+	LedgerBalance Money `json:"ledger_balance"`
+}
+
+//Check a wallet's stored balance against the balance derived from the ledger
+func CheckWalletBalance(wallet *Wallet) (*LedgerMismatch, error) {
+
+	account, err := GetWalletLedgerAccount(Db, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := LedgerBalance(Db, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if balance == wallet.Balance {
+		return nil, nil
+	}
+
+	return &LedgerMismatch{WalletId: wallet.ID, UserId: wallet.UserId,
+		Balance: wallet.Balance, LedgerBalance: balance}, nil
+}
+
+//Check every wallet against the ledger and return the ones that disagree
+func ReconcileWallets() ([]*LedgerMismatch, error) {
+
+	wallets := make([]*Wallet, 0)
+	err := Db.Table("wallets").Find(&wallets).Error
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]*LedgerMismatch, 0)
+	for _, wallet := range wallets {
+		m, err := CheckWalletBalance(wallet)
+		if err != nil {
+			return nil, err
+		}
+
+		if m != nil {
+			mismatches = append(mismatches, m)
+		}
+	}
+
+	return mismatches, nil
+}
+
+//Wallets funded before the ledger existed have no history. Give each one an opening
+//balance entry against suspense so that their balances can be checked against the ledger
+func OpenLedgerForExistingWallets() error {
+
+	wallets := make([]*Wallet, 0)
+	err := Db.Table("wallets").Where("balance_kobo <> 0").Find(&wallets).Error
+	if err != nil {
+		return err
+	}
+
+	for _, wallet := range wallets {
+
+		count := 0
+		err = Db.Table("ledger_accounts").Where("wallet_id = ?", wallet.ID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		tx := Db.Begin()
+		err = tx.Error
+		if err != nil {
+			return err
+		}
+
+		err = postOpeningBalance(tx, wallet)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		tx.Commit()
+	}
+
+	return nil
+}
+
+func postOpeningBalance(tx *gorm.DB, wallet *Wallet) error {
+
+	account, err := GetWalletLedgerAccount(tx, wallet)
+	if err != nil {
+		return err
+	}
+
+	suspense, err := GetSystemAccount(tx, SuspenseAccount)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryOpeningBalance, account.Code, "Balance carried over from before the ledger")
+	if wallet.Balance.IsPositive() {
+		entry.Debit(suspense, wallet.Balance).Credit(account, wallet.Balance)
+	} else {
+		entry.Debit(account, wallet.Balance.Neg()).Credit(suspense, wallet.Balance.Neg())
+	}
+
+	return PostJournalEntry(tx, entry)
+}
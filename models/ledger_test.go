@@ -0,0 +1,110 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestJournalEntryValidate(t *testing.T) {
+
+	wallet, payee, fees := &LedgerAccount{}, &LedgerAccount{}, &LedgerAccount{}
+	wallet.ID, payee.ID, fees.ID = 1, 2, 3
+
+	cases := []struct {
+		name string
+		entry *JournalEntry
+		valid bool
+	}{
+		{"balanced", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(100)).Credit(payee, naira(100)), true},
+		{"split credit", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(101)).
+			Credit(payee, naira(100)).Credit(fees, naira(1)), true},
+		{"more debited", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(101)).Credit(payee, naira(100)), false},
+		{"more credited", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(100)).
+			Credit(payee, naira(100)).Credit(fees, Kobo(1)), false},
+		{"one line", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(100)), false},
+		{"no lines", NewJournalEntry(EntryPayment, "ref", ""), false},
+		{"zero lines", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, Kobo(0)).Credit(payee, Kobo(0)), false},
+		{"negative lines", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, Kobo(-100)).Credit(payee, Kobo(-100)), false},
+		{"two currencies", NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(100)).
+			Credit(payee, NewMoney(10000, "USD")), false},
+		{"no account", NewJournalEntry(EntryPayment, "ref", "").Debit(&LedgerAccount{}, naira(100)).Credit(payee, naira(100)), false},
+	}
+
+	for _, c := range cases {
+		if err := c.entry.Validate(); (err == nil) != c.valid {
+			t.Errorf("%s: Validate returned %v, want valid %v", c.name, err, c.valid)
+		}
+	}
+
+	odd := NewJournalEntry(EntryPayment, "ref", "").Debit(wallet, naira(100)).Credit(payee, naira(100))
+	odd.Lines[1].Direction = "sideways"
+	if odd.Validate() == nil {
+		t.Error("a line that is neither a debit nor a credit was accepted")
+	}
+}
+
+type entryTotals struct {
+	EntryId uint
+	Kind string
+	Debits int64
+	Credits int64
+}
+
+//Debits and credits of every journal entry posted under ref, whatever path posted them
+func entryTotalsFor(t *testing.T, ref string) map[string] entryTotals {
+
+	t.Helper()
+	rows := make([]entryTotals, 0)
+	err := Db.Raw("SELECT e.id AS entry_id, e.kind, " +
+		"SUM(CASE WHEN l.direction = ? THEN l.amount_kobo ELSE 0 END) AS debits, " +
+		"SUM(CASE WHEN l.direction = ? THEN l.amount_kobo ELSE 0 END) AS credits " +
+		"FROM journal_entries e JOIN journal_lines l ON l.entry_id = e.id " +
+		"WHERE e.reference = ? AND e.deleted_at IS NULL AND l.deleted_at IS NULL GROUP BY e.id, e.kind", Debit, Credit, ref).
+		Scan(&rows).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totals := make(map[string] entryTotals)
+	for _, row := range rows {
+		if row.Debits != row.Credits {
+			t.Errorf("%s entry %d under %s debits %d and credits %d", row.Kind, row.EntryId, ref, row.Debits, row.Credits)
+		}
+		totals[row.Kind] = row
+	}
+
+	return totals
+}
+
+func TestTopUpAndPaymentPostingsBalance(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, 0)
+	payee := newTestAccount(t, 0)
+
+	ref := "TEST-" + GenUniqueKey()
+	err := FundAccount(ref, payer, naira(5000), naira(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topUp := entryTotalsFor(t, ref)
+	if topUp[EntryTopUp].Debits != naira(5000).Kobo {
+		t.Errorf("top up posted %d, want %d", topUp[EntryTopUp].Debits, naira(5000).Kobo)
+	}
+
+	if topUp[EntryFee].Debits != naira(50).Kobo {
+		t.Errorf("top up fee posted %d, want %d", topUp[EntryFee].Debits, naira(50).Kobo)
+	}
+
+	token := payWithToken(t, payer, payee, naira(1200).Kobo)
+	payment := entryTotalsFor(t, token.Token)
+	if payment[EntryPayment].Debits != naira(1200).Kobo {
+		t.Errorf("payment posted %d, want %d", payment[EntryPayment].Debits, naira(1200).Kobo)
+	}
+
+	if payment[EntryFee].Debits != token.Fee.Kobo {
+		t.Errorf("payment fee posted %d, want the quoted %d", payment[EntryFee].Debits, token.Fee.Kobo)
+	}
+
+	requireReconciled(t, payer.ID, payee.ID)
+}
@@ -0,0 +1,331 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"fmt"
+	"time"
+)
+
+//The limits an account is held to
+const (
+	LimitSingleTransaction = "single_transaction"
+	LimitDailyOutflow = "daily_outflow"
+	LimitMonthlyOutflow = "monthly_outflow"
+	LimitDailyInflow = "daily_inflow"
+	LimitMonthlyInflow = "monthly_inflow"
+	LimitMaxBalance = "max_balance"
+)
+
+var limitNames = map[string] string {
+	LimitSingleTransaction : "Single transaction",
+	LimitDailyOutflow : "Daily spending",
+	LimitMonthlyOutflow : "Monthly spending",
+	LimitDailyInflow : "Daily receiving",
+	LimitMonthlyInflow : "Monthly receiving",
+	LimitMaxBalance : "Maximum wallet balance",
+}
+
+//Caps for an account tier. A zero amount means no cap. Outflow is money paid or withdrawn out of the
+//wallet, inflow is money topped up or received into it. Fees and refunds count towards neither
+type TierLimits struct {
+	SingleTransaction Money
+	DailyOutflow Money
+	MonthlyOutflow Money
+	DailyInflow Money
+	MonthlyInflow Money
+	MaxBalance Money
+}
+
+func naira(n int64) Money {
+	return Kobo(n * 100)
+}
+
+//Limits by KYC tier, see MaxTier. Accounts above the highest tier listed get its limits
+var tierLimits = []*TierLimits {
+	{SingleTransaction: naira(10000), DailyOutflow: naira(20000), MonthlyOutflow: naira(100000),
+		DailyInflow: naira(20000), MonthlyInflow: naira(100000), MaxBalance: naira(50000)},
+	{SingleTransaction: naira(50000), DailyOutflow: naira(50000), MonthlyOutflow: naira(300000),
+		DailyInflow: naira(50000), MonthlyInflow: naira(300000), MaxBalance: naira(300000)},
+	{SingleTransaction: naira(200000), DailyOutflow: naira(200000), MonthlyOutflow: naira(1000000),
+		DailyInflow: naira(200000), MonthlyInflow: naira(1000000), MaxBalance: naira(500000)},
+	{SingleTransaction: naira(5000000), DailyOutflow: naira(5000000), MonthlyOutflow: naira(50000000),
+		DailyInflow: naira(5000000), MonthlyInflow: naira(50000000)},
+}
+
+func limitsForTier(tier int) *TierLimits {
+
+	if tier < 0 {
+		tier = 0
+	}
+
+	if tier >= len(tierLimits) {
+		tier = len(tierLimits) - 1
+	}
+
+	return tierLimits[tier]
+}
+
+//An operation that would take an account over one of its limits. Carries what is left so the
+//client can offer a smaller amount
+type LimitError struct {
+	Limit string `json:"limit"`
+	Max Money `json:"max"`
+	Used Money `json:"used"`
+	Remaining Money `json:"remaining"`
+	Requested Money `json:"requested"`
+	ResetsAt *time.Time `json:"resets_at,omitempty"`
+}
+
+func (e *LimitError) Error() string {
+
+	if e.Limit == LimitSingleTransaction {
+		return fmt.Sprintf("%s limit exceeded. You can move at most %s at once", limitNames[e.Limit], e.Max)
+	}
+
+	return fmt.Sprintf("%s limit exceeded. %s remaining", limitNames[e.Limit], e.Remaining)
+}
+
+//Where an account stands against one of its limits
+type LimitUsage struct {
+	Limit string `json:"limit"`
+	Name string `json:"name"`
+	Unlimited bool `json:"unlimited"`
+	Max Money `json:"max"`
+	Used Money `json:"used"`
+	Remaining Money `json:"remaining"`
+	ResetsAt *time.Time `json:"resets_at,omitempty"`
+}
+
+type AccountLimits struct {
+	Tier int `json:"tier"`
+	Limits []*LimitUsage `json:"limits"`
+}
+
+func startOfDay(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+func startOfMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+}
+
+func accountTier(db *gorm.DB, user uint) (int, error) {
+
+	account := &Account{}
+	err := db.Table("accounts").Select("tier").Where("id = ?", user).First(account).Error
+	return account.Tier, err
+}
+
+//Sum of the wallet's journal lines in one direction for the given kinds of entry since a time
+func ledgerFlowSince(db *gorm.DB, wallet *Wallet, direction string, kinds []string, since time.Time) (int64, error) {
+
+	type result struct {
+		Total int64
+	}
+
+	r := &result{}
+	err := db.Table("journal_lines").Select("COALESCE(SUM(journal_lines.amount_kobo), 0) AS total").
+		Joins("JOIN journal_entries ON journal_entries.id = journal_lines.entry_id").
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = journal_lines.account_id").
+		Where("ledger_accounts.wallet_id = ? AND journal_lines.direction = ? AND journal_entries.kind IN (?) " +
+			"AND journal_lines.created_at >= ? AND journal_lines.deleted_at IS NULL", wallet.ID, direction, kinds, since).
+		Scan(r).Error
+
+	return r.Total, err
+}
+
+//Money paid or withdrawn out of the wallet since a time. Pending withdrawals already hold their
+//amount, so they count as well
+func outflowSince(db *gorm.DB, wallet *Wallet, since time.Time) (Money, error) {
+
+	type result struct {
+		Total int64
+	}
+
+	posted, err := ledgerFlowSince(db, wallet, Debit, []string {EntryPayment, EntryWithdrawal}, since)
+	if err != nil {
+		return Money{}, err
+	}
+
+	r := &result{}
+	err = db.Table("withdrawals").Select("COALESCE(SUM(amount_kobo), 0) AS total").
+		Where("user_id = ? AND status = ? AND created_at >= ? AND deleted_at IS NULL", wallet.UserId, WithdrawalPending, since).
+		Scan(r).Error
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Kobo(posted + r.Total), nil
+}
+
+//Money topped up or received into the wallet since a time
+func inflowSince(db *gorm.DB, wallet *Wallet, since time.Time) (Money, error) {
+
+	posted, err := ledgerFlowSince(db, wallet, Credit, []string {EntryTopUp, EntryPayment}, since)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Kobo(posted), nil
+}
+
+func windowLimit(name string, max, used, amount Money, resets time.Time) error {
+
+	if !max.IsPositive() || !max.SameCurrency(amount) || !used.Add(amount).GreaterThan(max) {
+		return nil
+	}
+
+	remaining := max.Sub(used)
+	if remaining.IsNegative() {
+		remaining = NewMoney(0, max.Currency)
+	}
+
+	return &LimitError{Limit: name, Max: max, Used: used, Remaining: remaining, Requested: amount, ResetsAt: &resets}
+}
+
+//Check that user can send amount out of wallet. Call it with the wallet locked in tx so two
+//payments cannot both fit in what is left
+func checkOutflowLimits(tx *gorm.DB, user uint, wallet *Wallet, amount Money) error {
+
+	tier, err := accountTier(tx, user)
+	if err != nil {
+		return err
+	}
+
+	limits := limitsForTier(tier)
+	max := limits.SingleTransaction
+	if max.IsPositive() && max.SameCurrency(amount) && amount.GreaterThan(max) {
+		return &LimitError{Limit: LimitSingleTransaction, Max: max, Used: Kobo(0), Remaining: max, Requested: amount}
+	}
+
+	now := time.Now()
+	monthly, err := outflowSince(tx, wallet, startOfMonth(now))
+	if err != nil {
+		return err
+	}
+
+	daily, err := outflowSince(tx, wallet, startOfDay(now))
+	if err != nil {
+		return err
+	}
+
+	err = windowLimit(LimitDailyOutflow, limits.DailyOutflow, daily, amount, startOfDay(now).AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	return windowLimit(LimitMonthlyOutflow, limits.MonthlyOutflow, monthly, amount, startOfMonth(now).AddDate(0, 1, 0))
+}
+
+//Check that user can take amount into wallet. pending, in amount's currency, is money on its way in
+//that is not in the wallet yet, like top ups still being paid for. Call it with the wallet locked in tx
+//where the money moves in the same transaction
+func checkInflowLimits(tx *gorm.DB, user uint, wallet *Wallet, amount, pending Money) error {
+
+	tier, err := accountTier(tx, user)
+	if err != nil {
+		return err
+	}
+
+	limits := limitsForTier(tier)
+	max := limits.MaxBalance
+	if max.IsPositive() && max.SameCurrency(amount) && wallet.Balance.SameCurrency(amount) &&
+		pending.SameCurrency(amount) && wallet.Balance.Add(pending).Add(amount).GreaterThan(max) {
+		used := wallet.Balance.Add(pending)
+		remaining := max.Sub(used)
+		if remaining.IsNegative() {
+			remaining = NewMoney(0, max.Currency)
+		}
+		return &LimitError{Limit: LimitMaxBalance, Max: max, Used: used, Remaining: remaining, Requested: amount}
+	}
+
+	now := time.Now()
+	monthly, err := inflowSince(tx, wallet, startOfMonth(now))
+	if err != nil {
+		return err
+	}
+
+	daily, err := inflowSince(tx, wallet, startOfDay(now))
+	if err != nil {
+		return err
+	}
+
+	if pending.SameCurrency(monthly) {
+		monthly, daily = monthly.Add(pending), daily.Add(pending)
+	}
+
+	err = windowLimit(LimitDailyInflow, limits.DailyInflow, daily, amount, startOfDay(now).AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	return windowLimit(LimitMonthlyInflow, limits.MonthlyInflow, monthly, amount, startOfMonth(now).AddDate(0, 1, 0))
+}
+
+func limitUsage(name string, max, used Money, resets *time.Time) *LimitUsage {
+
+	usage := &LimitUsage{Limit: name, Name: limitNames[name], Max: max, Used: used, ResetsAt: resets}
+	if !max.IsPositive() {
+		usage.Unlimited = true
+		return usage
+	}
+
+	usage.Remaining = max.Sub(used)
+	if usage.Remaining.IsNegative() {
+		usage.Remaining = NewMoney(0, max.Currency)
+	}
+
+	return usage
+}
+
+//The user's limits for their tier and how much of each is used up
+func GetLimits(user uint) (*AccountLimits, error) {
+
+	wallet := GetWallet(user)
+	if wallet == nil {
+		return nil, errors.New("Wallet not found for user")
+	}
+
+	tier, err := accountTier(Db, user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	day, month := startOfDay(now), startOfMonth(now)
+	nextDay, nextMonth := day.AddDate(0, 0, 1), month.AddDate(0, 1, 0)
+
+	dailyOut, err := outflowSince(Db, wallet, day)
+	if err != nil {
+		return nil, err
+	}
+
+	monthlyOut, err := outflowSince(Db, wallet, month)
+	if err != nil {
+		return nil, err
+	}
+
+	dailyIn, err := inflowSince(Db, wallet, day)
+	if err != nil {
+		return nil, err
+	}
+
+	monthlyIn, err := inflowSince(Db, wallet, month)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := limitsForTier(tier)
+	data := &AccountLimits{Tier: tier}
+	data.Limits = []*LimitUsage {
+		limitUsage(LimitSingleTransaction, limits.SingleTransaction, Kobo(0), nil),
+		limitUsage(LimitDailyOutflow, limits.DailyOutflow, dailyOut, &nextDay),
+		limitUsage(LimitMonthlyOutflow, limits.MonthlyOutflow, monthlyOut, &nextMonth),
+		limitUsage(LimitDailyInflow, limits.DailyInflow, dailyIn, &nextDay),
+		limitUsage(LimitMonthlyInflow, limits.MonthlyInflow, monthlyIn, &nextMonth),
+		limitUsage(LimitMaxBalance, limits.MaxBalance, wallet.Balance, nil),
+	}
+
+	return data, nil
+}
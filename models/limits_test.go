@@ -0,0 +1,148 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLimitsForTier(t *testing.T) {
+
+	cases := map[int] *TierLimits {
+		-1 : tierLimits[0],
+		0 : tierLimits[0],
+		1 : tierLimits[1],
+		MaxTier : tierLimits[len(tierLimits) - 1],
+		MaxTier + 5 : tierLimits[len(tierLimits) - 1],
+	}
+
+	for tier, limits := range cases {
+		if limitsForTier(tier) != limits {
+			t.Errorf("tier %d got the wrong limits", tier)
+		}
+	}
+
+	//Every tier lets an account do at least what the one below it could
+	for i := 1; i < len(tierLimits); i++ {
+		lower, higher := tierLimits[i - 1], tierLimits[i]
+		if higher.SingleTransaction.LessThan(lower.SingleTransaction) || higher.DailyOutflow.LessThan(lower.DailyOutflow) ||
+			higher.DailyInflow.LessThan(lower.DailyInflow) || higher.MonthlyOutflow.LessThan(lower.MonthlyOutflow) ||
+			higher.MonthlyInflow.LessThan(lower.MonthlyInflow) {
+			t.Errorf("tier %d is held to less than tier %d", i, i - 1)
+		}
+	}
+}
+
+func TestWindowLimit(t *testing.T) {
+
+	resets := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		max, used, amount Money
+		remaining int64
+		refused bool
+	}{
+		{"well under", naira(1000), naira(100), naira(100), 0, false},
+		{"up to the limit", naira(1000), naira(900), naira(100), 0, false},
+		{"a kobo over", naira(1000), naira(900), Kobo(10001), naira(100).Kobo, true},
+		{"nothing left", naira(1000), naira(1000), Kobo(1), 0, true},
+		{"already over", naira(1000), naira(1200), Kobo(1), 0, true},
+		{"no cap", Kobo(0), naira(1000000), naira(1000000), 0, false},
+		{"another currency", naira(1000), naira(1000), NewMoney(100, "USD"), 0, false},
+	}
+
+	for _, c := range cases {
+		err := windowLimit(LimitDailyOutflow, c.max, c.used, c.amount, resets)
+		if (err != nil) != c.refused {
+			t.Errorf("%s: windowLimit returned %v, want refused %v", c.name, err, c.refused)
+			continue
+		}
+
+		if err == nil {
+			continue
+		}
+
+		limit, ok := err.(*LimitError)
+		if !ok || limit.Limit != LimitDailyOutflow || limit.Remaining.Kobo != c.remaining ||
+			limit.Requested != c.amount || !limit.ResetsAt.Equal(resets) {
+			t.Errorf("%s: refused with %+v", c.name, err)
+		}
+	}
+}
+
+func TestLimitWindows(t *testing.T) {
+
+	lagos := time.FixedZone("WAT", 60 * 60)
+	now := time.Date(2026, 3, 31, 23, 30, 0, 0, lagos)
+
+	if day := startOfDay(now); !day.Equal(time.Date(2026, 3, 31, 0, 0, 0, 0, lagos)) {
+		t.Errorf("day starts at %s", day)
+	}
+
+	if month := startOfMonth(now); !month.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, lagos)) {
+		t.Errorf("month starts at %s", month)
+	}
+
+	if next := startOfMonth(now).AddDate(0, 1, 0); !next.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, lagos)) {
+		t.Errorf("month resets at %s", next)
+	}
+}
+
+func TestLimitUsage(t *testing.T) {
+
+	usage := limitUsage(LimitDailyInflow, naira(1000), naira(1200), nil)
+	if usage.Unlimited || !usage.Remaining.IsZero() || usage.Name != limitNames[LimitDailyInflow] {
+		t.Errorf("usage over the limit %+v", usage)
+	}
+
+	usage = limitUsage(LimitDailyInflow, naira(1000), naira(250), nil)
+	if usage.Remaining != naira(750) {
+		t.Errorf("usage under the limit %+v", usage)
+	}
+
+	if usage = limitUsage(LimitMaxBalance, Kobo(0), naira(250), nil); !usage.Unlimited {
+		t.Errorf("uncapped usage %+v", usage)
+	}
+}
+
+//Tier 0 accounts are held to their single transaction and daily caps, and a refusal moves nothing
+func TestOutflowLimits(t *testing.T) {
+
+	requireDb(t)
+	sender := newTestAccount(t, naira(25000).Kobo)
+	recipient := newTestAccount(t, 0)
+	err := Db.Table("accounts").Where("id = ?", sender.ID).UpdateColumn("tier", 0).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := limitsForTier(0)
+	send := func(amount string) error {
+		_, err := SendTransfer(sender.ID, &TransferPayload{Recipient: recipient.Email, Amount: json.Number(amount), Pin: testPin})
+		return err
+	}
+
+	err = send(limits.SingleTransaction.Add(Kobo(1)).Decimal())
+	if limit, ok := err.(*LimitError); !ok || limit.Limit != LimitSingleTransaction {
+		t.Errorf("sending past the single transaction cap returned %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = send("9000")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = send("3000")
+	limit, ok := err.(*LimitError)
+	if !ok || limit.Limit != LimitDailyOutflow || limit.Remaining != limits.DailyOutflow.Sub(naira(18000)) {
+		t.Errorf("sending past the daily cap returned %v", err)
+	}
+
+	if balance := balanceOf(t, recipient.ID); balance != naira(18000).Kobo {
+		t.Errorf("recipient holds %d, want %d", balance, naira(18000).Kobo)
+	}
+
+	requireReconciled(t, sender.ID, recipient.ID)
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"fmt"
+	"strings"
+	"strconv"
+	"math"
+)
+
+const DefaultCurrency = "NGN"
+
+var currencySymbols = map[string] string {
+	"NGN" : "₦",
+	"USD" : "$",
+	"GHS" : "GH₵",
+}
+
+//An amount of money in minor units (kobo for NGN) and its ISO 4217 currency code.
+//Stored as two columns through gorm's embedded struct support, e.g
+//	Balance Money `gorm:"embedded;embedded_prefix:balance_"`
+//gives balance_kobo and balance_currency
+type Money struct {
+	Kobo int64 `json:"kobo"`
+	Currency string `json:"currency"`
+}
+
+func NewMoney(kobo int64, currency string) Money {
+
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	return Money{Kobo: kobo, Currency: strings.ToUpper(currency)}
+}
+
+//Naira amount in kobo
+func Kobo(kobo int64) Money {
+	return NewMoney(kobo, DefaultCurrency)
+}
+
+//Parse a major unit amount such as 1500 or 1500.50 exactly, without going through a float
+func ParseMoney(n json.Number, currency string) (Money, error) {
+
+	value := strings.TrimSpace(n.String())
+	if value == "" {
+		return Money{}, errors.New("Amount is required")
+	}
+
+	if strings.ContainsAny(value, "eE") {
+		return Money{}, errors.New(fmt.Sprintf("Invalid amount '%s'", value))
+	}
+
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	whole, fraction := value, ""
+	if i := strings.Index(value, "."); i >= 0 {
+		whole, fraction = value[:i], value[i + 1:]
+	}
+
+	if len(fraction) > 2 {
+		return Money{}, errors.New(fmt.Sprintf("Invalid amount '%s'. At most 2 decimal places are allowed", n))
+	}
+
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major < 0 {
+		return Money{}, errors.New(fmt.Sprintf("Invalid amount '%s'", n))
+	}
+
+	minor, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || minor < 0 {
+		return Money{}, errors.New(fmt.Sprintf("Invalid amount '%s'", n))
+	}
+
+	if major > (math.MaxInt64 - minor) / 100 {
+		return Money{}, errors.New(fmt.Sprintf("Amount '%s' is too large", n))
+	}
+
+	kobo := major * 100 + minor
+	if negative {
+		kobo = -kobo
+	}
+
+	return NewMoney(kobo, currency), nil
+}
+
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == o.Currency
+}
+
+//Arithmetic and comparison assume both operands share a currency. Amounts coming
+//from outside are checked with SameCurrency, so a mismatch here is a programming error
+func (m Money) mustMatch(o Money) {
+	if !m.SameCurrency(o) {
+		panic(fmt.Sprintf("currency mismatch: %s and %s", m.Currency, o.Currency))
+	}
+}
+
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return NewMoney(m.Kobo + o.Kobo, m.Currency)
+}
+
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return NewMoney(m.Kobo - o.Kobo, m.Currency)
+}
+
+func (m Money) Neg() Money {
+	return NewMoney(-m.Kobo, m.Currency)
+}
+
+func (m Money) Cmp(o Money) int {
+	m.mustMatch(o)
+	switch {
+	case m.Kobo < o.Kobo:
+		return -1
+	case m.Kobo > o.Kobo:
+		return 1
+	}
+
+	return 0
+}
+
+func (m Money) LessThan(o Money) bool {
+	return m.Cmp(o) < 0
+}
+
+func (m Money) GreaterThan(o Money) bool {
+	return m.Cmp(o) > 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Kobo == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Kobo > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Kobo < 0
+}
+
+//Major unit amount with two decimal places, e.g 1500.50
+func (m Money) Decimal() string {
+
+	kobo := m.Kobo
+	sign := ""
+	if kobo < 0 {
+		sign = "-"
+		kobo = -kobo
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, kobo / 100, kobo % 100)
+}
+
+//Human readable amount, e.g ₦1,500.50
+func (m Money) String() string {
+
+	value := m.Decimal()
+	sign := ""
+	if strings.HasPrefix(value, "-") {
+		sign = "-"
+		value = value[1:]
+	}
+
+	point := strings.Index(value, ".")
+	whole := value[:point]
+	grouped := ""
+	for i, d := range whole {
+		if i > 0 && (len(whole) - i) % 3 == 0 {
+			grouped += ","
+		}
+		grouped += string(d)
+	}
+
+	symbol, ok := currencySymbols[m.Currency]
+	if !ok {
+		symbol = m.Currency + " "
+	}
+
+	return sign + symbol + grouped + value[point:]
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string] interface{} {
+		"kobo" : m.Kobo,
+		"currency" : m.Currency,
+		"formatted" : m.String(),
+	})
+}
+
+//Move amounts from the old float columns, which held major units, into the new minor unit columns
+func migrateMoneyColumn(table, column string) error {
+
+	if !Db.Dialect().HasColumn(table, column) {
+		return nil
+	}
+
+	err := Db.Exec(fmt.Sprintf("UPDATE %s SET %s_kobo = ROUND(%s * 100), %s_currency = ?", table, column, column, column),
+		DefaultCurrency).Error
+	if err != nil {
+		return err
+	}
+
+	return Db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column)).Error
+}
+
+func MigrateMoneyColumns() error {
+
+	columns := [][]string {
+		{"wallets", "balance"},
+		{"tx_tokens", "amount"},
+		{"tx_refs", "amount"},
+		{"journal_lines", "amount"},
+	}
+
+	for _, c := range columns {
+		err := migrateMoneyColumn(c[0], c[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"strings"
+	"time"
+	"fmt"
+)
+
+//Limits on how many requests one user can send, so nobody can flood another user's inbox
+const (
+	MaxMoneyRequestsPerHour = 10
+	MaxOpenRequestsPerPayer = 3
+)
+
+var ErrTooManyOpenRequests = errors.New("You already have too many open requests with this user")
+
+//Ask a user for money. From is an email, phone, handle or user id
+type MoneyRequestPayload struct {
+	From string `json:"from"`
+	Amount json.Number `json:"amount"`
+	Note string `json:"note"`
+}
+
+//Raise a request for payment against another user. The request is a token already claimed for the
+//requester, so the payer pays or declines it like any other claimed token (AuthorizePayment,
+//DeclineToken) and it expires after MoneyRequestTTL
+func CreateMoneyRequest(user uint, payload *MoneyRequestPayload) (*TxToken, error) {
+
+	amount, err := ParseMoney(payload.Amount, DefaultCurrency)
+	if err != nil || !amount.IsPositive() {
+		return nil, errors.New("Invalid amount")
+	}
+
+	note := strings.TrimSpace(payload.Note)
+	if len(note) > maxMemoLength {
+		return nil, errors.New(fmt.Sprintf("Note should not be longer than %d characters", maxMemoLength))
+	}
+
+	payer := FindAccount(payload.From)
+	if payer == nil {
+		return nil, errors.New(fmt.Sprintf("No account found for %s", payload.From))
+	}
+
+	if payer.ID == user {
+		return nil, errors.New("You cannot request money from yourself")
+	}
+
+	requester := GetAccount(user)
+	if requester == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	err = checkMoneyRequestLimits(user, payer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := Db.Begin()
+	err = tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := raiseMoneyRequest(tx, user, payer.ID, amount, note)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsRequestReceived
+	wsMessage.Account = requester
+	wsMessage.Amount = amount
+	wsMessage.Token = token.Token
+	wsMessage.Memo = note
+	SendWsMessageTo(payer.ID, wsMessage)
+
+	return GetTxToken(token.Token), nil
+}
+
+//Create a request token in tx, claimed for the requester and waiting on the payer
+func raiseMoneyRequest(tx *gorm.DB, requester, payer uint, amount Money, note string) (*TxToken, error) {
+
+	now := time.Now()
+	token := &TxToken{}
+	token.Kind = TokenKindRequest
+	token.Token = findToken()
+	token.UserId = payer
+	token.Amount = Kobo(0)
+	token.Status = TokenCreated
+	token.StatusChangedAt = &now
+	token.ExactAmount = amount
+	token.AllowedPayee = requester
+	token.Memo = note
+
+	err := tx.Create(token).Error
+	if err != nil {
+		return nil, errors.New("Cannot send request at this time. Please retry")
+	}
+
+	//The payer sees the fee in their inbox before paying
+	fee := feeFor(FeeTransfer, payer, amount)
+	err = transitionToken(tx, token, TokenClaimed, requester, "", map[string] interface{} {
+		"amount_kobo" : amount.Kobo, "amount_currency" : amount.Currency, "recv_by" : requester,
+		"fee_kobo" : fee.Kobo, "fee_currency" : fee.Currency})
+	if err != nil {
+		return nil, err
+	}
+
+	token.Amount = amount
+	token.Fee = fee
+	token.RecvBy = requester
+	return token, nil
+}
+
+func checkMoneyRequestLimits(user, payer uint) error {
+
+	sent := 0
+	err := Db.Table("tx_tokens").Where("kind = ? AND recv_by = ? AND created_at >= ?",
+		TokenKindRequest, user, time.Now().Add(-time.Hour)).Count(&sent).Error
+	if err != nil {
+		return err
+	}
+
+	if sent >= MaxMoneyRequestsPerHour {
+		return errors.New("You have sent too many requests. Please try again later")
+	}
+
+	return checkOpenMoneyRequests(user, payer)
+}
+
+//Requests user is still waiting on from payer, whether sent alone or as a split share
+func checkOpenMoneyRequests(user, payer uint) error {
+
+	open := 0
+	err := Db.Table("tx_tokens").Where("kind = ? AND recv_by = ? AND user_id = ? AND state = ?",
+		TokenKindRequest, user, payer, TokenClaimed).Count(&open).Error
+	if err != nil {
+		return err
+	}
+
+	if open >= MaxOpenRequestsPerPayer {
+		return ErrTooManyOpenRequests
+	}
+
+	return nil
+}
+
+//Requests waiting for user to pay or decline, newest first
+func GetMoneyRequestInbox(user uint) []*TxToken {
+
+	data := getMoneyRequests("user_id = ? AND kind = ? AND state = ?", user, TokenKindRequest, TokenClaimed)
+	resp := make([]*TxToken, 0)
+	for _, n := range data {
+		if n.Status == TokenClaimed {
+			resp = append(resp, n)
+		}
+	}
+
+	return resp
+}
+
+//Every request user has sent, newest first
+func GetSentMoneyRequests(user uint) []*TxToken {
+	return getMoneyRequests("recv_by = ? AND kind = ?", user, TokenKindRequest)
+}
+
+func getMoneyRequests(query string, args ...interface{}) []*TxToken {
+
+	data := make([]*TxToken, 0)
+	err := Db.Table("tx_tokens").Where(query, args...).Order("created_at desc").Limit(100).Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	resp := make([]*TxToken, 0)
+	for _, n := range data {
+		//Not swept yet, but past its time
+		if n.Status == TokenClaimed && n.stale(now) {
+			n.Status = TokenExpired
+		}
+
+		n.Recv = GetAccount(n.RecvBy)
+		n.User = GetAccount(n.UserId)
+		n.ExpiresAt = n.expiry()
+		resp = append(resp, n)
+	}
+
+	return resp
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestMoneyRequestIsPaidByThePayer(t *testing.T) {
+
+	requireDb(t)
+	requester := newTestAccount(t, 0)
+	payer := newTestAccount(t, naira(1000).Kobo)
+
+	request, err := CreateMoneyRequest(requester.ID, &MoneyRequestPayload{From: payer.Email, Amount: "250", Note: "Lunch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request.Status != TokenClaimed || request.UserId != payer.ID || request.RecvBy != requester.ID {
+		t.Fatalf("new request %+v", request)
+	}
+
+	inbox := GetMoneyRequestInbox(payer.ID)
+	if len(inbox) != 1 || inbox[0].Token != request.Token {
+		t.Errorf("payer's inbox holds %d requests, want the new one", len(inbox))
+	}
+
+	//Only the payer can pay it
+	err = AuthorizePayment(requester.ID, &AuthorizePaymentPayload{Token: request.Token, Pin: testPin})
+	if err == nil {
+		t.Error("the requester paid their own request")
+	}
+
+	err = AuthorizePayment(payer.ID, &AuthorizePaymentPayload{Token: request.Token, Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance := balanceOf(t, requester.ID); balance != naira(250).Kobo {
+		t.Errorf("requester holds %d, want %d", balance, naira(250).Kobo)
+	}
+
+	if inbox := GetMoneyRequestInbox(payer.ID); len(inbox) != 0 {
+		t.Errorf("payer's inbox still holds %d requests", len(inbox))
+	}
+
+	sent := GetSentMoneyRequests(requester.ID)
+	if len(sent) != 1 || sent[0].Status != TokenAuthorized {
+		t.Errorf("requester's sent requests %+v", sent)
+	}
+
+	requireReconciled(t, requester.ID, payer.ID)
+}
+
+func TestMoneyRequestLimits(t *testing.T) {
+
+	requireDb(t)
+	requester := newTestAccount(t, 0)
+	payer := newTestAccount(t, 0)
+
+	_, err := CreateMoneyRequest(requester.ID, &MoneyRequestPayload{From: requester.Email, Amount: "10"})
+	if err == nil {
+		t.Error("a user requested money from themselves")
+	}
+
+	_, err = CreateMoneyRequest(requester.ID, &MoneyRequestPayload{From: payer.Email, Amount: "0"})
+	if err == nil {
+		t.Error("a request for nothing was sent")
+	}
+
+	for i := 0; i < MaxOpenRequestsPerPayer; i++ {
+		_, err = CreateMoneyRequest(requester.ID, &MoneyRequestPayload{From: payer.Email, Amount: "10"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = CreateMoneyRequest(requester.ID, &MoneyRequestPayload{From: payer.Email, Amount: "10"})
+	if err != ErrTooManyOpenRequests {
+		t.Errorf("request past the open limit returned %v, want ErrTooManyOpenRequests", err)
+	}
+
+	//Declining one makes room for another
+	inbox := GetMoneyRequestInbox(payer.ID)
+	if len(inbox) == 0 {
+		t.Fatal("payer's inbox is empty")
+	}
+
+	_, err = DeclineToken(payer.ID, &CloseTokenPayload{Token: inbox[0].Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CreateMoneyRequest(requester.ID, &MoneyRequestPayload{From: payer.Email, Amount: "10"})
+	if err != nil {
+		t.Errorf("request after a decline returned %v", err)
+	}
+}
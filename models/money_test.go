@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+
+	cases := []struct {
+		amount string
+		currency string
+		kobo int64
+		valid bool
+	}{
+		{"10", "", 1000, true},
+		{"10.5", "", 1050, true},
+		{"10.50", "", 1050, true},
+		{"10.05", "", 1005, true},
+		{".5", "", 50, true},
+		{"10.", "", 1000, true},
+		{" 1500.25 ", "", 150025, true},
+		{"0", "", 0, true},
+		{"-10.5", "", -1050, true},
+		{"-0.01", "", -1, true},
+		{"92233720368547758.07", "", math.MaxInt64, true},
+		{"10.055", "", 0, false},
+		{"10.5.5", "", 0, false},
+		{"1e3", "", 0, false},
+		{"--10", "", 0, false},
+		{"10.-5", "", 0, false},
+		{"ten", "", 0, false},
+		{"", "", 0, false},
+		{"92233720368547758.08", "", 0, false},
+		{"100000000000000000000", "", 0, false},
+	}
+
+	for _, c := range cases {
+		m, err := ParseMoney(json.Number(c.amount), c.currency)
+		if (err == nil) != c.valid {
+			t.Errorf("ParseMoney(%q) returned %v, want valid %v", c.amount, err, c.valid)
+			continue
+		}
+
+		if c.valid && (m.Kobo != c.kobo || m.Currency != DefaultCurrency) {
+			t.Errorf("ParseMoney(%q) = %d %s, want %d %s", c.amount, m.Kobo, m.Currency, c.kobo, DefaultCurrency)
+		}
+	}
+}
+
+func TestParseMoneyCurrency(t *testing.T) {
+
+	cases := map[string] string {
+		"" : DefaultCurrency,
+		"ngn" : "NGN",
+		"USD" : "USD",
+		"ghs" : "GHS",
+	}
+
+	for currency, want := range cases {
+		m, err := ParseMoney("1.5", currency)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Currency != want || m.Kobo != 150 {
+			t.Errorf("ParseMoney(1.5, %q) = %d %s, want 150 %s", currency, m.Kobo, m.Currency, want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+
+	cases := []struct {
+		money Money
+		formatted string
+		decimal string
+	}{
+		{Kobo(0), "₦0.00", "0.00"},
+		{Kobo(5), "₦0.05", "0.05"},
+		{Kobo(1050), "₦10.50", "10.50"},
+		{Kobo(100000), "₦1,000.00", "1000.00"},
+		{Kobo(150050), "₦1,500.50", "1500.50"},
+		{Kobo(12345678901), "₦123,456,789.01", "123456789.01"},
+		{Kobo(-1050), "-₦10.50", "-10.50"},
+		{Kobo(-100000000), "-₦1,000,000.00", "-1000000.00"},
+		{NewMoney(2500, "usd"), "$25.00", "25.00"},
+		{NewMoney(250000, "GHS"), "GH₵2,500.00", "2500.00"},
+		{NewMoney(250000, "EUR"), "EUR 2,500.00", "2500.00"},
+	}
+
+	for _, c := range cases {
+		if s := c.money.String(); s != c.formatted {
+			t.Errorf("%d %s formatted as %q, want %q", c.money.Kobo, c.money.Currency, s, c.formatted)
+		}
+
+		if d := c.money.Decimal(); d != c.decimal {
+			t.Errorf("%d %s as a decimal is %q, want %q", c.money.Kobo, c.money.Currency, d, c.decimal)
+		}
+	}
+}
+
+//Adding naira to dollars is a bug, not an amount
+func TestMoneyCurrencyMismatchPanics(t *testing.T) {
+
+	defer func() {
+		if recover() == nil {
+			t.Error("adding NGN to USD did not panic")
+		}
+	}()
+
+	Kobo(100).Add(NewMoney(100, "USD"))
+}
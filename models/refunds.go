@@ -0,0 +1,261 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"strings"
+	"fmt"
+)
+
+//Money given back on an authorized token, by its payee or forced by an admin. Refunds on a token
+//never add up to more than its amount, and the token is reversed once they reach it
+type Refund struct {
+	gorm.Model
+	TokenId uint `json:"token_id" gorm:"index"`
+	Token string `json:"token"`
+	PayerId uint `json:"payer_id" gorm:"index"`
+	PayeeId uint `json:"payee_id" gorm:"index"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Reason string `json:"reason"`
+	Actor uint `json:"actor"`
+	Forced bool `json:"forced"`
+}
+
+//Empty amount refunds whatever is left of the token
+type RefundPayload struct {
+	Token string `json:"token"`
+	Amount json.Number `json:"amount"`
+	Reason string `json:"reason"`
+	Pin string `json:"pin"`
+}
+
+//The payee gives back some or all of a payment they received
+func RefundPayment(user uint, payload *RefundPayload) (*Refund, error) {
+
+	token := GetTxToken(payload.Token)
+	if token == nil {
+		return nil, errors.New(fmt.Sprintf("Token %s not found", payload.Token))
+	}
+
+	if token.RecvBy != user {
+		return nil, errors.New("unAuthorized")
+	}
+
+	err := VerifyPin(user, payload.Pin)
+	if err != nil {
+		return nil, err
+	}
+
+	return reverseToken(user, payload, false)
+}
+
+//An admin reverses a payment without the payee
+func ForceReversal(admin uint, payload *RefundPayload) (*Refund, error) {
+
+	if strings.TrimSpace(payload.Reason) == "" {
+		return nil, errors.New("A reason is required to force a reversal")
+	}
+
+	return reverseToken(admin, payload, true)
+}
+
+func reverseToken(actor uint, payload *RefundPayload, forced bool) (*Refund, error) {
+
+	reason := strings.TrimSpace(payload.Reason)
+	if len(reason) > maxCloseReasonLength {
+		return nil, errors.New(fmt.Sprintf("Reason should not be longer than %d characters", maxCloseReasonLength))
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := lockToken(tx, payload.Token)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if locked.Status != TokenAuthorized {
+		tx.Rollback()
+		if locked.Status == TokenReversed {
+			return nil, errors.New(fmt.Sprintf("Token %s has already been fully refunded", locked.Token))
+		}
+		return nil, errors.New(fmt.Sprintf("Token %s has not been paid", locked.Token))
+	}
+
+	refunded := NewMoney(locked.Refunded.Kobo, locked.Amount.Currency)
+	left := locked.Amount.Sub(refunded)
+	amount := left
+	if payload.Amount != "" {
+		amount, err = ParseMoney(payload.Amount, locked.Amount.Currency)
+		if err != nil || !amount.IsPositive() {
+			tx.Rollback()
+			return nil, errors.New("Invalid amount")
+		}
+	}
+
+	if amount.GreaterThan(left) {
+		tx.Rollback()
+		return nil, errors.New(fmt.Sprintf("At most %s of this payment can still be refunded", left))
+	}
+
+	wallets, err := lockWallets(tx, locked.UserId, locked.RecvBy)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//A payee refunds out of what they have. A forced reversal is taken back regardless, even
+	//if the payee has spent or is holding the money. What they hold is still paid out in full
+	//when the holds are captured, leaving the wallet overdrawn
+	payerWallet, payeeWallet := wallets[locked.UserId], wallets[locked.RecvBy]
+	if forced {
+		err = overdrawWallet(tx, payeeWallet, amount)
+	} else {
+		err = debitWallet(tx, payeeWallet, amount)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = creditWallet(tx, payerWallet, amount)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	refunded = refunded.Add(amount)
+	updates := map[string] interface{} {"refunded_kobo" : refunded.Kobo, "refunded_currency" : refunded.Currency}
+	if refunded.Cmp(locked.Amount) == 0 {
+		err = transitionToken(tx, locked, TokenReversed, actor, reason, updates)
+	} else {
+		err = tx.Table("tx_tokens").Where("id = ?", locked.ID).UpdateColumns(updates).Error
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	refund := &Refund{}
+	refund.TokenId = locked.ID
+	refund.Token = locked.Token
+	refund.PayerId = locked.UserId
+	refund.PayeeId = locked.RecvBy
+	refund.Amount = amount
+	refund.Reason = reason
+	refund.Actor = actor
+	refund.Forced = forced
+
+	err = tx.Create(refund).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = postRefund(tx, payeeWallet, payerWallet, locked.Token, amount)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	notifyRefund(locked, refund)
+	return refund, nil
+}
+
+//The compensating entry of a payment. Money goes back from the payee's wallet account to the payer's
+func postRefund(tx *gorm.DB, from, to *Wallet, ref string, amount Money) error {
+
+	payee, err := GetWalletLedgerAccount(tx, from)
+	if err != nil {
+		return err
+	}
+
+	payer, err := GetWalletLedgerAccount(tx, to)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryRefund, ref, "Refund")
+	entry.Debit(payee, amount).Credit(payer, amount)
+	return PostJournalEntry(tx, entry)
+}
+
+func notifyRefund(token *TxToken, refund *Refund) {
+
+	payer := GetAccount(token.UserId)
+	payee := GetAccount(token.RecvBy)
+	if payer == nil || payee == nil {
+		return
+	}
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsTokenRefunded
+	wsMessage.Account = payee
+	wsMessage.Amount = refund.Amount
+	wsMessage.Token = token.Token
+	wsMessage.Reason = refund.Reason
+	SendWsMessageTo(payer.ID, wsMessage)
+
+	wsMessage = &WsMessage{}
+	wsMessage.Event = WsTokenRefunded
+	wsMessage.Account = payer
+	wsMessage.Amount = refund.Amount
+	wsMessage.Token = token.Token
+	wsMessage.Reason = refund.Reason
+	SendWsMessageTo(payee.ID, wsMessage)
+
+	note := ""
+	if refund.Reason != "" {
+		note = fmt.Sprintf(" Reason: %s", refund.Reason)
+	}
+
+	mail := &MailRequest{}
+	mail.Subject = "LitePay - Payment Refunded"
+	mail.Body = fmt.Sprintf("%s of your payment to %s on token %s has been refunded to your wallet.%s",
+		refund.Amount, payee.Fullname, token.Token, note)
+	mail.To = payer.Email
+	mail.Name = payer.Fullname
+	MailQueue <- mail
+
+	mail = &MailRequest{}
+	mail.Subject = "LitePay - Payment Refunded"
+	mail.Body = fmt.Sprintf("%s of the payment from %s on token %s has been refunded from your wallet.%s",
+		refund.Amount, payer.Fullname, token.Token, note)
+	if refund.Forced {
+		mail.Body += " This reversal was made by LitePay support. If your wallet did not hold enough to cover it, " +
+			"it is now overdrawn and the difference comes out of the next money you receive."
+	}
+	mail.To = payee.Email
+	mail.Name = payee.Fullname
+	MailQueue <- mail
+}
+
+func GetRefundsFor(token uint) []*Refund {
+
+	data := make([]*Refund, 0)
+	err := Db.Table("refunds").Where("token_id = ?", token).Order("id asc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+//Only admins may force reversals, and later other support actions. Set by hand in the database
+func IsAdmin(user uint) bool {
+
+	count := 0
+	Db.Table("accounts").Where("id = ? AND is_admin = ?", user, true).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+)
+
+//A forced reversal can take a wallet below what it has promised to claims. Those claims must still
+//be paid when they are authorized
+func TestClaimIsPaidAfterForcedReversal(t *testing.T) {
+
+	requireDb(t)
+	customer := newTestAccount(t, 100000)
+	merchant := newTestAccount(t, 0)
+	supplier := newTestAccount(t, 0)
+
+	sale := payWithToken(t, customer, merchant, 50000)
+	claim := claimToken(t, merchant, supplier, 30000)
+	held := walletOf(t, merchant.ID).Held
+
+	_, err := ForceReversal(customer.ID, &RefundPayload{Token: sale.Token, Reason: "Chargeback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wallet := walletOf(t, merchant.ID)
+	if wallet.Balance.Kobo != 0 || wallet.Held.Kobo != held.Kobo {
+		t.Fatalf("merchant holds %s with %s held after the reversal, want 0 with %s held", wallet.Balance, wallet.Held, held)
+	}
+
+	err = AuthorizePayment(merchant.ID, &AuthorizePaymentPayload{Token: claim.Token, Pin: testPin})
+	if err != nil {
+		t.Fatalf("authorizing a held claim on an overdrawn wallet failed. %s", err.Error())
+	}
+
+	if balance := balanceOf(t, supplier.ID); balance != 30000 {
+		t.Errorf("supplier received %d, want 30000", balance)
+	}
+
+	wallet = walletOf(t, merchant.ID)
+	if wallet.Balance.Kobo != -held.Kobo || wallet.Held.Kobo != 0 {
+		t.Errorf("merchant holds %s with %s held, want -%s with nothing held", wallet.Balance, wallet.Held, held)
+	}
+
+	//Overdrawn, so nothing new can be held
+	token, err := CreateToken(merchant.ID, &CreateTokenPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if RedeemToken(supplier.ID, token.Token, Kobo(100)) == nil {
+		t.Error("a claim on an overdrawn wallet went through")
+	}
+
+	requireReconciled(t, customer.ID, merchant.ID, supplier.ID)
+}
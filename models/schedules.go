@@ -0,0 +1,411 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"strings"
+	"time"
+	"fmt"
+)
+
+//How often a scheduled transfer repeats
+const (
+	ScheduleOnce = "once"
+	ScheduleDaily = "daily"
+	ScheduleWeekly = "weekly"
+	ScheduleMonthly = "monthly"
+)
+
+const (
+	ScheduleActive = "active"
+	SchedulePaused = "paused"
+	ScheduleCompleted = "completed" //a one-off transfer that went through
+	ScheduleFailed = "failed" //a one-off transfer that could not go through
+)
+
+const (
+	//Attempts at one occurrence before it is given up on. Only a lack of funds is retried
+	MaxScheduleAttempts = 5
+
+	//Wait before the first retry. Doubles after every attempt
+	ScheduleRetryBackoff = 15 * time.Minute
+
+	//A run that has not finished after this long is assumed dead and the schedule is picked up again
+	scheduleLease = 10 * time.Minute
+)
+
+var ErrScheduleNotFound = errors.New("Scheduled transfer not found")
+
+//A transfer to another user that runs on its own, once at StartAt or repeatedly from it.
+//DueAt is the occurrence being paid, NextRunAt when the next attempt at it happens. They only
+//differ while an occurrence is being retried
+type ScheduledTransfer struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"index"`
+	RecipientId uint `json:"recipient_id"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Memo string `json:"memo"`
+	Frequency string `json:"frequency"`
+	StartAt time.Time `json:"start_at"`
+	EndAt *time.Time `json:"end_at"`
+	DueAt time.Time `json:"due_at"`
+	NextRunAt time.Time `json:"next_run_at" gorm:"index"`
+	Status string `json:"status" gorm:"index"`
+	Attempts int `json:"attempts"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	LastError string `json:"last_error"`
+	ClaimedAt *time.Time `json:"-"`
+
+	Recipient *Account `sql:"-" gorm:"-" json:"recipient"`
+}
+
+type ScheduledTransferPayload struct {
+	Recipient string `json:"recipient"` //email, phone, handle or user id
+	Amount json.Number `json:"amount"`
+	Memo string `json:"memo"`
+	Frequency string `json:"frequency"`
+	StartAt time.Time `json:"start_at"`
+	EndAt *time.Time `json:"end_at"`
+	Pin string `json:"pin"`
+}
+
+//Change a schedule. Empty fields are left as they are
+type ScheduledTransferUpdatePayload struct {
+	Amount json.Number `json:"amount"`
+	Memo *string `json:"memo"`
+	Status string `json:"status"` //active or paused
+	Pin string `json:"pin"`
+}
+
+func validFrequency(frequency string) bool {
+
+	switch frequency {
+	case ScheduleOnce, ScheduleDaily, ScheduleWeekly, ScheduleMonthly:
+		return true
+	}
+
+	return false
+}
+
+func CreateScheduledTransfer(user uint, payload *ScheduledTransferPayload) (*ScheduledTransfer, error) {
+
+	amount, err := ParseMoney(payload.Amount, DefaultCurrency)
+	if err != nil || !amount.IsPositive() {
+		return nil, errors.New("Invalid amount")
+	}
+
+	memo := strings.TrimSpace(payload.Memo)
+	if len(memo) > maxMemoLength {
+		return nil, errors.New(fmt.Sprintf("Memo should not be longer than %d characters", maxMemoLength))
+	}
+
+	if !validFrequency(payload.Frequency) {
+		return nil, errors.New("Frequency should be once, daily, weekly or monthly")
+	}
+
+	now := time.Now()
+	if payload.StartAt.IsZero() || payload.StartAt.Before(now.Add(-time.Minute)) {
+		return nil, errors.New("Start time should be in the future")
+	}
+
+	if payload.EndAt != nil && payload.EndAt.Before(payload.StartAt) {
+		return nil, errors.New("End time should be after the start time")
+	}
+
+	recipient := FindAccount(payload.Recipient)
+	if recipient == nil {
+		return nil, errors.New(fmt.Sprintf("No account found for %s", payload.Recipient))
+	}
+
+	if recipient.ID == user {
+		return nil, errors.New("You cannot pay yourself")
+	}
+
+	//The pin authorizes every run of the schedule
+	err = VerifyPin(user, payload.Pin)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &ScheduledTransfer{}
+	schedule.UserId = user
+	schedule.RecipientId = recipient.ID
+	schedule.Amount = amount
+	schedule.Memo = memo
+	schedule.Frequency = payload.Frequency
+	schedule.StartAt = payload.StartAt
+	schedule.EndAt = payload.EndAt
+	schedule.DueAt = payload.StartAt
+	schedule.NextRunAt = payload.StartAt
+	schedule.Status = ScheduleActive
+
+	err = Db.Create(schedule).Error
+	if err != nil {
+		return nil, errors.New("Cannot schedule transfer at this time. Please retry")
+	}
+
+	schedule.Recipient = recipient
+	return schedule, nil
+}
+
+func GetScheduledTransfer(user, id uint) *ScheduledTransfer {
+
+	schedule := &ScheduledTransfer{}
+	err := Db.Table("scheduled_transfers").Where("id = ? AND user_id = ? AND deleted_at IS NULL", id, user).First(schedule).Error
+	if err != nil {
+		return nil
+	}
+
+	schedule.Recipient = GetAccount(schedule.RecipientId)
+	return schedule
+}
+
+func GetScheduledTransfersFor(user uint) []*ScheduledTransfer {
+
+	data := make([]*ScheduledTransfer, 0)
+	err := Db.Table("scheduled_transfers").Where("user_id = ? AND deleted_at IS NULL", user).Order("id desc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	for _, schedule := range data {
+		schedule.Recipient = GetAccount(schedule.RecipientId)
+	}
+
+	return data
+}
+
+func UpdateScheduledTransfer(user, id uint, payload *ScheduledTransferUpdatePayload) (*ScheduledTransfer, error) {
+
+	schedule := GetScheduledTransfer(user, id)
+	if schedule == nil {
+		return nil, ErrScheduleNotFound
+	}
+
+	updates := make(map[string] interface{})
+	if payload.Amount != "" {
+		amount, err := ParseMoney(payload.Amount, DefaultCurrency)
+		if err != nil || !amount.IsPositive() {
+			return nil, errors.New("Invalid amount")
+		}
+
+		//Paying a different amount needs the same authority as the original schedule
+		err = VerifyPin(user, payload.Pin)
+		if err != nil {
+			return nil, err
+		}
+		updates["amount_kobo"] = amount.Kobo
+		updates["amount_currency"] = amount.Currency
+	}
+
+	if payload.Memo != nil {
+		memo := strings.TrimSpace(*payload.Memo)
+		if len(memo) > maxMemoLength {
+			return nil, errors.New(fmt.Sprintf("Memo should not be longer than %d characters", maxMemoLength))
+		}
+		updates["memo"] = memo
+	}
+
+	switch payload.Status {
+	case "":
+	case ScheduleActive, SchedulePaused:
+		if schedule.Status != ScheduleActive && schedule.Status != SchedulePaused {
+			return nil, errors.New(fmt.Sprintf("This transfer is %s and cannot be changed", schedule.Status))
+		}
+		updates["status"] = payload.Status
+
+		//Occurrences missed while paused are skipped, not paid all at once
+		if payload.Status == ScheduleActive && schedule.Status == SchedulePaused {
+			due := schedule.DueAt
+			for due.Before(time.Now()) && schedule.Frequency != ScheduleOnce {
+				due = schedule.advance(due)
+			}
+			updates["due_at"] = due
+			updates["next_run_at"] = due
+			updates["attempts"] = 0
+		}
+	default:
+		return nil, errors.New("Status should be active or paused")
+	}
+
+	if len(updates) == 0 {
+		return schedule, nil
+	}
+
+	err := Db.Table("scheduled_transfers").Where("id = ?", schedule.ID).UpdateColumns(updates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return GetScheduledTransfer(user, id), nil
+}
+
+func DeleteScheduledTransfer(user, id uint) error {
+
+	schedule := GetScheduledTransfer(user, id)
+	if schedule == nil {
+		return ErrScheduleNotFound
+	}
+
+	return Db.Delete(schedule).Error
+}
+
+//The occurrence after due. Monthly transfers stay on the day of the month they started on,
+//or the last day of shorter months
+func (schedule *ScheduledTransfer) advance(due time.Time) time.Time {
+
+	switch schedule.Frequency {
+	case ScheduleDaily:
+		return due.AddDate(0, 0, 1)
+	case ScheduleWeekly:
+		return due.AddDate(0, 0, 7)
+	case ScheduleMonthly:
+		year, month, _ := due.Date()
+		first := time.Date(year, month + 1, 1, due.Hour(), due.Minute(), due.Second(), 0, due.Location())
+		day := schedule.StartAt.In(due.Location()).Day()
+		last := first.AddDate(0, 1, -1).Day()
+		if day > last {
+			day = last
+		}
+		return first.AddDate(0, 0, day - 1)
+	}
+
+	return due
+}
+
+//Column updates that move the schedule on to its next occurrence, or finish it
+func (schedule *ScheduledTransfer) next(outcome string) map[string] interface{} {
+
+	updates := map[string] interface{} {"attempts" : 0}
+	if schedule.Frequency == ScheduleOnce {
+		updates["status"] = outcome
+		return updates
+	}
+
+	due := schedule.advance(schedule.DueAt)
+	for due.Before(time.Now()) {
+		due = schedule.advance(due)
+	}
+
+	if schedule.EndAt != nil && due.After(*schedule.EndAt) {
+		updates["status"] = ScheduleCompleted
+		return updates
+	}
+
+	updates["due_at"] = due
+	updates["next_run_at"] = due
+	return updates
+}
+
+//Make one attempt at the schedule's current occurrence
+func runScheduledTransfer(schedule *ScheduledTransfer) {
+
+	sender := GetAccount(schedule.UserId)
+	recipient := GetAccount(schedule.RecipientId)
+	if sender == nil {
+		return
+	}
+
+	now := time.Now()
+	var err error
+	if recipient == nil {
+		err = errors.New("The recipient's account no longer exists")
+	} else {
+		//Moving the schedule on happens in the same transaction as the transfer, so an occurrence
+		//can never be paid twice
+		_, err = sendTransfer(sender, recipient, schedule.Amount, schedule.Memo, func(tx *gorm.DB, token *TxToken) error {
+			updates := schedule.next(ScheduleCompleted)
+			updates["last_run_at"] = now
+			updates["last_error"] = ""
+			updates["claimed_at"] = gorm.Expr("NULL")
+			r := tx.Table("scheduled_transfers").Where("id = ? AND due_at = ?", schedule.ID, schedule.DueAt).UpdateColumns(updates)
+			if r.Error == nil && r.RowsAffected != 1 {
+				return errors.New("Scheduled transfer changed while running")
+			}
+			return r.Error
+		})
+	}
+
+	if err == nil {
+		mailScheduleOutcome(sender, schedule, fmt.Sprintf("Your scheduled transfer of %s to %s went through.",
+			schedule.Amount, recipient.Fullname))
+		return
+	}
+
+	attempts := schedule.Attempts + 1
+	updates := map[string] interface{} {"last_run_at" : now, "last_error" : err.Error(),
+		"attempts" : attempts, "claimed_at" : gorm.Expr("NULL")}
+
+	retry := err == ErrInsufficientFunds && attempts < MaxScheduleAttempts
+	if retry {
+		updates["next_run_at"] = now.Add(ScheduleRetryBackoff * time.Duration(1 << uint(attempts - 1)))
+	} else {
+		for k, v := range schedule.next(ScheduleFailed) {
+			updates[k] = v
+		}
+	}
+	Db.Table("scheduled_transfers").Where("id = ?", schedule.ID).UpdateColumns(updates)
+
+	name := "the recipient"
+	if recipient != nil {
+		name = recipient.Fullname
+	}
+
+	body := fmt.Sprintf("Your scheduled transfer of %s to %s failed. %s.", schedule.Amount, name, err.Error())
+	if retry {
+		body += fmt.Sprintf(" We will try again at %s.", updates["next_run_at"].(time.Time).Format(time.RFC1123))
+	} else {
+		body += " This transfer has been skipped."
+	}
+	mailScheduleOutcome(sender, schedule, body)
+}
+
+func mailScheduleOutcome(sender *Account, schedule *ScheduledTransfer, body string) {
+
+	mail := &MailRequest{}
+	mail.Subject = "LitePay - Scheduled Transfer"
+	mail.Body = body
+	mail.To = sender.Email
+	mail.Name = sender.Fullname
+
+	MailQueue <- mail
+}
+
+//Run every schedule that is due
+func RunDueScheduledTransfers() error {
+
+	now := time.Now()
+	data := make([]*ScheduledTransfer, 0)
+	err := Db.Table("scheduled_transfers").Where("status = ? AND next_run_at <= ? AND deleted_at IS NULL", ScheduleActive, now).
+		Order("next_run_at asc").Limit(100).Find(&data).Error
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range data {
+
+		//Claim it first so a slow run is not picked up again by the next tick
+		r := Db.Table("scheduled_transfers").Where("id = ? AND status = ? AND next_run_at <= ? AND (claimed_at IS NULL OR claimed_at < ?)",
+			schedule.ID, ScheduleActive, now, now.Add(-scheduleLease)).UpdateColumn("claimed_at", now)
+		if r.Error != nil || r.RowsAffected != 1 {
+			continue
+		}
+
+		runScheduledTransfer(schedule)
+	}
+
+	return nil
+}
+
+func ScheduledTransferWorker() {
+
+	for {
+
+		time.Sleep(time.Minute)
+		err := RunDueScheduledTransfers()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
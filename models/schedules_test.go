@@ -0,0 +1,131 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleAdvance(t *testing.T) {
+
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name string
+		frequency string
+		start time.Time
+		due time.Time
+		next time.Time
+	}{
+		{"daily", ScheduleDaily, at(2026, 1, 31), at(2026, 1, 31), at(2026, 2, 1)},
+		{"daily over a year end", ScheduleDaily, at(2026, 12, 31), at(2026, 12, 31), at(2027, 1, 1)},
+		{"weekly", ScheduleWeekly, at(2026, 2, 25), at(2026, 2, 25), at(2026, 3, 4)},
+		{"monthly", ScheduleMonthly, at(2026, 1, 15), at(2026, 1, 15), at(2026, 2, 15)},
+		{"monthly into a short month", ScheduleMonthly, at(2026, 1, 31), at(2026, 1, 31), at(2026, 2, 28)},
+		{"monthly into a leap february", ScheduleMonthly, at(2028, 1, 30), at(2028, 1, 30), at(2028, 2, 29)},
+		{"monthly back to the start day", ScheduleMonthly, at(2026, 1, 31), at(2026, 2, 28), at(2026, 3, 31)},
+		{"monthly into a 30 day month", ScheduleMonthly, at(2026, 1, 31), at(2026, 3, 31), at(2026, 4, 30)},
+		{"monthly over a year end", ScheduleMonthly, at(2026, 12, 31), at(2026, 12, 31), at(2027, 1, 31)},
+		{"once", ScheduleOnce, at(2026, 1, 31), at(2026, 1, 31), at(2026, 1, 31)},
+	}
+
+	for _, c := range cases {
+		schedule := &ScheduledTransfer{Frequency: c.frequency, StartAt: c.start}
+		if next := schedule.advance(c.due); !next.Equal(c.next) {
+			t.Errorf("%s: after %s comes %s, want %s", c.name, c.due, next, c.next)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+
+	now := time.Now()
+
+	once := &ScheduledTransfer{Frequency: ScheduleOnce, StartAt: now, DueAt: now}
+	if updates := once.next(ScheduleFailed); updates["status"] != ScheduleFailed {
+		t.Errorf("one-off transfer moved on with %v", updates)
+	}
+
+	//Occurrences missed while the worker was down are skipped, not paid all at once
+	start := now.AddDate(0, 0, -10)
+	daily := &ScheduledTransfer{Frequency: ScheduleDaily, StartAt: start, DueAt: start}
+	updates := daily.next(ScheduleCompleted)
+	due, ok := updates["due_at"].(time.Time)
+	if !ok || due.Before(now) || due.After(now.AddDate(0, 0, 1)) || updates["status"] != nil {
+		t.Errorf("daily transfer ten days behind moved on with %v", updates)
+	}
+
+	if updates["attempts"] != 0 || updates["next_run_at"] != due {
+		t.Errorf("daily transfer moved on with %v", updates)
+	}
+
+	end := now.Add(time.Hour)
+	ending := &ScheduledTransfer{Frequency: ScheduleWeekly, StartAt: now, DueAt: now, EndAt: &end}
+	if updates := ending.next(ScheduleCompleted); updates["status"] != ScheduleCompleted {
+		t.Errorf("weekly transfer past its end moved on with %v", updates)
+	}
+}
+
+//A run moves the schedule on with the transfer, so running the same occurrence again pays nothing
+func TestScheduledTransferPaysEachOccurrenceOnce(t *testing.T) {
+
+	requireDb(t)
+	sender := newTestAccount(t, naira(1000).Kobo)
+	recipient := newTestAccount(t, 0)
+
+	schedule, err := CreateScheduledTransfer(sender.ID, &ScheduledTransferPayload{Recipient: recipient.Email,
+		Amount: "100", Frequency: ScheduleDaily, StartAt: time.Now(), Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Loaded back the way the worker sees it, with due_at as stored
+	schedule = GetScheduledTransfer(sender.ID, schedule.ID)
+	if schedule == nil {
+		t.Fatal("schedule not found")
+	}
+	stale := *schedule
+	runScheduledTransfer(schedule)
+	runScheduledTransfer(&stale)
+
+	if balance := balanceOf(t, recipient.ID); balance != naira(100).Kobo {
+		t.Errorf("recipient holds %d, want %d", balance, naira(100).Kobo)
+	}
+
+	current := GetScheduledTransfer(sender.ID, schedule.ID)
+	if current == nil || !current.DueAt.After(schedule.DueAt) || current.Status != ScheduleActive {
+		t.Errorf("schedule after a run %+v", current)
+	}
+
+	requireReconciled(t, sender.ID, recipient.ID)
+}
+
+func TestScheduledTransferRetriesShortFunds(t *testing.T) {
+
+	requireDb(t)
+	sender := newTestAccount(t, naira(50).Kobo)
+	recipient := newTestAccount(t, 0)
+
+	schedule, err := CreateScheduledTransfer(sender.ID, &ScheduledTransferPayload{Recipient: recipient.Email,
+		Amount: "100", Frequency: ScheduleMonthly, StartAt: time.Now(), Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule = GetScheduledTransfer(sender.ID, schedule.ID)
+	if schedule == nil {
+		t.Fatal("schedule not found")
+	}
+	runScheduledTransfer(schedule)
+
+	current := GetScheduledTransfer(sender.ID, schedule.ID)
+	if current == nil || current.Attempts != 1 || !current.DueAt.Equal(schedule.DueAt) ||
+		!current.NextRunAt.After(time.Now()) || current.Status != ScheduleActive {
+		t.Errorf("schedule after running short %+v", current)
+	}
+
+	if balance := balanceOf(t, recipient.ID); balance != 0 {
+		t.Errorf("recipient holds %d, want nothing", balance)
+	}
+}
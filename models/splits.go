@@ -0,0 +1,435 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"strings"
+	"time"
+	"fmt"
+	"os"
+)
+
+const (
+	SplitEqual = "equal"
+	SplitCustom = "custom"
+
+	MaxSplitParticipants = 20
+
+	//A split sends a request to every participant, so splits are capped on their own. Each
+	//participant is still held to MaxOpenRequestsPerPayer
+	MaxSplitsPerHour = 3
+
+	//A participant is reminded at most once in this window
+	SplitReminderInterval = time.Hour
+)
+
+//A bill one user paid and wants shared with others. Every participant's share is a money request
+//(see money_requests.go) owed to the creator
+type Split struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"index"`
+	Title string `json:"title"`
+	Total Money `json:"total" gorm:"embedded;embedded_prefix:total_"`
+	Mode string `json:"mode"`
+
+	Shares []*SplitShare `sql:"-" gorm:"-" json:"shares"`
+	Paid Money `sql:"-" gorm:"-" json:"paid"`
+	Outstanding Money `sql:"-" gorm:"-" json:"outstanding"`
+}
+
+type SplitShare struct {
+	gorm.Model
+	SplitId uint `json:"split_id" gorm:"index"`
+	UserId uint `json:"user_id"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	TokenId uint `json:"token_id" gorm:"index"`
+	LastRemindedAt *time.Time `json:"last_reminded_at"`
+
+	Status TokenStatus `sql:"-" gorm:"-" json:"status"`
+	Token string `sql:"-" gorm:"-" json:"token"`
+	User *Account `sql:"-" gorm:"-" json:"user"`
+}
+
+type SplitParticipantPayload struct {
+	User string `json:"user"` //email, phone, handle or user id
+	Amount json.Number `json:"amount"` //custom splits only
+}
+
+type SplitPayload struct {
+	Title string `json:"title"`
+	Total json.Number `json:"total"`
+	Mode string `json:"mode"`
+
+	//Equal splits only. Count the creator as one of the people sharing the bill
+	IncludeMe bool `json:"include_me"`
+	Participants []*SplitParticipantPayload `json:"participants"`
+}
+
+type SplitReminderPayload struct {
+	Channel string `json:"channel"` //email, sms or empty for both
+}
+
+var ErrSplitNotFound = errors.New("Split not found")
+
+func CreateSplit(user uint, payload *SplitPayload) (*Split, error) {
+
+	creator := GetAccount(user)
+	if creator == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	title := strings.TrimSpace(payload.Title)
+	if len(title) > maxMemoLength {
+		return nil, errors.New(fmt.Sprintf("Title should not be longer than %d characters", maxMemoLength))
+	}
+
+	total, err := ParseMoney(payload.Total, DefaultCurrency)
+	if err != nil || !total.IsPositive() {
+		return nil, errors.New("Invalid total")
+	}
+
+	if len(payload.Participants) == 0 || len(payload.Participants) > MaxSplitParticipants {
+		return nil, errors.New(fmt.Sprintf("A split should have between 1 and %d participants", MaxSplitParticipants))
+	}
+
+	seen := make(map[uint] bool)
+	shares := make([]*SplitShare, 0)
+	for _, p := range payload.Participants {
+
+		account := FindAccount(p.User)
+		if account == nil {
+			return nil, errors.New(fmt.Sprintf("No account found for %s", p.User))
+		}
+
+		if account.ID == user {
+			return nil, errors.New("Leave yourself out of the participants. Use include_me to take a share")
+		}
+
+		if seen[account.ID] {
+			return nil, errors.New(fmt.Sprintf("%s is listed more than once", p.User))
+		}
+		seen[account.ID] = true
+
+		share := &SplitShare{}
+		share.UserId = account.ID
+		share.User = account
+		if payload.Mode == SplitCustom {
+			share.Amount, err = ParseMoney(p.Amount, DefaultCurrency)
+			if err != nil || !share.Amount.IsPositive() {
+				return nil, errors.New(fmt.Sprintf("Invalid amount for %s", p.User))
+			}
+		}
+		shares = append(shares, share)
+	}
+
+	switch payload.Mode {
+	case SplitEqual, "":
+		payload.Mode = SplitEqual
+		splitEqually(total, shares, payload.IncludeMe)
+	case SplitCustom:
+		sum := Kobo(0)
+		for _, share := range shares {
+			sum = sum.Add(share.Amount)
+		}
+
+		//Whatever the shares do not cover is the creator's own part of the bill
+		if sum.GreaterThan(total) {
+			return nil, errors.New(fmt.Sprintf("Shares add up to %s, more than the total of %s", sum, total))
+		}
+	default:
+		return nil, errors.New("Mode should be equal or custom")
+	}
+
+	err = checkSplitLimits(user, shares)
+	if err != nil {
+		return nil, err
+	}
+
+	split := &Split{}
+	split.UserId = user
+	split.Title = title
+	split.Total = total
+	split.Mode = payload.Mode
+
+	tx := Db.Begin()
+	err = tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Create(split).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("Cannot create split at this time. Please retry")
+	}
+
+	note := fmt.Sprintf("Your share of %s", total)
+	if title != "" {
+		note = fmt.Sprintf("Your share of %s", title)
+	}
+
+	tokens := make(map[uint] *TxToken)
+	for _, share := range shares {
+
+		//Shares too small to split any further come out as zero. Nothing to ask for
+		if !share.Amount.IsPositive() {
+			continue
+		}
+
+		token, err := raiseMoneyRequest(tx, user, share.UserId, share.Amount, note)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		share.SplitId = split.ID
+		share.TokenId = token.ID
+		err = tx.Create(share).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		tokens[share.UserId] = token
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	for payer, token := range tokens {
+		wsMessage := &WsMessage{}
+		wsMessage.Event = WsRequestReceived
+		wsMessage.Account = creator
+		wsMessage.Amount = token.Amount
+		wsMessage.Token = token.Token
+		wsMessage.Memo = note
+		SendWsMessageTo(payer, wsMessage)
+	}
+
+	return GetSplit(user, split.ID), nil
+}
+
+func checkSplitLimits(user uint, shares []*SplitShare) error {
+
+	created := 0
+	err := Db.Table("splits").Where("user_id = ? AND created_at >= ?", user, time.Now().Add(-time.Hour)).Count(&created).Error
+	if err != nil {
+		return err
+	}
+
+	if created >= MaxSplitsPerHour {
+		return errors.New("You have created too many splits. Please try again later")
+	}
+
+	for _, share := range shares {
+		if !share.Amount.IsPositive() {
+			continue
+		}
+
+		err = checkOpenMoneyRequests(user, share.UserId)
+		if err == ErrTooManyOpenRequests {
+			return errors.New(fmt.Sprintf("You already have too many open requests with %s", share.User.Fullname))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Divide total between the shares, and the creator when includeMe is set. Kobo that do not divide
+//evenly go to the participants first, one each, so the shares always add up to what is asked for
+func splitEqually(total Money, shares []*SplitShare, includeMe bool) {
+
+	people := int64(len(shares))
+	if includeMe {
+		people += 1
+	}
+
+	each := total.Kobo / people
+	left := total.Kobo % people
+	for _, share := range shares {
+		share.Amount = NewMoney(each, total.Currency)
+		if left > 0 {
+			share.Amount = NewMoney(each + 1, total.Currency)
+			left -= 1
+		}
+	}
+}
+
+//A split with each share's progress. Only its creator can see it
+func GetSplit(user, id uint) *Split {
+
+	split := &Split{}
+	err := Db.Table("splits").Where("id = ? AND user_id = ?", id, user).First(split).Error
+	if err != nil {
+		return nil
+	}
+
+	return split.load()
+}
+
+func GetSplitsFor(user uint) []*Split {
+
+	data := make([]*Split, 0)
+	err := Db.Table("splits").Where("user_id = ?", user).Order("id desc").Limit(50).Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	for _, split := range data {
+		split.load()
+	}
+
+	return data
+}
+
+func (split *Split) load() *Split {
+
+	shares := make([]*SplitShare, 0)
+	Db.Table("split_shares").Where("split_id = ?", split.ID).Order("id asc").Find(&shares)
+
+	now := time.Now()
+	split.Paid = NewMoney(0, split.Total.Currency)
+	split.Outstanding = NewMoney(0, split.Total.Currency)
+	for _, share := range shares {
+
+		share.User = GetAccount(share.UserId)
+		token := GetTxTokenById(share.TokenId)
+		if token != nil {
+			share.Token = token.Token
+			share.Status = token.Status
+			if token.Status == TokenClaimed && token.stale(now) {
+				share.Status = TokenExpired
+			}
+		}
+
+		switch share.Status {
+		case TokenAuthorized:
+			split.Paid = split.Paid.Add(share.Amount)
+		case TokenClaimed:
+			split.Outstanding = split.Outstanding.Add(share.Amount)
+		}
+	}
+
+	split.Shares = shares
+	return split
+}
+
+//Push the latest state of the split a share token belongs to, if any, to the split's creator
+func notifySplitProgress(token *TxToken) {
+
+	if token.Kind != TokenKindRequest {
+		return
+	}
+
+	share := &SplitShare{}
+	err := Db.Table("split_shares").Where("token_id = ?", token.ID).First(share).Error
+	if err != nil {
+		return
+	}
+
+	split := GetSplit(token.RecvBy, share.SplitId)
+	if split == nil {
+		return
+	}
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsSplitProgress
+	wsMessage.Account = GetAccount(token.UserId)
+	wsMessage.Amount = share.Amount
+	wsMessage.Token = token.Token
+	wsMessage.Split = split
+	SendWsMessageTo(split.UserId, wsMessage)
+}
+
+//Nudge everyone who has not paid their share yet. Returns how many people were reminded
+func RemindSplitParticipants(user, id uint, payload *SplitReminderPayload) (int, error) {
+
+	split := GetSplit(user, id)
+	if split == nil {
+		return 0, ErrSplitNotFound
+	}
+
+	email, sms := true, true
+	switch payload.Channel {
+	case "email":
+		sms = false
+	case "sms":
+		email = false
+	case "":
+	default:
+		return 0, errors.New("Channel should be email or sms")
+	}
+
+	creator := GetAccount(user)
+	if creator == nil {
+		return 0, errors.New("Account not found")
+	}
+
+	now := time.Now()
+	reminded := 0
+	for _, share := range split.Shares {
+
+		if share.Status != TokenClaimed || share.User == nil {
+			continue
+		}
+
+		if share.LastRemindedAt != nil && now.Sub(*share.LastRemindedAt) < SplitReminderInterval {
+			continue
+		}
+
+		//Claim the reminder first, so two calls at once do not both send one
+		r := Db.Table("split_shares").Where("id = ? AND (last_reminded_at IS NULL OR last_reminded_at < ?)",
+			share.ID, now.Add(-SplitReminderInterval)).UpdateColumn("last_reminded_at", now)
+		if r.Error != nil || r.RowsAffected != 1 {
+			continue
+		}
+
+		body := fmt.Sprintf("%s is waiting on your share of %s for %s. Pay it from your LitePay requests with token %s",
+			creator.Fullname, share.Amount, splitName(split), share.Token)
+
+		if email && share.User.Email != "" {
+			mail := &MailRequest{}
+			mail.Subject = "LitePay - Payment Reminder"
+			mail.Body = body
+			mail.To = share.User.Email
+			mail.Name = share.User.Fullname
+			MailQueue <- mail
+		}
+
+		if sms && share.User.Phone != "" {
+			SmsQueue <- newSmsRequest(share.User.Phone, body)
+		}
+
+		reminded += 1
+	}
+
+	return reminded, nil
+}
+
+func splitName(split *Split) string {
+
+	if split.Title != "" {
+		return split.Title
+	}
+
+	return fmt.Sprintf("a %s bill", split.Total)
+}
+
+func newSmsRequest(to, body string) *SmsRequest {
+
+	sms := &SmsRequest{}
+	sms.ApiToken = os.Getenv("SMS_API_TOKEN")
+	sms.From = os.Getenv("SMS_SENDER")
+	if sms.From == "" {
+		sms.From = "LitePay"
+	}
+	sms.To = to
+	sms.Body = body
+	return sms
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestSplitEqually(t *testing.T) {
+
+	cases := []struct {
+		total int64
+		participants int
+		includeMe bool
+		amounts []int64
+	}{
+		{10000, 2, false, []int64 {5000, 5000}},
+		{10001, 2, false, []int64 {5001, 5000}},
+		{10000, 3, false, []int64 {3334, 3333, 3333}},
+		{10000, 2, true, []int64 {3334, 3333}},
+		{10002, 2, true, []int64 {3334, 3334}},
+		{2, 3, false, []int64 {1, 1, 0}},
+		{1, 1, true, []int64 {1}},
+		{1, 2, true, []int64 {1, 0}},
+	}
+
+	for _, c := range cases {
+		shares := make([]*SplitShare, c.participants)
+		for i := range shares {
+			shares[i] = &SplitShare{}
+		}
+
+		splitEqually(Kobo(c.total), shares, c.includeMe)
+
+		var sum int64
+		for i, share := range shares {
+			sum += share.Amount.Kobo
+			if share.Amount.Kobo != c.amounts[i] || share.Amount.Currency != DefaultCurrency {
+				t.Errorf("%d kobo between %d, include me %v: share %d is %s, want %d", c.total, c.participants,
+					c.includeMe, i, share.Amount, c.amounts[i])
+			}
+		}
+
+		//Without the creator taking a part, nothing can be left over
+		if !c.includeMe && sum != c.total {
+			t.Errorf("%d kobo between %d: shares add up to %d", c.total, c.participants, sum)
+		}
+	}
+}
+
+func TestSplitTracksPayments(t *testing.T) {
+
+	requireDb(t)
+	creator := newTestAccount(t, 0)
+	first := newTestAccount(t, naira(1000).Kobo)
+	second := newTestAccount(t, naira(1000).Kobo)
+
+	split, err := CreateSplit(creator.ID, &SplitPayload{Title: "Dinner", Total: "100.01", Mode: SplitEqual,
+		Participants: []*SplitParticipantPayload{{User: first.Email}, {User: second.Email}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(split.Shares) != 2 || split.Outstanding.Kobo != 10001 || !split.Paid.IsZero() {
+		t.Fatalf("new split %+v", split)
+	}
+
+	share := split.Shares[0]
+	err = AuthorizePayment(share.UserId, &AuthorizePaymentPayload{Token: share.Token, Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	split = GetSplit(creator.ID, split.ID)
+	if split.Paid != share.Amount || split.Outstanding.Kobo != 10001 - share.Amount.Kobo {
+		t.Errorf("after one share was paid, paid %s and outstanding %s", split.Paid, split.Outstanding)
+	}
+
+	if split.Shares[0].Status != TokenAuthorized || split.Shares[1].Status != TokenClaimed {
+		t.Errorf("share statuses %s and %s", split.Shares[0].Status, split.Shares[1].Status)
+	}
+
+	if balance := balanceOf(t, creator.ID); balance != share.Amount.Kobo {
+		t.Errorf("creator holds %d, want %d", balance, share.Amount.Kobo)
+	}
+
+	if GetSplit(first.ID, split.ID) != nil {
+		t.Error("a participant can see the split")
+	}
+
+	requireReconciled(t, creator.ID, first.ID, second.ID)
+}
+
+func TestCreateSplitValidation(t *testing.T) {
+
+	requireDb(t)
+	creator := newTestAccount(t, 0)
+	other := newTestAccount(t, 0)
+	participant := func(user string, amount string) *SplitParticipantPayload {
+		return &SplitParticipantPayload{User: user, Amount: json.Number(amount)}
+	}
+
+	cases := []struct {
+		name string
+		payload *SplitPayload
+	}{
+		{"no participants", &SplitPayload{Total: "100"}},
+		{"no total", &SplitPayload{Participants: []*SplitParticipantPayload{participant(other.Email, "")}}},
+		{"yourself", &SplitPayload{Total: "100", Participants: []*SplitParticipantPayload{participant(creator.Email, "")}}},
+		{"twice", &SplitPayload{Total: "100", Participants: []*SplitParticipantPayload{participant(other.Email, ""),
+			participant(fmt.Sprint(other.ID), "")}}},
+		{"unknown user", &SplitPayload{Total: "100", Participants: []*SplitParticipantPayload{
+			participant("nobody-" + GenUniqueKey() + "@example.com", "")}}},
+		{"custom over the total", &SplitPayload{Total: "100", Mode: SplitCustom,
+			Participants: []*SplitParticipantPayload{participant(other.Email, "100.01")}}},
+		{"custom without an amount", &SplitPayload{Total: "100", Mode: SplitCustom,
+			Participants: []*SplitParticipantPayload{participant(other.Email, "")}}},
+		{"unknown mode", &SplitPayload{Total: "100", Mode: "weighted",
+			Participants: []*SplitParticipantPayload{participant(other.Email, "")}}},
+	}
+
+	for _, c := range cases {
+		if split, err := CreateSplit(creator.ID, c.payload); err == nil {
+			t.Errorf("%s: created split %d", c.name, split.ID)
+		}
+	}
+}
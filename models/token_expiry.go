@@ -0,0 +1,179 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"os"
+	"time"
+	"fmt"
+)
+
+var ErrTokenExpired = errors.New("This payment token has expired. Please ask for a new one")
+
+var (
+	//How long a token can wait to be claimed by a payee. TOKEN_UNCLAIMED_TTL, e.g 15m
+	UnclaimedTokenTTL = durationFromEnv("TOKEN_UNCLAIMED_TTL", 15 * time.Minute)
+
+	//How long a claimed token can wait for the payer to authorize it. TOKEN_CLAIMED_TTL, e.g 5m
+	ClaimedTokenTTL = durationFromEnv("TOKEN_CLAIMED_TTL", 5 * time.Minute)
+
+	//How long a money request waits for the payer to pay or decline it. MONEY_REQUEST_TTL, e.g 72h
+	MoneyRequestTTL = durationFromEnv("MONEY_REQUEST_TTL", 72 * time.Hour)
+)
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		fmt.Printf("Invalid %s '%s'. Using %s\n", key, value, fallback)
+		return fallback
+	}
+
+	return d
+}
+
+//When the token stops being usable in its current state. nil for states that do not expire
+func (token *TxToken) expiry() *time.Time {
+
+	if token.StatusChangedAt == nil {
+		return nil
+	}
+
+	var ttl time.Duration
+	switch token.Status {
+	case TokenCreated:
+		ttl = UnclaimedTokenTTL
+	case TokenClaimed:
+		ttl = ClaimedTokenTTL
+		if token.Kind == TokenKindRequest {
+			ttl = MoneyRequestTTL
+		}
+	default:
+		return nil
+	}
+
+	at := token.StatusChangedAt.Add(ttl)
+	return &at
+}
+
+func (token *TxToken) stale(now time.Time) bool {
+	at := token.expiry()
+	return at != nil && !now.Before(*at)
+}
+
+//Check a locked token's TTL. A token past its TTL is expired on the spot, without waiting for the
+//sweeper. Returns ErrTokenExpired if the token is, or has just become, expired, along with whether this
+//call expired it. The caller should then hand tx to commitExpiry so the expiry sticks
+func checkTokenExpiry(tx *gorm.DB, token *TxToken) (bool, error) {
+
+	if token.Status == TokenExpired {
+		return false, ErrTokenExpired
+	}
+
+	if !token.stale(time.Now()) {
+		return false, nil
+	}
+
+	err := transitionToken(tx, token, TokenExpired, 0, "Token expired", nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, ErrTokenExpired
+}
+
+//Commit an expiry found by checkTokenExpiry and, if it was new, tell the parties the same way the sweeper does
+func commitExpiry(tx *gorm.DB, token *TxToken, expired bool) {
+
+	err := tx.Commit().Error
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if expired {
+		notifyTokenExpired(token)
+	}
+}
+
+func notifyTokenExpired(token *TxToken) {
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsTokenExpired
+	wsMessage.Amount = token.Amount
+	wsMessage.Token = token.Token
+	if token.RecvBy > 0 {
+		wsMessage.Account = GetAccount(token.RecvBy)
+	}
+
+	SendWsMessageTo(token.UserId, wsMessage)
+
+	//Whoever asked for the money should know too
+	if token.Kind == TokenKindRequest {
+		wsMessage := &WsMessage{}
+		wsMessage.Event = WsTokenExpired
+		wsMessage.Amount = token.Amount
+		wsMessage.Token = token.Token
+		wsMessage.Account = GetAccount(token.UserId)
+		SendWsMessageTo(token.RecvBy, wsMessage)
+		notifySplitProgress(token)
+	}
+}
+
+//Expire every token that has outlived its TTL
+func ExpireStaleTokens() error {
+
+	now := time.Now()
+	data := make([]*TxToken, 0)
+	err := Db.Table("tx_tokens").
+		Where("state = ? AND status_changed_at <= ?", TokenCreated, now.Add(-UnclaimedTokenTTL)).
+		Or("state = ? AND kind <> ? AND status_changed_at <= ?", TokenClaimed, TokenKindRequest, now.Add(-ClaimedTokenTTL)).
+		Or("state = ? AND kind = ? AND status_changed_at <= ?", TokenClaimed, TokenKindRequest, now.Add(-MoneyRequestTTL)).
+		Find(&data).Error
+	if err != nil {
+		return err
+	}
+
+	for _, stale := range data {
+
+		tx := Db.Begin()
+		err = tx.Error
+		if err != nil {
+			return err
+		}
+
+		locked, err := lockToken(tx, stale.Token)
+		if err != nil {
+			tx.Rollback()
+			continue
+		}
+
+		expired, err := checkTokenExpiry(tx, locked)
+		if err != ErrTokenExpired {
+			//Moved on or failed since we looked
+			tx.Rollback()
+			continue
+		}
+
+		commitExpiry(tx, locked, expired)
+	}
+
+	return nil
+}
+
+func TokenExpiryWorker() {
+
+	for {
+
+		time.Sleep(30 * time.Second)
+		err := ExpireStaleTokens()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenExpiry(t *testing.T) {
+
+	changed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		status TokenStatus
+		kind string
+		ttl time.Duration
+	}{
+		{"unclaimed", TokenCreated, TokenKindPayment, UnclaimedTokenTTL},
+		{"claimed", TokenClaimed, TokenKindPayment, ClaimedTokenTTL},
+		{"request", TokenClaimed, TokenKindRequest, MoneyRequestTTL},
+		{"authorized", TokenAuthorized, TokenKindPayment, 0},
+		{"declined", TokenDeclined, TokenKindRequest, 0},
+		{"already expired", TokenExpired, TokenKindPayment, 0},
+	}
+
+	for _, c := range cases {
+		token := &TxToken{Status: c.status, Kind: c.kind, StatusChangedAt: &changed}
+		at := token.expiry()
+		if c.ttl == 0 {
+			if at != nil || token.stale(changed.AddDate(1, 0, 0)) {
+				t.Errorf("%s: expires at %v, want never", c.name, at)
+			}
+			continue
+		}
+
+		if at == nil || !at.Equal(changed.Add(c.ttl)) {
+			t.Errorf("%s: expires at %v, want %s", c.name, at, changed.Add(c.ttl))
+			continue
+		}
+
+		if token.stale(changed.Add(c.ttl - time.Second)) || !token.stale(changed.Add(c.ttl)) {
+			t.Errorf("%s: not stale exactly at %s", c.name, changed.Add(c.ttl))
+		}
+	}
+
+	if (&TxToken{Status: TokenCreated}).expiry() != nil {
+		t.Error("a token without a status change time expires")
+	}
+}
+
+func TestDurationFromEnv(t *testing.T) {
+
+	cases := map[string] time.Duration {
+		"" : time.Minute,
+		"90s" : 90 * time.Second,
+		"2h" : 2 * time.Hour,
+		"0s" : time.Minute,
+		"-5m" : time.Minute,
+		"soon" : time.Minute,
+	}
+
+	for value, want := range cases {
+		t.Setenv("TEST_TOKEN_TTL", value)
+		if d := durationFromEnv("TEST_TOKEN_TTL", time.Minute); d != want {
+			t.Errorf("durationFromEnv(%q) = %s, want %s", value, d, want)
+		}
+	}
+}
+
+//Backdate a token so it looks like it has sat in its state for ago
+func ageToken(t *testing.T, token *TxToken, ago time.Duration) {
+
+	t.Helper()
+	err := Db.Table("tx_tokens").Where("id = ?", token.ID).UpdateColumn("status_changed_at", time.Now().Add(-ago)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//The sweeper expires a stale claim and gives the payer back what it held
+func TestSweeperExpiresStaleClaims(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, naira(1000).Kobo)
+	payee := newTestAccount(t, 0)
+
+	stale := claimToken(t, payer, payee, naira(100).Kobo)
+	fresh := claimToken(t, payer, payee, naira(200).Kobo)
+	ageToken(t, stale, ClaimedTokenTTL + time.Minute)
+
+	err := ExpireStaleTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := GetTxToken(stale.Token).Status; status != TokenExpired {
+		t.Errorf("stale claim is %s, want expired", status)
+	}
+
+	if status := GetTxToken(fresh.Token).Status; status != TokenClaimed {
+		t.Errorf("fresh claim is %s, want claimed", status)
+	}
+
+	if held := walletOf(t, payer.ID).Held.Kobo; held != naira(200).Kobo + fresh.Fee.Kobo {
+		t.Errorf("payer has %d held, want only the fresh claim's %d", held, naira(200).Kobo + fresh.Fee.Kobo)
+	}
+}
+
+//A claim past its time cannot be paid, even before the sweeper gets to it
+func TestStaleClaimCannotBePaid(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, naira(1000).Kobo)
+	payee := newTestAccount(t, 0)
+
+	token := claimToken(t, payer, payee, naira(100).Kobo)
+	ageToken(t, token, ClaimedTokenTTL + time.Minute)
+
+	err := AuthorizePayment(payer.ID, &AuthorizePaymentPayload{Token: token.Token, Pin: testPin})
+	if err != ErrTokenExpired {
+		t.Fatalf("paying a stale claim returned %v, want ErrTokenExpired", err)
+	}
+
+	if status := GetTxToken(token.Token).Status; status != TokenExpired {
+		t.Errorf("stale claim is %s after the attempt, want expired", status)
+	}
+
+	if balance := balanceOf(t, payee.ID); balance != 0 {
+		t.Errorf("payee holds %d, want nothing", balance)
+	}
+
+	requireReconciled(t, payer.ID, payee.ID)
+}
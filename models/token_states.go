@@ -0,0 +1,173 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"time"
+	"fmt"
+)
+
+//Lifecycle of a TxToken
+//	created -> claimed -> authorized -> reversed
+//	created -> cancelled | expired
+//	claimed -> declined | cancelled | expired
+type TokenStatus string
+
+const (
+	TokenCreated TokenStatus = "created"
+	TokenClaimed TokenStatus = "claimed"
+	TokenAuthorized TokenStatus = "authorized"
+	TokenDeclined TokenStatus = "declined"
+	TokenCancelled TokenStatus = "cancelled"
+	TokenExpired TokenStatus = "expired"
+	TokenReversed TokenStatus = "reversed"
+)
+
+var tokenTransitions = map[TokenStatus] []TokenStatus {
+	TokenCreated : {TokenClaimed, TokenCancelled, TokenExpired},
+	TokenClaimed : {TokenAuthorized, TokenDeclined, TokenCancelled, TokenExpired},
+	TokenAuthorized : {TokenReversed},
+}
+
+//An illegal move between two token states
+type TransitionError struct {
+	Token string
+	From TokenStatus
+	To TokenStatus
+}
+
+func (e *TransitionError) Error() string {
+
+	switch e.From {
+	case TokenAuthorized:
+		return fmt.Sprintf("Token %s has already been redeemed", e.Token)
+	case TokenDeclined, TokenCancelled, TokenExpired, TokenReversed:
+		return fmt.Sprintf("Token %s has been %s", e.Token, e.From)
+	}
+
+	return fmt.Sprintf("Token %s cannot move from %s to %s", e.Token, e.From, e.To)
+}
+
+func CanTransition(from, to TokenStatus) bool {
+
+	for _, next := range tokenTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s TokenStatus) Terminal() bool {
+	return len(tokenTransitions[s]) == 0
+}
+
+//Every state change a token goes through, when, who made it and why
+type TxTokenTransition struct {
+	gorm.Model
+	TokenId uint `json:"token_id" gorm:"index"`
+	From TokenStatus `json:"from"`
+	To TokenStatus `json:"to"`
+	Actor uint `json:"actor"`
+	Reason string `json:"reason"`
+}
+
+//Lock a token row until tx ends
+func lockToken(tx *gorm.DB, token string) (*TxToken, error) {
+
+	locked := &TxToken{}
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Table("tx_tokens").Where("token = ?", token).First(locked).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errors.New(fmt.Sprintf("Token %s not found", token))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return locked, nil
+}
+
+//The one place a token changes state. Checks the move is legal, applies it together with any other
+//columns in updates, and records it. The update is guarded on the current state so a stale token
+//can never overwrite a move made by someone else. actor is the user making the move, 0 for the system
+func transitionToken(tx *gorm.DB, token *TxToken, to TokenStatus, actor uint, reason string, updates map[string] interface{}) error {
+
+	from := token.Status
+	if !CanTransition(from, to) {
+		return &TransitionError{Token: token.Token, From: from, To: to}
+	}
+
+	//A claim's hold lives exactly as long as the claim
+	if from == TokenClaimed {
+		status := HoldReleased
+		if to == TokenAuthorized {
+			status = HoldCaptured
+		}
+
+		err := closeHold(tx, token, status)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	if updates == nil {
+		updates = make(map[string] interface{})
+	}
+	updates["state"] = to
+	updates["status_changed_at"] = now
+
+	r := tx.Table("tx_tokens").Where("id = ? AND state = ?", token.ID, from).UpdateColumns(updates)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected != 1 {
+		return errors.New(fmt.Sprintf("Token %s was changed by another request. Please retry", token.Token))
+	}
+
+	transition := &TxTokenTransition{}
+	transition.TokenId = token.ID
+	transition.From = from
+	transition.To = to
+	transition.Actor = actor
+	transition.Reason = reason
+
+	err := tx.Create(transition).Error
+	if err != nil {
+		return err
+	}
+
+	token.Status = to
+	token.StatusChangedAt = &now
+	return nil
+}
+
+func GetTokenTransitions(token uint) []*TxTokenTransition {
+
+	data := make([]*TxTokenTransition, 0)
+	err := Db.Table("tx_token_transitions").Where("token_id = ?", token).Order("id asc").Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+//Tokens used to have a numeric status where 1 meant redeemed and 0 meant anything else
+func MigrateTokenStatuses() error {
+
+	if !Db.Dialect().HasColumn("tx_tokens", "status") {
+		return nil
+	}
+
+	err := Db.Exec("UPDATE tx_tokens SET state = CASE WHEN status = 1 THEN ? WHEN recv_by > 0 THEN ? ELSE ? END, " +
+		"status_changed_at = updated_at", TokenAuthorized, TokenClaimed, TokenCreated).Error
+	if err != nil {
+		return err
+	}
+
+	return Db.Exec("ALTER TABLE tx_tokens DROP COLUMN status").Error
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+)
+
+//Every pair of states, so a move added to tokenTransitions by mistake shows up here too
+func TestCanTransition(t *testing.T) {
+
+	states := []TokenStatus {TokenCreated, TokenClaimed, TokenAuthorized, TokenDeclined, TokenCancelled,
+		TokenExpired, TokenReversed}
+	allowed := map[[2]TokenStatus] bool {
+		{TokenCreated, TokenClaimed} : true,
+		{TokenCreated, TokenCancelled} : true,
+		{TokenCreated, TokenExpired} : true,
+		{TokenClaimed, TokenAuthorized} : true,
+		{TokenClaimed, TokenDeclined} : true,
+		{TokenClaimed, TokenCancelled} : true,
+		{TokenClaimed, TokenExpired} : true,
+		{TokenAuthorized, TokenReversed} : true,
+	}
+
+	for _, from := range states {
+		for _, to := range states {
+			if CanTransition(from, to) != allowed[[2]TokenStatus{from, to}] {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, !allowed[[2]TokenStatus{from, to}],
+					allowed[[2]TokenStatus{from, to}])
+			}
+		}
+	}
+
+	if CanTransition("", TokenClaimed) || CanTransition(TokenCreated, "redeemed") {
+		t.Error("a move to or from an unknown state was allowed")
+	}
+}
+
+func TestTerminalStates(t *testing.T) {
+
+	cases := map[TokenStatus] bool {
+		TokenCreated : false,
+		TokenClaimed : false,
+		TokenAuthorized : false,
+		TokenDeclined : true,
+		TokenCancelled : true,
+		TokenExpired : true,
+		TokenReversed : true,
+	}
+
+	for status, terminal := range cases {
+		if status.Terminal() != terminal {
+			t.Errorf("%s terminal %v, want %v", status, !terminal, terminal)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"litepay/payments"
+	"time"
+	"fmt"
+)
+
+const (
+	IntentPending = "pending"
+	IntentCompleted = "completed"
+	IntentFailed = "failed"
+	IntentHeld = "held" //paid, but past the account's limits. Parked in suspense to be refunded
+)
+
+//A top up still pending after this long was most likely abandoned at checkout, so it no longer counts
+//against the limits of new ones. If it is paid after all the limits are checked again
+const openTopUpWindow = time.Hour
+
+//How a top up was started
+const (
+	TopUpCheckout = "checkout"
+	TopUpSavedCard = "saved_card"
+	TopUpAuto = "auto"
+)
+
+var ErrUnknownTopUp = errors.New("Unknown transaction reference")
+var ErrTopUpHeld = errors.New("This payment takes your wallet past its limits, so it was not added. It will be refunded to you")
+
+//A wallet top up started by a user. The paystack reference is generated by us and bound to the user
+//and amount before paystack sees it, so verification can only ever credit the right wallet with the right amount
+type TopUpIntent struct {
+	gorm.Model
+	Reference string `json:"reference" gorm:"unique_index"`
+	UserId uint `json:"user_id"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Fee Money `json:"fee" gorm:"embedded;embedded_prefix:fee_"` //quoted when the top up started, taken from Amount
+	Source string `json:"source"`
+	AccessCode string `json:"access_code"`
+	Status string `json:"status"`
+	FailureReason string `json:"failure_reason"`
+}
+
+func CreateTopUpIntent(user uint, amount Money, source string) (*TopUpIntent, error) {
+
+	if !amount.IsPositive() {
+		return nil, errors.New("Amount should be > 0")
+	}
+
+	wallet := GetWallet(user)
+	if wallet == nil {
+		return nil, errors.New("Wallet not found for user")
+	}
+
+	//Checked before the customer pays, counting top ups that are still being paid for. They are checked
+	//again once paid, see FundAccount
+	open, err := openTopUps(user, amount.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkInflowLimits(Db, user, wallet, amount, open)
+	if err != nil {
+		return nil, err
+	}
+
+	intent := &TopUpIntent{}
+	intent.Reference = "LP-TOPUP-" + GenUniqueKey()
+	intent.UserId = user
+	intent.Amount = amount
+	intent.Fee = feeFor(FeeTopUp, user, amount)
+	intent.Source = source
+	intent.Status = IntentPending
+
+	err = Db.Create(intent).Error
+	if err != nil {
+		return nil, errors.New("Failed to top up wallet at this time. Please retry")
+	}
+
+	return intent, nil
+}
+
+//Total of the user's recent top ups that may still be paid
+func openTopUps(user uint, currency string) (Money, error) {
+
+	type result struct {
+		Total int64
+	}
+
+	r := &result{}
+	err := Db.Table("top_up_intents").Select("COALESCE(SUM(amount_kobo), 0) AS total").
+		Where("user_id = ? AND status = ? AND amount_currency = ? AND created_at >= ? AND deleted_at IS NULL",
+		user, IntentPending, currency, time.Now().Add(-openTopUpWindow)).Scan(r).Error
+
+	return NewMoney(r.Total, currency), err
+}
+
+//Start a top up with the payment provider. The returned intent carries the access code for checkout
+func InitTopUp(account *Account, amount Money) (*TopUpIntent, error) {
+
+	intent, err := CreateTopUpIntent(account.ID, amount, TopUpCheckout)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &payments.InitializeRequest{}
+	req.Reference = intent.Reference
+	req.Email = account.Email
+	req.Amount = amount.Kobo
+	req.Currency = amount.Currency
+
+	resp, err := Provider.Initialize(req)
+	if err != nil {
+		fmt.Println(err)
+		setTopUpStatus(intent, IntentFailed, "Could not initialize payment")
+		return nil, errors.New("Failed to top up wallet at this time. Please retry")
+	}
+
+	err = setTopUpAccessCode(intent, resp.AccessCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return intent, nil
+}
+
+func setTopUpAccessCode(intent *TopUpIntent, code string) error {
+	intent.AccessCode = code
+	return Db.Table("top_up_intents").Where("id = ?", intent.ID).UpdateColumn("access_code", code).Error
+}
+
+func GetTopUpIntent(ref string) *TopUpIntent {
+
+	intent := &TopUpIntent{}
+	err := Db.Table("top_up_intents").Where("reference = ?", ref).First(intent).Error
+	if err != nil {
+		return nil
+	}
+
+	return intent
+}
+
+func setTopUpStatus(intent *TopUpIntent, status, reason string) error {
+	intent.Status = status
+	intent.FailureReason = reason
+	return Db.Table("top_up_intents").Where("id = ?", intent.ID).UpdateColumns(map[string] interface{} {
+		"status" : status, "failure_reason" : reason}).Error
+}
+
+//Verify a top up started by user with paystack and credit their wallet
+func VerifyTopUp(user uint, ref string) (*TopUpIntent, error) {
+
+	intent := GetTopUpIntent(ref)
+	if intent == nil {
+		return nil, ErrUnknownTopUp
+	}
+
+	if intent.UserId != user {
+		fmt.Printf("User %d attempted to verify top up %s which belongs to user %d\n", user, ref, intent.UserId)
+		return nil, ErrUnknownTopUp
+	}
+
+	if intent.Status == IntentHeld {
+		return intent, ErrTopUpHeld
+	}
+
+	if intent.Status != IntentPending {
+		return intent, nil
+	}
+
+	txn, err := VerifyTransaction(ref)
+	if err != nil {
+		return nil, errors.New("Failed to verify transaction at this time. Please, retry")
+	}
+
+	return intent, CompleteTopUp(intent, txn)
+}
+
+//Credit the owner of intent once paystack reports txn as successful. Safe to call more than once.
+//Fails with ErrTopUpHeld when the payment went past the account's limits and was parked instead
+func CompleteTopUp(intent *TopUpIntent, txn *payments.Transaction) error {
+
+	if !txn.Successful() {
+		if txn.Status == payments.StatusFailed || txn.Status == payments.StatusAbandoned {
+			setTopUpStatus(intent, IntentFailed, "Payment " + txn.Status)
+		}
+
+		return errors.New("Transaction was not successful")
+	}
+
+	paid := NewMoney(txn.Amount, txn.Currency)
+	if paid != intent.Amount {
+		reason := fmt.Sprintf("Paystack reported %s %d, expected %s %d", paid.Currency, paid.Kobo,
+			intent.Amount.Currency, intent.Amount.Kobo)
+		fmt.Printf("Top up %s rejected. %s\n", intent.Reference, reason)
+		setTopUpStatus(intent, IntentFailed, reason)
+		return errors.New("Transaction amount does not match the top up request")
+	}
+
+	account := GetAccount(intent.UserId)
+	if account == nil {
+		return errors.New("Account not found")
+	}
+
+	fee := intent.Fee
+	if fee.Currency == "" {
+		fee = NewMoney(0, intent.Amount.Currency)
+	}
+
+	err := FundAccount(intent.Reference, account, intent.Amount, fee)
+	if err == ErrTxRefUsed {
+		//Credited, or held, by an earlier call
+		if current := GetTopUpIntent(intent.Reference); current != nil && current.Status == IntentHeld {
+			*intent = *current
+			return ErrTopUpHeld
+		}
+	} else if err == ErrTopUpHeld {
+		if current := GetTopUpIntent(intent.Reference); current != nil {
+			*intent = *current
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	err = setTopUpStatus(intent, IntentCompleted, "")
+	if err != nil {
+		return err
+	}
+
+	err = saveAuthorization(account, txn.Authorization)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return nil
+}
+
+//Park a top up that was paid past the account's limits in suspense, for finance to refund. Called by
+//FundAccount in place of crediting the wallet
+func holdTopUp(tx *gorm.DB, ref string, amount Money, reason string) error {
+
+	float, err := GetSystemAccount(tx, PaystackFloatAccount)
+	if err != nil {
+		return err
+	}
+
+	suspense, err := GetSystemAccount(tx, SuspenseAccount)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryHeldReceipt, ref, "Top up past the account's limits, to be refunded")
+	entry.Debit(float, amount).Credit(suspense, amount)
+	err = PostJournalEntry(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Table("top_up_intents").Where("reference = ?", ref).UpdateColumns(map[string] interface{} {
+		"status" : IntentHeld, "failure_reason" : reason}).Error
+}
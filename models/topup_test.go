@@ -0,0 +1,136 @@
+package models
+
+import (
+	"litepay/payments"
+	"testing"
+)
+
+//A top up paid past the account's limits is parked in suspense instead of going over the cap
+func TestTopUpLimitsCountOpenIntentsAndHoldOverflow(t *testing.T) {
+
+	requireDb(t)
+	account := newTestAccount(t, 0)
+	err := Db.Table("accounts").Where("id = ?", account.ID).UpdateColumn("tier", 0).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	max := limitsForTier(0).DailyInflow
+	first, err := CreateTopUpIntent(account.ID, max, TopUpCheckout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The first one may still be paid, so there is no room left for another
+	_, err = CreateTopUpIntent(account.ID, naira(100), TopUpCheckout)
+	if _, ok := err.(*LimitError); !ok {
+		t.Fatalf("second top up started with %v while the first was open", err)
+	}
+
+	err = CompleteTopUp(first, &payments.Transaction{Reference: first.Reference, Status: payments.StatusSuccess,
+		Amount: first.Amount.Kobo, Currency: first.Amount.Currency})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Started before the first was paid, as if the limit check had been raced
+	second := &TopUpIntent{Reference: "LP-TOPUP-" + GenUniqueKey(), UserId: account.ID, Amount: naira(100),
+		Fee: Kobo(0), Source: TopUpCheckout, Status: IntentPending}
+	err = Db.Create(second).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := &payments.Transaction{Reference: second.Reference, Status: payments.StatusSuccess,
+		Amount: second.Amount.Kobo, Currency: second.Amount.Currency}
+	for i := 0; i < 2; i++ {
+		err = CompleteTopUp(second, txn)
+		if err != ErrTopUpHeld {
+			t.Fatalf("completing a top up past the limits returned %v, want ErrTopUpHeld", err)
+		}
+	}
+
+	if second.Status != IntentHeld {
+		t.Errorf("top up past the limits is %s, want held", second.Status)
+	}
+
+	if balance := balanceOf(t, account.ID); balance != max.Kobo - first.Fee.Kobo {
+		t.Errorf("balance %d, want %d", balance, max.Kobo - first.Fee.Kobo)
+	}
+
+	requireReconciled(t, account.ID)
+}
+
+//A top up reference only credits the user who started it, and only once
+func TestTopUpIsBoundToItsUser(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 0)
+	other := newTestAccount(t, 0)
+
+	intent, err := InitTopUp(account, naira(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = VerifyTopUp(account.ID, intent.Reference)
+	if err == nil {
+		t.Error("an unpaid top up verified")
+	}
+
+	fake.CompleteTransaction(intent.Reference)
+	_, err = VerifyTopUp(other.ID, intent.Reference)
+	if err != ErrUnknownTopUp {
+		t.Errorf("verifying someone else's top up returned %v, want ErrUnknownTopUp", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		verified, err := VerifyTopUp(account.ID, intent.Reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if verified.Status != IntentCompleted {
+			t.Errorf("verified top up is %s", verified.Status)
+		}
+	}
+
+	if balance := balanceOf(t, account.ID); balance != naira(500).Kobo - intent.Fee.Kobo {
+		t.Errorf("balance %d, want %d", balance, naira(500).Kobo - intent.Fee.Kobo)
+	}
+
+	if balance := balanceOf(t, other.ID); balance != 0 {
+		t.Errorf("the other user holds %d", balance)
+	}
+
+	requireReconciled(t, account.ID, other.ID)
+}
+
+//A payment for a different amount than the top up asked for credits nothing
+func TestTopUpRejectsAnotherAmount(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 0)
+
+	intent, err := InitTopUp(account, naira(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.SetTransaction(&payments.Transaction{Reference: intent.Reference, Status: payments.StatusSuccess,
+		Amount: naira(5).Kobo, Currency: DefaultCurrency})
+	_, err = VerifyTopUp(account.ID, intent.Reference)
+	if err == nil {
+		t.Error("a top up paid for the wrong amount verified")
+	}
+
+	if status := GetTopUpIntent(intent.Reference).Status; status != IntentFailed {
+		t.Errorf("top up paid for the wrong amount is %s, want failed", status)
+	}
+
+	if balance := balanceOf(t, account.ID); balance != 0 {
+		t.Errorf("balance %d, want nothing", balance)
+	}
+}
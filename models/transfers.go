@@ -0,0 +1,155 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"encoding/json"
+	"strings"
+	"time"
+	"fmt"
+)
+
+//Send money straight to someone found by email, phone or handle
+type TransferPayload struct {
+	Recipient string `json:"recipient"`
+	Amount json.Number `json:"amount"`
+	Memo string `json:"memo"`
+	Pin string `json:"pin"`
+}
+
+//Push a transfer from user to the recipient. A transfer is a token that is created, claimed for the
+//recipient and authorized in one go, so it is settled exactly like AuthorizePayment and shows in both histories
+func SendTransfer(user uint, payload *TransferPayload) (*TxToken, error) {
+
+	amount, err := ParseMoney(payload.Amount, DefaultCurrency)
+	if err != nil || !amount.IsPositive() {
+		return nil, errors.New("Invalid amount")
+	}
+
+	memo := strings.TrimSpace(payload.Memo)
+	if len(memo) > maxMemoLength {
+		return nil, errors.New(fmt.Sprintf("Memo should not be longer than %d characters", maxMemoLength))
+	}
+
+	recipient := FindAccount(payload.Recipient)
+	if recipient == nil {
+		return nil, errors.New(fmt.Sprintf("No account found for %s", payload.Recipient))
+	}
+
+	if recipient.ID == user {
+		return nil, errors.New("You cannot pay yourself")
+	}
+
+	sender := GetAccount(user)
+	if sender == nil {
+		return nil, errors.New("Account not found")
+	}
+
+	err = VerifyPin(user, payload.Pin)
+	if err != nil {
+		return nil, err
+	}
+
+	return sendTransfer(sender, recipient, amount, memo, nil)
+}
+
+//Move amount from sender to recipient. The pin has already been checked. When within is set it runs in
+//the same database transaction, after the money has moved, and its error undoes the transfer
+func sendTransfer(sender, recipient *Account, amount Money, memo string, within func(tx *gorm.DB, token *TxToken) error) (*TxToken, error) {
+
+	user := sender.ID
+	now := time.Now()
+	token := &TxToken{}
+	token.Kind = TokenKindTransfer
+	token.Token = findToken()
+	token.UserId = user
+	token.Amount = Kobo(0)
+	token.Status = TokenCreated
+	token.StatusChangedAt = &now
+	token.ExactAmount = amount
+	token.AllowedPayee = recipient.ID
+	token.Memo = memo
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Create(token).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("Cannot send money at this time. Please retry")
+	}
+
+	fee := feeFor(FeeTransfer, user, amount)
+	err = transitionToken(tx, token, TokenClaimed, user, "", map[string] interface{} {
+		"amount_kobo" : amount.Kobo, "amount_currency" : amount.Currency, "recv_by" : recipient.ID,
+		"fee_kobo" : fee.Kobo, "fee_currency" : fee.Currency})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	token.Amount = amount
+	token.Fee = fee
+	token.RecvBy = recipient.ID
+	err = settleToken(tx, token, user, "")
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if within != nil {
+		err = within(tx, token)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	go TriggerAutoTopUp(user)
+	notifyTransfer(sender, recipient, token)
+	return GetTxToken(token.Token), nil
+}
+
+func notifyTransfer(sender, recipient *Account, token *TxToken) {
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsTransferReceived
+	wsMessage.Account = sender
+	wsMessage.Amount = token.Amount
+	wsMessage.Token = token.Token
+	SendWsMessageTo(recipient.ID, wsMessage)
+
+	wsMessage = &WsMessage{}
+	wsMessage.Event = WsTransferSent
+	wsMessage.Account = recipient
+	wsMessage.Amount = token.Amount
+	wsMessage.Token = token.Token
+	SendWsMessageTo(sender.ID, wsMessage)
+
+	note := ""
+	if token.Memo != "" {
+		note = fmt.Sprintf(" Memo: %s", token.Memo)
+	}
+
+	mail := &MailRequest{}
+	mail.Subject = "LitePay - Money Received"
+	mail.Body = fmt.Sprintf("%s sent you %s.%s", sender.Fullname, token.Amount, note)
+	mail.To = recipient.Email
+	mail.Name = recipient.Fullname
+	MailQueue <- mail
+
+	mail = &MailRequest{}
+	mail.Subject = "LitePay - Money Sent"
+	mail.Body = fmt.Sprintf("You sent %s to %s.%s", token.Amount, recipient.Fullname, note)
+	mail.To = sender.Email
+	mail.Name = sender.Fullname
+	MailQueue <- mail
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHandleFormat(t *testing.T) {
+
+	cases := map[string] bool {
+		"ada" : true,
+		"ada_lovelace" : true,
+		"a1b2c3" : true,
+		"abcdefghijklmnopqrst" : true,
+		"ab" : false,
+		"abcdefghijklmnopqrstu" : false,
+		"1ada" : false,
+		"_ada" : false,
+		"ada.l" : false,
+		"ada-l" : false,
+		"Ada" : false,
+		"08012345678" : false,
+		"" : false,
+	}
+
+	for handle, valid := range cases {
+		if handleRegexp.MatchString(handle) != valid {
+			t.Errorf("handle %q valid %v, want %v", handle, !valid, valid)
+		}
+	}
+}
+
+//The recipient can be named by email, @handle or user id
+func TestSendTransfer(t *testing.T) {
+
+	requireDb(t)
+	sender := newTestAccount(t, naira(1000).Kobo)
+	recipient := newTestAccount(t, 0)
+
+	handle := "t" + strings.ToLower(GenUniqueKey())[:15]
+	_, err := SetHandle(recipient.ID, "@" + strings.ToUpper(handle))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = SetHandle(sender.ID, handle)
+	if err == nil {
+		t.Error("two accounts took the same handle")
+	}
+
+	for _, name := range []string {strings.ToUpper(recipient.Email), "@" + handle, fmt.Sprint(recipient.ID)} {
+		token, err := SendTransfer(sender.ID, &TransferPayload{Recipient: name, Amount: "100", Memo: "Rent", Pin: testPin})
+		if err != nil {
+			t.Fatalf("sending to %s: %v", name, err)
+		}
+
+		if token.Kind != TokenKindTransfer || token.Status != TokenAuthorized || token.RecvBy != recipient.ID {
+			t.Errorf("transfer to %s %+v", name, token)
+		}
+	}
+
+	if balance := balanceOf(t, recipient.ID); balance != naira(300).Kobo {
+		t.Errorf("recipient holds %d, want %d", balance, naira(300).Kobo)
+	}
+
+	requireReconciled(t, sender.ID, recipient.ID)
+}
+
+func TestSendTransferMovesNothingWhenRefused(t *testing.T) {
+
+	requireDb(t)
+	sender := newTestAccount(t, naira(100).Kobo)
+	recipient := newTestAccount(t, 0)
+
+	cases := []struct {
+		name string
+		payload *TransferPayload
+	}{
+		{"more than the balance", &TransferPayload{Recipient: recipient.Email, Amount: "100.01", Pin: testPin}},
+		{"wrong pin", &TransferPayload{Recipient: recipient.Email, Amount: "10", Pin: "0000"}},
+		{"yourself", &TransferPayload{Recipient: sender.Email, Amount: "10", Pin: testPin}},
+		{"nobody", &TransferPayload{Recipient: "@nobody_" + strings.ToLower(GenUniqueKey())[:10], Amount: "10", Pin: testPin}},
+		{"no amount", &TransferPayload{Recipient: recipient.Email, Amount: "0", Pin: testPin}},
+	}
+
+	for _, c := range cases {
+		if _, err := SendTransfer(sender.ID, c.payload); err == nil {
+			t.Errorf("%s: transfer went through", c.name)
+		}
+	}
+
+	if balance := balanceOf(t, sender.ID); balance != naira(100).Kobo {
+		t.Errorf("sender holds %d, want %d", balance, naira(100).Kobo)
+	}
+
+	sent := 0
+	err := Db.Table("tx_tokens").Where("user_id = ? AND kind = ?", sender.ID, TokenKindTransfer).Count(&sent).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != 0 {
+		t.Errorf("%d transfer tokens left behind by refused transfers", sent)
+	}
+}
@@ -5,19 +5,57 @@ import (
 	"github.com/pkg/errors"
 	"fmt"
 	"encoding/json"
+	"strings"
+	"time"
+)
+
+//What a token was used for
+const (
+	TokenKindPayment = "payment" //created by a payer, claimed by a payee
+	TokenKindTransfer = "transfer" //pushed by a sender straight to a recipient
+	TokenKindRequest = "request" //raised by a payee against a named payer, who pays or declines it
 )
 
 //Represent a transaction token
 type TxToken struct {
 	gorm.Model
 	Token string `json:"token"`
-	Amount float64 `json:"amount"`
-	Status uint `json:"status"`
+	Kind string `json:"kind" gorm:"index"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Status TokenStatus `json:"status" gorm:"column:state;index"`
+	StatusChangedAt *time.Time `json:"status_changed_at"`
 	UserId uint `json:"user_id"`
 	RecvBy uint `json:"recv_by"`
 
+	//Limits the payer set when creating the token. Zero amounts and payee mean no limit
+	MaxAmount Money `json:"max_amount" gorm:"embedded;embedded_prefix:max_amount_"`
+	ExactAmount Money `json:"exact_amount" gorm:"embedded;embedded_prefix:exact_amount_"`
+	AllowedPayee uint `json:"allowed_payee"`
+	Memo string `json:"memo"`
+	AutoAuthorize bool `json:"auto_authorize"`
+
+	//Charged to the payer on top of Amount. Quoted when the token is claimed
+	Fee Money `json:"fee" gorm:"embedded;embedded_prefix:fee_"`
+
+	//Given back so far through refunds. Reaches Amount when the token is reversed
+	Refunded Money `json:"refunded" gorm:"embedded;embedded_prefix:refunded_"`
+
 	User *Account `sql:"-" gorm:"-" json:"user"`
 	Recv *Account `sql:"-" gorm:"-" json:"recv"`
+	ExpiresAt *time.Time `sql:"-" gorm:"-" json:"expires_at"`
+	Refunds []*Refund `sql:"-" gorm:"-" json:"refunds,omitempty"`
+}
+
+//What a payer sends to create a token. Every field is optional
+type CreateTokenPayload struct {
+	MaxAmount json.Number `json:"max_amount"`
+	ExactAmount json.Number `json:"exact_amount"`
+	Payee string `json:"payee"` //email, phone or user id
+	Memo string `json:"memo"`
+
+	//Pay a claim for exactly ExactAmount straight away, without asking for the pin again
+	AutoAuthorize bool `json:"auto_authorize"`
+	Pin string `json:"pin"`
 }
 
 type RequestPaymentPayload struct {
@@ -30,33 +68,90 @@ type AuthorizePaymentPayload struct {
 	Pin string `json:"pin"`
 }
 
-func (p *RequestPaymentPayload) AmountValue() float64 {
-
-	data, err := p.Amount.Float64()
-	if err != nil {
-		return 0
-	}
-
-	return data
+func (p *RequestPaymentPayload) AmountValue() (Money, error) {
+	return ParseMoney(p.Amount, DefaultCurrency)
 }
 
-func CreateToken(user uint) (*TxToken, error) {
+const maxMemoLength = 140
+
+func CreateToken(user uint, payload *CreateTokenPayload) (*TxToken, error) {
 
 	account := GetAccount(user)
 	if account == nil {
 		return nil, errors.New("Account not found")
 	}
 
-	token := findToken()
 	tx := &TxToken{}
+	if payload.MaxAmount != "" {
+		max, err := ParseMoney(payload.MaxAmount, DefaultCurrency)
+		if err != nil || !max.IsPositive() {
+			return nil, errors.New("Invalid max amount")
+		}
+		tx.MaxAmount = max
+	}
+
+	if payload.ExactAmount != "" {
+		exact, err := ParseMoney(payload.ExactAmount, DefaultCurrency)
+		if err != nil || !exact.IsPositive() {
+			return nil, errors.New("Invalid exact amount")
+		}
+
+		if tx.MaxAmount.IsPositive() && exact.GreaterThan(tx.MaxAmount) {
+			return nil, errors.New("Exact amount cannot be more than the max amount")
+		}
+		tx.ExactAmount = exact
+	}
+
+	if strings.TrimSpace(payload.Payee) != "" {
+		payee := FindAccount(payload.Payee)
+		if payee == nil {
+			return nil, errors.New(fmt.Sprintf("No account found for %s", payload.Payee))
+		}
+
+		if payee.ID == user {
+			return nil, errors.New("You cannot pay yourself")
+		}
+		tx.AllowedPayee = payee.ID
+	}
+
+	tx.Memo = strings.TrimSpace(payload.Memo)
+	if len(tx.Memo) > maxMemoLength {
+		return nil, errors.New(fmt.Sprintf("Memo should not be longer than %d characters", maxMemoLength))
+	}
+
+	if payload.AutoAuthorize {
+		if !tx.ExactAmount.IsPositive() {
+			return nil, errors.New("Only a token with an exact amount can be paid without authorization")
+		}
+
+		//Otherwise whoever gets hold of the code first is paid, with no one to stop it
+		if tx.AllowedPayee == 0 {
+			return nil, errors.New("Only a token for a named payee can be paid without authorization")
+		}
+
+		//Opting in is the authorization, so it needs the pin
+		err := VerifyPin(user, payload.Pin)
+		if err != nil {
+			return nil, err
+		}
+		tx.AutoAuthorize = true
+	}
+
+	token := findToken()
+	tx.Kind = TokenKindPayment
 	tx.UserId = user
 	tx.Token = token
+	tx.Amount = Kobo(0)
+	tx.Status = TokenCreated
+	now := time.Now()
+	tx.StatusChangedAt = &now
 
 	err := Db.Create(tx).Error
 	if err != nil {
 		return nil, errors.New("Cannot create token at this time. Please retry")
 	}
 
+	tx.ExpiresAt = tx.expiry()
 	return tx, nil
 }
 
@@ -87,47 +182,119 @@ func GetTxToken(token string) *TxToken {
 
 	tx.Recv = GetAccount(tx.RecvBy)
 	tx.User = GetAccount(tx.UserId)
+	tx.ExpiresAt = tx.expiry()
 	return tx
 }
 
-func RedeemToken(user uint, tk string, amount float64) (error) {
+func RedeemToken(user uint, tk string, amount Money) (error) {
+
+	if !amount.IsPositive() {
+		return errors.New("Amount should be > 0")
+	}
 
 	token := GetTxToken(tk)
 	if token == nil {
 		return errors.New(fmt.Sprintf("Token %s not found", tk))
 	}
 
+	if token.UserId == user {
+		return errors.New("You cannot pay yourself")
+	}
+
+	err := token.checkClaim(user, amount)
+	if err != nil {
+		return err
+	}
+
 	wallet := GetWallet(token.UserId)
 	if wallet == nil {
 		return errors.New("Wallet not found for user")
 	}
 
-	if wallet.Balance < amount {
+	if !wallet.Balance.SameCurrency(amount) {
+		return errors.New(fmt.Sprintf("Payment should be in %s", wallet.Balance.Currency))
+	}
+
+	fee := feeFor(FeeTransfer, token.UserId, amount)
+	if wallet.Available.LessThan(amount.Add(fee)) {
 		return errors.New(fmt.Sprintf("The wallet balance on %s account is insufficient to complete this transaction", token.User.Fullname))
 	}
 
-	token.Amount = amount
 	tx := Db.Begin()
-	err := tx.Error
+	err = tx.Error
 	if err != nil {
 		return err
 	}
 
-	err = tx.Table("tx_tokens").Where("token = ?", tk).UpdateColumn("amount", amount).Error
+	//Claiming is only legal from created, so a token another payee has claimed cannot be taken over
+	locked, err := lockToken(tx, tk)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	err = tx.Table("tx_tokens").Where("token = ?", tk).UpdateColumn("recv_by", user).Error
+	expired, err := checkTokenExpiry(tx, locked)
+	if err == ErrTokenExpired {
+		commitExpiry(tx, locked, expired)
+		return err
+	}
+
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	tx.Commit()
+	err = transitionToken(tx, locked, TokenClaimed, user, "", map[string] interface{} {
+		"amount_kobo" : amount.Kobo, "amount_currency" : amount.Currency, "recv_by" : user,
+		"fee_kobo" : fee.Kobo, "fee_currency" : fee.Currency})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Set the amount aside until the payer decides, so their other claims cannot spend it
+	wallet, err = lockWallet(tx, locked.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = placeHold(tx, wallet, amount.Add(fee), holdForToken(locked))
+	if err == ErrInsufficientFunds {
+		tx.Rollback()
+		return errors.New(fmt.Sprintf("The wallet balance on %s account is insufficient to complete this transaction", token.User.Fullname))
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	wsMessage := &WsMessage{}
+	wsMessage.Event = WsTokenClaimed
+	//Older tokens without a named payee wait for the payer like any other
+	autoAuthorize := locked.AutoAuthorize && locked.AllowedPayee > 0
+	if autoAuthorize {
+		locked.Amount = amount
+		locked.Fee = fee
+		locked.RecvBy = user
+		err = settleToken(tx, locked, locked.UserId, "Exact amount authorized when the token was created")
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		wsMessage.Event = WsTokenAuthorized
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+
+	if autoAuthorize {
+		go TriggerAutoTopUp(locked.UserId)
+	}
+
 	wsMessage.Account = GetAccount(user)
 	wsMessage.Amount = amount
 	wsMessage.Token = tk
@@ -136,6 +303,28 @@ func RedeemToken(user uint, tk string, amount float64) (error) {
 	return nil
 }
 
+//Check a claim against the limits the payer put on the token
+func (token *TxToken) checkClaim(user uint, amount Money) error {
+
+	if token.AllowedPayee > 0 && token.AllowedPayee != user {
+		return errors.New(fmt.Sprintf("Token %s is meant for someone else", token.Token))
+	}
+
+	if token.ExactAmount.IsPositive() {
+		if !token.ExactAmount.SameCurrency(amount) || token.ExactAmount.Cmp(amount) != 0 {
+			return errors.New(fmt.Sprintf("This token can only pay exactly %s", token.ExactAmount))
+		}
+	}
+
+	if token.MaxAmount.IsPositive() {
+		if !token.MaxAmount.SameCurrency(amount) || amount.GreaterThan(token.MaxAmount) {
+			return errors.New(fmt.Sprintf("This token can pay at most %s", token.MaxAmount))
+		}
+	}
+
+	return nil
+}
+
 func GetTxTokenById(id uint) *TxToken {
 
 	tx := &TxToken{}
@@ -150,30 +339,42 @@ func GetTxTokenById(id uint) *TxToken {
 
 	tx.Recv = GetAccount(tx.RecvBy)
 	tx.User = GetAccount(tx.UserId)
+	tx.ExpiresAt = tx.expiry()
 	return tx
 }
 
 func GetTransactionHistory(user uint) []*TxToken {
 
 	data := make([]*TxToken, 0)
-	err := Db.Table("tx_tokens").Where("user_id = ? AND status = ? AND amount > ?", user, 1, 0).Or("recv_by = ? AND status = ? AND amount > ?", user, 1, 0).Find(&data).Error
+	states := []TokenStatus {TokenAuthorized, TokenReversed}
+	err := Db.Table("tx_tokens").Where("user_id = ? AND state IN (?) AND amount_kobo > ?", user, states, 0).Or("recv_by = ? AND state IN (?) AND amount_kobo > ?", user, states, 0).Order("status_changed_at desc").Find(&data).Error
 	if err != nil {
 		return nil
 	}
 
 	resp := make([]*TxToken, 0)
 	for _, n := range data {
-		resp = append(resp, GetTxToken(n.Token))
+		token := GetTxToken(n.Token)
+		if token == nil {
+			continue
+		}
+
+		if token.Refunded.IsPositive() {
+			token.Refunds = GetRefundsFor(token.ID)
+		}
+		resp = append(resp, token)
 	}
 
 	return resp
 }
 
+var ErrTxRefUsed = errors.New("Attempt to reuse an already used transaction reference")
+
 type TxRef struct {
 	gorm.Model
 	UserId uint `json:"user_id"`
-	PsTxRef string `json:"ps_tx_ref"` //PayStack Transaction reference
-	Amount float32 `json:"amount"`
+	PsTxRef string `json:"ps_tx_ref" gorm:"unique_index"` //PayStack Transaction reference
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
 }
 
 func CreateTxRef(ref *TxRef) error {
@@ -193,4 +394,118 @@ func GetTxRef(ref string) *TxRef {
 	}
 
 	return tx
-}
\ No newline at end of file
+}
+
+//What a payer sends to decline or cancel a token
+type CloseTokenPayload struct {
+	Token string `json:"token"`
+	Reason string `json:"reason"`
+}
+
+const maxCloseReasonLength = 140
+
+//The payer turns down a payee's claim on their token
+func DeclineToken(user uint, payload *CloseTokenPayload) (*TxToken, error) {
+	return closeToken(user, payload, TokenDeclined)
+}
+
+//The payer withdraws their token, claimed or not
+func CancelToken(user uint, payload *CloseTokenPayload) (*TxToken, error) {
+	return closeToken(user, payload, TokenCancelled)
+}
+
+func closeToken(user uint, payload *CloseTokenPayload, to TokenStatus) (*TxToken, error) {
+
+	reason := strings.TrimSpace(payload.Reason)
+	if len(reason) > maxCloseReasonLength {
+		return nil, errors.New(fmt.Sprintf("Reason should not be longer than %d characters", maxCloseReasonLength))
+	}
+
+	token := GetTxToken(payload.Token)
+	if token == nil {
+		return nil, errors.New(fmt.Sprintf("Token %s not found", payload.Token))
+	}
+
+	if user != token.UserId {
+		return nil, errors.New("unAuthorized")
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := lockToken(tx, token.Token)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	expired, err := checkTokenExpiry(tx, locked)
+	if err == ErrTokenExpired {
+		commitExpiry(tx, locked, expired)
+		return nil, err
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = transitionToken(tx, locked, to, user, reason, nil)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	if locked.RecvBy > 0 {
+		notifyTokenClosed(locked, reason)
+		notifySplitProgress(locked)
+	}
+
+	return GetTxToken(locked.Token), nil
+}
+
+//Let the payee who claimed a token know the payer will not be paying it
+func notifyTokenClosed(token *TxToken, reason string) {
+
+	payer := GetAccount(token.UserId)
+	payee := GetAccount(token.RecvBy)
+	if payer == nil || payee == nil {
+		return
+	}
+
+	wsMessage := &WsMessage{}
+	wsMessage.Event = WsTokenDeclined
+	if token.Status == TokenCancelled {
+		wsMessage.Event = WsTokenCancelled
+	}
+	wsMessage.Account = payer
+	wsMessage.Amount = token.Amount
+	wsMessage.Token = token.Token
+	wsMessage.Reason = reason
+
+	SendWsMessageTo(payee.ID, wsMessage)
+
+	mail := &MailRequest{}
+	mail.Subject = fmt.Sprintf("LitePay - Payment %s", token.Status)
+	mail.Body = fmt.Sprintf("%s has %s your request for %s on token %s.", payer.Fullname, token.Status, token.Amount, token.Token)
+	if reason != "" {
+		mail.Body += fmt.Sprintf(" Reason: %s", reason)
+	}
+	mail.To = payee.Email
+	mail.Name = payee.Fullname
+
+	MailQueue <- mail
+}
+
+//Every token made before transfers existed was a payment
+func MigrateTokenKinds() error {
+	return Db.Exec("UPDATE tx_tokens SET kind = ? WHERE kind IS NULL OR kind = ''", TokenKindPayment).Error
+}
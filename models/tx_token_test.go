@@ -0,0 +1,178 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckClaim(t *testing.T) {
+
+	cases := []struct {
+		name string
+		token *TxToken
+		user uint
+		amount Money
+		valid bool
+	}{
+		{"no limits", &TxToken{}, 7, naira(1000000), true},
+		{"named payee", &TxToken{AllowedPayee: 7}, 7, naira(10), true},
+		{"someone else", &TxToken{AllowedPayee: 7}, 8, naira(10), false},
+		{"exact", &TxToken{ExactAmount: naira(50)}, 7, naira(50), true},
+		{"less than exact", &TxToken{ExactAmount: naira(50)}, 7, Kobo(4999), false},
+		{"more than exact", &TxToken{ExactAmount: naira(50)}, 7, Kobo(5001), false},
+		{"exact in another currency", &TxToken{ExactAmount: naira(50)}, 7, NewMoney(5000, "USD"), false},
+		{"under the max", &TxToken{MaxAmount: naira(50)}, 7, naira(20), true},
+		{"at the max", &TxToken{MaxAmount: naira(50)}, 7, naira(50), true},
+		{"over the max", &TxToken{MaxAmount: naira(50)}, 7, Kobo(5001), false},
+		{"max in another currency", &TxToken{MaxAmount: naira(50)}, 7, NewMoney(100, "USD"), false},
+		{"every limit met", &TxToken{AllowedPayee: 7, ExactAmount: naira(50), MaxAmount: naira(60)}, 7, naira(50), true},
+		{"every limit but the payee", &TxToken{AllowedPayee: 7, ExactAmount: naira(50), MaxAmount: naira(60)}, 9, naira(50), false},
+	}
+
+	for _, c := range cases {
+		if err := c.token.checkClaim(c.user, c.amount); (err == nil) != c.valid {
+			t.Errorf("%s: checkClaim returned %v, want valid %v", c.name, err, c.valid)
+		}
+	}
+}
+
+func TestCreateTokenValidation(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, 0)
+	payee := newTestAccount(t, 0)
+
+	cases := []struct {
+		name string
+		payload *CreateTokenPayload
+	}{
+		{"zero max", &CreateTokenPayload{MaxAmount: "0"}},
+		{"negative exact", &CreateTokenPayload{ExactAmount: "-5"}},
+		{"exact over the max", &CreateTokenPayload{MaxAmount: "10", ExactAmount: "10.01"}},
+		{"unknown payee", &CreateTokenPayload{Payee: "nobody-" + GenUniqueKey() + "@example.com"}},
+		{"yourself", &CreateTokenPayload{Payee: fmt.Sprint(payer.ID)}},
+		{"auto without an exact amount", &CreateTokenPayload{Payee: payee.Email, AutoAuthorize: true, Pin: testPin}},
+		{"auto without a payee", &CreateTokenPayload{ExactAmount: "10", AutoAuthorize: true, Pin: testPin}},
+		{"auto with the wrong pin", &CreateTokenPayload{Payee: payee.Email, ExactAmount: "10", AutoAuthorize: true, Pin: "0000"}},
+	}
+
+	for _, c := range cases {
+		if token, err := CreateToken(payer.ID, c.payload); err == nil {
+			t.Errorf("%s: created token %s", c.name, token.Token)
+		}
+	}
+}
+
+//A token for a named payee and exact amount, opted in with the pin, is paid as soon as it is claimed
+func TestAutoAuthorizedToken(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, naira(1000).Kobo)
+	payee := newTestAccount(t, 0)
+
+	token, err := CreateToken(payer.ID, &CreateTokenPayload{Payee: payee.Email, ExactAmount: "120",
+		AutoAuthorize: true, Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = RedeemToken(payee.ID, token.Token, naira(120))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := GetTxToken(token.Token).Status; status != TokenAuthorized {
+		t.Errorf("auto authorized token is %s after the claim", status)
+	}
+
+	if balance := balanceOf(t, payee.ID); balance != naira(120).Kobo {
+		t.Errorf("payee holds %d, want %d", balance, naira(120).Kobo)
+	}
+
+	requireReconciled(t, payer.ID, payee.ID)
+}
+
+func heldBy(t *testing.T, user uint) int64 {
+	t.Helper()
+	return walletOf(t, user).Held.Kobo
+}
+
+//Declining a claim gives the payer their money back, and a closed token cannot be paid
+func TestPayerDeclinesClaim(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, naira(1000).Kobo)
+	payee := newTestAccount(t, 0)
+
+	token := claimToken(t, payer, payee, naira(300).Kobo)
+	if held := heldBy(t, payer.ID); held != naira(300).Kobo + token.Fee.Kobo {
+		t.Fatalf("claim holds %d, want %d", held, naira(300).Kobo + token.Fee.Kobo)
+	}
+
+	_, err := DeclineToken(payee.ID, &CloseTokenPayload{Token: token.Token})
+	if err == nil {
+		t.Error("the payee declined their own claim")
+	}
+
+	declined, err := DeclineToken(payer.ID, &CloseTokenPayload{Token: token.Token, Reason: "Wrong amount"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if declined.Status != TokenDeclined {
+		t.Errorf("declined token is %s", declined.Status)
+	}
+
+	if held := heldBy(t, payer.ID); held != 0 {
+		t.Errorf("payer still has %d held after declining", held)
+	}
+
+	err = AuthorizePayment(payer.ID, &AuthorizePaymentPayload{Token: token.Token, Pin: testPin})
+	if err == nil {
+		t.Error("a declined token was paid")
+	}
+
+	_, err = CancelToken(payer.ID, &CloseTokenPayload{Token: token.Token})
+	if _, ok := err.(*TransitionError); !ok {
+		t.Errorf("cancelling a declined token returned %v, want a TransitionError", err)
+	}
+
+	if balance := balanceOf(t, payer.ID); balance != naira(1000).Kobo {
+		t.Errorf("payer holds %d, want %d", balance, naira(1000).Kobo)
+	}
+
+	transitions := GetTokenTransitions(token.ID)
+	last := transitions[len(transitions) - 1]
+	if last.To != TokenDeclined || last.Actor != payer.ID || last.Reason != "Wrong amount" {
+		t.Errorf("last transition %+v", last)
+	}
+
+	requireReconciled(t, payer.ID, payee.ID)
+}
+
+//A token can be withdrawn before anyone claims it, and then cannot be claimed
+func TestPayerCancelsUnclaimedToken(t *testing.T) {
+
+	requireDb(t)
+	payer := newTestAccount(t, naira(1000).Kobo)
+	payee := newTestAccount(t, 0)
+
+	token, err := CreateToken(payer.ID, &CreateTokenPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, err := CancelToken(payer.ID, &CloseTokenPayload{Token: token.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cancelled.Status != TokenCancelled {
+		t.Errorf("cancelled token is %s", cancelled.Status)
+	}
+
+	err = RedeemToken(payee.ID, token.Token, naira(100))
+	if err == nil {
+		t.Error("a cancelled token was claimed")
+	}
+}
@@ -1,18 +1,31 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"sort"
+)
 
+var ErrInsufficientFunds = errors.New("Insufficient funds")
+
+//Balance is the ledger balance, everything the wallet owns. Held is the part of it set aside for
+//claimed tokens (see holds.go), and Available what is left to spend
 type Wallet struct {
 	gorm.Model
 	UserId uint `json:"user_id"`
-	Balance float64 `json:"balance"`
+	Balance Money `json:"balance" gorm:"embedded;embedded_prefix:balance_"`
+	Held Money `json:"held" gorm:"embedded;embedded_prefix:held_"`
+
+	Available Money `sql:"-" gorm:"-" json:"available_balance"`
+	Holds []*Hold `sql:"-" gorm:"-" json:"holds,omitempty"`
 }
 
 func NewWallet(user uint) *Wallet {
 	
 	wallet := &Wallet{}
 	wallet.UserId = user
-	wallet.Balance = 0
+	wallet.Balance = Kobo(0)
+	wallet.Held = Kobo(0)
 
 	return wallet
 }
@@ -25,5 +38,104 @@ func GetWallet(user uint) *Wallet {
 		return nil
 	}
 
+	if wallet.Held.Currency == "" {
+		wallet.Held = NewMoney(0, wallet.Balance.Currency)
+	}
+
+	wallet.Available = wallet.Balance.Sub(wallet.Held)
 	return wallet
 }
+
+//Lock the wallets of users with SELECT ... FOR UPDATE until tx ends. Rows are always
+//locked in ascending id order so two transactions touching the same wallets cannot deadlock
+func lockWallets(tx *gorm.DB, users ...uint) (map[uint] *Wallet, error) {
+
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	data := make([]*Wallet, 0)
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Table("wallets").
+		Where("user_id IN (?)", users).Order("id asc").Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := make(map[uint] *Wallet)
+	for _, w := range data {
+		wallets[w.UserId] = w
+	}
+
+	for _, user := range users {
+		if _, ok := wallets[user]; !ok {
+			return nil, errors.New("Wallet not found for user")
+		}
+	}
+
+	return wallets, nil
+}
+
+func lockWallet(tx *gorm.DB, user uint) (*Wallet, error) {
+
+	wallets, err := lockWallets(tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return wallets[user], nil
+}
+
+//Relative update, so a stale in-memory balance can never be written back
+func creditWallet(tx *gorm.DB, wallet *Wallet, amount Money) error {
+
+	if !wallet.Balance.SameCurrency(amount) {
+		return errors.New("Cannot credit a " + wallet.Balance.Currency + " wallet with " + amount.Currency)
+	}
+
+	err := tx.Exec("UPDATE wallets SET balance_kobo = balance_kobo + ?, updated_at = NOW() WHERE id = ?",
+		amount.Kobo, wallet.ID).Error
+	if err != nil {
+		return err
+	}
+
+	wallet.Balance = wallet.Balance.Add(amount)
+	return nil
+}
+
+//Conditional relative update. Fails with ErrInsufficientFunds instead of letting the balance go
+//negative or dipping into money held for claimed tokens
+func debitWallet(tx *gorm.DB, wallet *Wallet, amount Money) error {
+
+	if !wallet.Balance.SameCurrency(amount) {
+		return errors.New("Cannot debit a " + wallet.Balance.Currency + " wallet with " + amount.Currency)
+	}
+
+	r := tx.Exec("UPDATE wallets SET balance_kobo = balance_kobo - ?, updated_at = NOW() WHERE id = ? AND balance_kobo - held_kobo >= ?",
+		amount.Kobo, wallet.ID, amount.Kobo)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected != 1 {
+		return ErrInsufficientFunds
+	}
+
+	wallet.Balance = wallet.Balance.Sub(amount)
+	return nil
+}
+
+//Debit wallet even past what it holds. Only for money that has already left or that a hold promised, like
+//a forced reversal, a withdrawal the bank has paid out or a captured claim. The wallet is left overdrawn,
+//which blocks any debit until money coming in has covered the shortfall
+func overdrawWallet(tx *gorm.DB, wallet *Wallet, amount Money) error {
+
+	if !wallet.Balance.SameCurrency(amount) {
+		return errors.New("Cannot debit a " + wallet.Balance.Currency + " wallet with " + amount.Currency)
+	}
+
+	err := tx.Exec("UPDATE wallets SET balance_kobo = balance_kobo - ?, updated_at = NOW() WHERE id = ?",
+		amount.Kobo, wallet.ID).Error
+	if err != nil {
+		return err
+	}
+
+	wallet.Balance = wallet.Balance.Sub(amount)
+	return nil
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+//Payments, transfers and top ups racing on the same wallets must neither create nor lose money
+func TestConcurrentWalletMovementsConserveMoney(t *testing.T) {
+
+	requireDb(t)
+
+	a := newTestAccount(t, 500000)
+	b := newTestAccount(t, 500000)
+	c := newTestAccount(t, 20000) //runs dry, so some of its transfers fail
+	users := []uint {a.ID, b.ID, c.ID}
+
+	mismatches, err := ReconcileWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fees, err := GetSystemAccount(Db, FeesAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feesBefore, err := LedgerBalance(Db, fees)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := int64(0)
+	for _, user := range users {
+		before += balanceOf(t, user)
+	}
+
+	//Tokens from a to b, claimed up front so that only the authorizations race
+	tokens := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		token, err := CreateToken(a.ID, &CreateTokenPayload{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = RedeemToken(b.ID, token.Token, Kobo(1500))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token.Token)
+	}
+
+	var funded, authorized int64
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	for _, token := range tokens {
+		token := token
+		//Each token is authorized twice at once. Only one of the two may move money
+		for i := 0; i < 2; i++ {
+			run(func() {
+				if AuthorizePayment(a.ID, &AuthorizePaymentPayload{Token: token, Pin: testPin}) == nil {
+					atomic.AddInt64(&authorized, 1)
+				}
+			})
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		run(func() {
+			SendTransfer(b.ID, &TransferPayload{Recipient: a.Email, Amount: "25", Pin: testPin})
+		})
+		run(func() {
+			SendTransfer(c.ID, &TransferPayload{Recipient: b.Email, Amount: "50", Pin: testPin})
+		})
+		run(func() {
+			SendTransfer(a.ID, &TransferPayload{Recipient: c.Email, Amount: "10", Pin: testPin})
+		})
+
+		//The same reference is funded twice at once. Only one of the two may credit the wallet
+		ref := "TEST-" + GenUniqueKey()
+		for j := 0; j < 2; j++ {
+			run(func() {
+				if FundAccount(ref, c, Kobo(700), Kobo(0)) == nil {
+					atomic.AddInt64(&funded, 700)
+				}
+			})
+		}
+	}
+
+	wg.Wait()
+
+	if authorized != int64(len(tokens)) {
+		t.Errorf("%d authorizations succeeded for %d tokens", authorized, len(tokens))
+	}
+
+	if funded != 10 * 700 {
+		t.Errorf("%d kobo was funded, expected %d", funded, 10 * 700)
+	}
+
+	feesAfter, err := LedgerBalance(Db, fees)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := int64(0)
+	for _, user := range users {
+		balance := balanceOf(t, user)
+		if balance < 0 {
+			t.Errorf("wallet of user %d went negative: %d", user, balance)
+		}
+		after += balance
+	}
+
+	charged := feesAfter.Kobo - feesBefore.Kobo
+	if after + charged != before + funded {
+		t.Errorf("wallets held %d and now hold %d with %d funded and %d charged in fees", before, after, funded, charged)
+	}
+
+	requireReconciled(t, users...)
+
+	now, err := ReconcileWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(now) != len(mismatches) {
+		t.Errorf("%d wallets disagreed with the ledger before the test and %d after", len(mismatches), len(now))
+	}
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"litepay/payments"
+	"os"
+	"fmt"
+)
+
+//A webhook delivered by paystack. Deliveries are retried, so every event is stored and applied only once
+type WebhookEvent struct {
+	gorm.Model
+	Provider string `json:"provider" gorm:"unique_index:idx_webhook_event"`
+	Event string `json:"event" gorm:"unique_index:idx_webhook_event"`
+	Reference string `json:"reference" gorm:"unique_index:idx_webhook_event"`
+	Payload string `json:"payload" gorm:"type:text"`
+	Processed bool `json:"processed"`
+	Error string `json:"error"`
+}
+
+type PaystackEvent struct {
+	Event string `json:"event"`
+	Data json.RawMessage `json:"data"`
+}
+
+//The parts of a charge.success payload we act on
+type paystackChargeData struct {
+	Reference string `json:"reference"`
+	Status string `json:"status"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+	Authorization payments.Authorization `json:"authorization"`
+}
+
+//The parts of a transfer.* payload we act on
+type paystackTransferData struct {
+	Reference string `json:"reference"`
+	TransferCode string `json:"transfer_code"`
+	Status string `json:"status"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+//Check the x-paystack-signature header, a hex HMAC-SHA512 of the raw body keyed with our secret key.
+//Anyone can sign with an empty key, so nothing verifies until the key is set
+func VerifyPaystackSignature(body []byte, signature string) bool {
+
+	key := os.Getenv("PS_KEY")
+	if key == "" {
+		return false
+	}
+
+	mac := hmac.New(sha512.New, []byte(key))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+//Apply a verified paystack webhook. Events seen before are acknowledged without being applied again
+func HandlePaystackWebhook(body []byte) error {
+
+	event := &PaystackEvent{}
+	err := json.Unmarshal(body, event)
+	if err != nil {
+		return errors.New("Malformed webhook payload")
+	}
+
+	ref := &struct {
+		Reference string `json:"reference"`
+	}{}
+	json.Unmarshal(event.Data, ref)
+
+	record := &WebhookEvent{}
+	err = Db.Table("webhook_events").Where("provider = ? AND event = ? AND reference = ?",
+		"paystack", event.Event, ref.Reference).First(record).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if record.ID > 0 && record.Processed {
+		return nil
+	}
+
+	if record.ID <= 0 {
+		record.Provider = "paystack"
+		record.Event = event.Event
+		record.Reference = ref.Reference
+		record.Payload = string(body)
+		err = Db.Create(record).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	switch event.Event {
+	case "charge.success":
+		err = handleChargeSuccess(event.Data)
+	case "transfer.success", "transfer.failed", "transfer.reversed":
+		err = handleTransferEvent(event.Event, event.Data)
+	default:
+		fmt.Printf("Ignoring paystack webhook event %s\n", event.Event)
+	}
+
+	if err != nil {
+		Db.Table("webhook_events").Where("id = ?", record.ID).UpdateColumn("error", err.Error())
+		return err
+	}
+
+	return Db.Table("webhook_events").Where("id = ?", record.ID).UpdateColumns(map[string] interface{} {
+		"processed" : true, "error" : ""}).Error
+}
+
+func handleChargeSuccess(data json.RawMessage) error {
+
+	charge := &paystackChargeData{}
+	err := json.Unmarshal(data, charge)
+	if err != nil {
+		return err
+	}
+
+	intent := GetTopUpIntent(charge.Reference)
+	if intent == nil {
+		amount := NewMoney(charge.Amount, charge.Currency)
+		fmt.Printf("charge.success for unknown reference %s. Amount %s\n", charge.Reference, amount)
+		return PostUnmatchedReceipt(charge.Reference, amount)
+	}
+
+	if intent.Status == IntentCompleted || intent.Status == IntentHeld {
+		return nil
+	}
+
+	//A top up given up on can still have been paid. Only the provider's own record can revive it
+	if intent.Status == IntentFailed {
+		txn, err := VerifyTransaction(charge.Reference)
+		if err != nil {
+			return err
+		}
+
+		if !txn.Successful() {
+			return nil
+		}
+
+		fmt.Printf("Top up %s was marked failed but paystack reports it paid\n", intent.Reference)
+		return completeChargedTopUp(intent, txn)
+	}
+
+	txn := &payments.Transaction{}
+	txn.Reference = charge.Reference
+	txn.Status = charge.Status
+	txn.Amount = charge.Amount
+	txn.Currency = charge.Currency
+	txn.Authorization = charge.Authorization
+
+	return completeChargedTopUp(intent, txn)
+}
+
+//A top up held past the account's limits is settled as far as the webhook goes
+func completeChargedTopUp(intent *TopUpIntent, txn *payments.Transaction) error {
+
+	err := CompleteTopUp(intent, txn)
+	if err == ErrTopUpHeld {
+		return nil
+	}
+
+	return err
+}
+
+func handleTransferEvent(event string, data json.RawMessage) error {
+
+	transfer := &paystackTransferData{}
+	err := json.Unmarshal(data, transfer)
+	if err != nil {
+		return err
+	}
+
+	if getWithdrawalByReference(transfer.Reference) == nil {
+		//Keep the event on record for reconciliation
+		fmt.Printf("%s for reference %s has no matching withdrawal\n", event, transfer.Reference)
+		return nil
+	}
+
+	status := transfer.Status
+	switch event {
+	case "transfer.success":
+		status = payments.StatusSuccess
+	case "transfer.failed":
+		status = payments.StatusFailed
+	case "transfer.reversed":
+		status = payments.StatusReversed
+	}
+
+	return SettleWithdrawal(transfer.Reference, status, "")
+}
+
+//Money that reached our paystack float but cannot be tied to a wallet is parked in suspense
+//until someone from finance works out who it belongs to
+func PostUnmatchedReceipt(ref string, amount Money) error {
+
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return err
+	}
+
+	float, err := GetSystemAccount(tx, PaystackFloatAccount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	suspense, err := GetSystemAccount(tx, SuspenseAccount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	entry := NewJournalEntry(EntryUnmatchedReceipt, ref, "Paystack payment with no matching top up")
+	entry.Debit(float, amount).Credit(suspense, amount)
+	err = PostJournalEntry(tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPaystackSignature(t *testing.T) {
+
+	body := `{"event":"charge.success","data":{"reference":"LP-TOPUP-1","status":"success","amount":50000,"currency":"NGN"}}`
+	//HMAC-SHA512 of body keyed with sk_test_webhook, worked out independently of this package
+	signature := "68478c0f47c5487177debe75dbf46926101f32cac8fc67dfc37e5c39dc2252da" +
+		"bcf44c0fbafaf070afdc98bc25eea6b5e80005f969a413c006d7cd43cf69d3df"
+
+	cases := []struct {
+		name string
+		key string
+		body string
+		signature string
+		valid bool
+	}{
+		{"genuine", "sk_test_webhook", body, signature, true},
+		{"amount changed", "sk_test_webhook", strings.Replace(body, "50000", "5000000", 1), signature, false},
+		{"reference changed", "sk_test_webhook", strings.Replace(body, "LP-TOPUP-1", "LP-TOPUP-2", 1), signature, false},
+		{"extra whitespace", "sk_test_webhook", body + "\n", signature, false},
+		{"another key", "sk_test_other", body, signature, false},
+		{"no signature", "sk_test_webhook", body, "", false},
+		{"truncated signature", "sk_test_webhook", body, signature[:64], false},
+		{"not hex", "sk_test_webhook", body, strings.Repeat("z", 128), false},
+		{"no key set", "", body, signAs("", body), false},
+	}
+
+	for _, c := range cases {
+		t.Setenv("PS_KEY", c.key)
+		if VerifyPaystackSignature([]byte(c.body), c.signature) != c.valid {
+			t.Errorf("%s: verified %v, want %v", c.name, !c.valid, c.valid)
+		}
+	}
+}
+
+func signAs(key, body string) string {
+
+	mac := hmac.New(sha512.New, []byte(key))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,401 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"litepay/payments"
+	"encoding/json"
+	"time"
+	"fmt"
+)
+
+const (
+	WithdrawalPending = "pending"
+	WithdrawalSuccess = "success"
+	WithdrawalFailed = "failed"
+	WithdrawalReversed = "reversed" //paid out, then returned by the bank
+)
+
+//A withdrawal the provider has no record of after this long never reached it
+const withdrawalGracePeriod = 30 * time.Minute
+
+//Money sent from a wallet to a bank beneficiary. The amount is held in the wallet while the
+//transfer is pending and only leaves it once the provider reports success
+type Withdrawal struct {
+	gorm.Model
+	UserId uint `json:"user_id" gorm:"index"`
+	BeneficiaryId uint `json:"beneficiary_id"`
+	Reference string `json:"reference" gorm:"unique_index"`
+	TransferCode string `json:"transfer_code"`
+	Amount Money `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Fee Money `json:"fee" gorm:"embedded;embedded_prefix:fee_"` //charged on top of Amount once it is paid out
+	Status string `json:"status" gorm:"index"`
+	FailureReason string `json:"failure_reason"`
+	CompletedAt *time.Time `json:"completed_at"`
+
+	Beneficiary *BankBeneficiary `sql:"-" gorm:"-" json:"beneficiary"`
+}
+
+type WithdrawalPayload struct {
+	BeneficiaryId uint `json:"beneficiary_id"`
+	Amount json.Number `json:"amount"`
+	Pin string `json:"pin"`
+}
+
+func CreateWithdrawal(user uint, payload *WithdrawalPayload) (*Withdrawal, error) {
+
+	amount, err := ParseMoney(payload.Amount, DefaultCurrency)
+	if err != nil || !amount.IsPositive() {
+		return nil, errors.New("Invalid amount")
+	}
+
+	beneficiary := GetBeneficiary(user, payload.BeneficiaryId)
+	if beneficiary == nil {
+		return nil, ErrBeneficiaryNotFound
+	}
+
+	err = VerifyPin(user, payload.Pin)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawal := &Withdrawal{}
+	withdrawal.UserId = user
+	withdrawal.BeneficiaryId = beneficiary.ID
+	withdrawal.Reference = "LP-WD-" + GenUniqueKey()
+	withdrawal.Amount = amount
+	withdrawal.Fee = feeFor(FeeWithdrawal, user, amount)
+	withdrawal.Status = WithdrawalPending
+
+	tx := Db.Begin()
+	err = tx.Error
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := lockWallet(tx, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = checkOutflowLimits(tx, user, wallet, amount)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Create(withdrawal).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("Cannot withdraw at this time. Please retry")
+	}
+
+	err = placeHold(tx, wallet, amount.Add(withdrawal.Fee), holdForWithdrawal(withdrawal))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	//The withdrawal is on record with its funds held before the provider hears of it. If the call
+	//fails we cannot tell whether the transfer went out, so it stays pending for the poller to settle
+	transfer, err := Provider.Transfer(&payments.TransferRequest{
+		Reference: withdrawal.Reference,
+		Recipient: beneficiary.RecipientCode,
+		Amount: amount.Kobo,
+		Currency: amount.Currency,
+		Reason: "LitePay withdrawal",
+	})
+	if err != nil {
+		fmt.Printf("Transfer for withdrawal %s failed. %s\n", withdrawal.Reference, err.Error())
+	} else {
+		Db.Table("withdrawals").Where("id = ?", withdrawal.ID).UpdateColumn("transfer_code", transfer.TransferCode)
+		err = SettleWithdrawal(withdrawal.Reference, transfer.Status, "")
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return GetWithdrawal(user, withdrawal.ID), nil
+}
+
+func GetWithdrawal(user, id uint) *Withdrawal {
+
+	withdrawal := &Withdrawal{}
+	err := Db.Table("withdrawals").Where("id = ? AND user_id = ?", id, user).First(withdrawal).Error
+	if err != nil {
+		return nil
+	}
+
+	withdrawal.Beneficiary = GetBeneficiary(user, withdrawal.BeneficiaryId)
+	return withdrawal
+}
+
+func GetWithdrawalsFor(user uint) []*Withdrawal {
+
+	data := make([]*Withdrawal, 0)
+	err := Db.Table("withdrawals").Where("user_id = ?", user).Order("id desc").Limit(100).Find(&data).Error
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func getWithdrawalByReference(ref string) *Withdrawal {
+
+	withdrawal := &Withdrawal{}
+	err := Db.Table("withdrawals").Where("reference = ?", ref).First(withdrawal).Error
+	if err != nil {
+		return nil
+	}
+
+	return withdrawal
+}
+
+//Move a withdrawal to the status the provider reports for its transfer. Safe to call repeatedly
+//with the same status, from webhooks and the poller alike
+func SettleWithdrawal(ref, status, reason string) error {
+
+	switch status {
+	case payments.StatusSuccess, payments.StatusFailed, payments.StatusReversed:
+	case payments.StatusAbandoned:
+		status = payments.StatusFailed
+	default:
+		//otp, queued, received and the like. Nothing final yet
+		return nil
+	}
+
+	tx := Db.Begin()
+	err := tx.Error
+	if err != nil {
+		return err
+	}
+
+	withdrawal := &Withdrawal{}
+	err = tx.Set("gorm:query_option", "FOR UPDATE").Table("withdrawals").Where("reference = ?", ref).First(withdrawal).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	from := withdrawal.Status
+	switch {
+	case from == WithdrawalPending && status == payments.StatusSuccess:
+		err = payOutWithdrawal(tx, withdrawal)
+	case from == WithdrawalPending:
+		//Failed, or reversed before we heard it succeed. Either way the money never left
+		err = closeWithdrawalHold(tx, withdrawal, HoldReleased)
+	case from == WithdrawalSuccess && status == payments.StatusReversed:
+		err = returnWithdrawal(tx, withdrawal)
+	case from == WithdrawalFailed && status == payments.StatusSuccess:
+		//We gave up on it and released the hold, but the bank paid it after all
+		fmt.Printf("Withdrawal %s was paid after it was marked failed. Please review wallet of user %d\n",
+			ref, withdrawal.UserId)
+		err = payOutFailedWithdrawal(tx, withdrawal)
+		if reason == "" {
+			reason = "It was paid after we reported it failed, so it has been taken from your wallet."
+		}
+	default:
+		tx.Rollback()
+		return nil
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+	err = tx.Table("withdrawals").Where("id = ?", withdrawal.ID).UpdateColumns(map[string] interface{} {
+		"status" : status, "failure_reason" : reason, "completed_at" : now}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+
+	withdrawal.Status = status
+	withdrawal.FailureReason = reason
+	notifyWithdrawal(withdrawal)
+	return nil
+}
+
+//The transfer went through. Capture the hold and take the money out of the wallet for good. The money
+//has left, so this cannot fail for want of funds, even if a forced reversal has left the wallet short of
+//what it held
+func payOutWithdrawal(tx *gorm.DB, withdrawal *Withdrawal) error {
+
+	wallet, err := lockWallet(tx, withdrawal.UserId)
+	if err != nil {
+		return err
+	}
+
+	err = closeWithdrawalHold(tx, withdrawal, HoldCaptured)
+	if err != nil {
+		return err
+	}
+
+	err = overdrawWallet(tx, wallet, withdrawal.Amount)
+	if err != nil {
+		return err
+	}
+
+	err = postWithdrawal(tx, wallet, withdrawal)
+	if err != nil {
+		return err
+	}
+
+	return chargeHeldFee(tx, wallet, withdrawal.Reference, "Withdrawal fee", withdrawal.Fee)
+}
+
+//The transfer went through after we had failed it. The hold is gone and the money may have been spent
+//since, so the wallet is overdrawn if need be. The fee is waived, the mistake was ours
+func payOutFailedWithdrawal(tx *gorm.DB, withdrawal *Withdrawal) error {
+
+	wallet, err := lockWallet(tx, withdrawal.UserId)
+	if err != nil {
+		return err
+	}
+
+	err = overdrawWallet(tx, wallet, withdrawal.Amount)
+	if err != nil {
+		return err
+	}
+
+	return postWithdrawal(tx, wallet, withdrawal)
+}
+
+func postWithdrawal(tx *gorm.DB, wallet *Wallet, withdrawal *Withdrawal) error {
+
+	float, err := GetSystemAccount(tx, PaystackFloatAccount)
+	if err != nil {
+		return err
+	}
+
+	account, err := GetWalletLedgerAccount(tx, wallet)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryWithdrawal, withdrawal.Reference, "Withdrawal to bank")
+	entry.Debit(account, withdrawal.Amount).Credit(float, withdrawal.Amount)
+	return PostJournalEntry(tx, entry)
+}
+
+//The bank sent a paid out withdrawal back. Put the money back in the wallet. The fee was for a
+//transfer that did happen, so it is kept
+func returnWithdrawal(tx *gorm.DB, withdrawal *Withdrawal) error {
+
+	wallet, err := lockWallet(tx, withdrawal.UserId)
+	if err != nil {
+		return err
+	}
+
+	err = creditWallet(tx, wallet, withdrawal.Amount)
+	if err != nil {
+		return err
+	}
+
+	float, err := GetSystemAccount(tx, PaystackFloatAccount)
+	if err != nil {
+		return err
+	}
+
+	account, err := GetWalletLedgerAccount(tx, wallet)
+	if err != nil {
+		return err
+	}
+
+	entry := NewJournalEntry(EntryWithdrawalReversal, withdrawal.Reference, "Withdrawal returned by bank")
+	entry.Debit(float, withdrawal.Amount).Credit(account, withdrawal.Amount)
+	return PostJournalEntry(tx, entry)
+}
+
+func notifyWithdrawal(withdrawal *Withdrawal) {
+
+	account := GetAccount(withdrawal.UserId)
+	if account == nil {
+		return
+	}
+
+	mail := &MailRequest{}
+	switch withdrawal.Status {
+	case WithdrawalSuccess:
+		mail.Subject = "LitePay - Withdrawal Successful"
+		mail.Body = fmt.Sprintf("Your withdrawal of %s (%s) has been paid to your bank account.", withdrawal.Amount, withdrawal.Reference)
+	case WithdrawalFailed:
+		mail.Subject = "LitePay - Withdrawal Failed"
+		mail.Body = fmt.Sprintf("Your withdrawal of %s (%s) could not be completed. The money is back in your wallet.", withdrawal.Amount, withdrawal.Reference)
+	case WithdrawalReversed:
+		mail.Subject = "LitePay - Withdrawal Reversed"
+		mail.Body = fmt.Sprintf("Your bank returned your withdrawal of %s (%s). The money is back in your wallet.", withdrawal.Amount, withdrawal.Reference)
+	default:
+		return
+	}
+
+	if withdrawal.FailureReason != "" {
+		mail.Body += " " + withdrawal.FailureReason
+	}
+	mail.To = account.Email
+	mail.Name = account.Fullname
+
+	MailQueue <- mail
+}
+
+//Ask the provider about withdrawals still pending, for when a webhook never arrives
+func PollPendingWithdrawals() error {
+
+	now := time.Now()
+	data := make([]*Withdrawal, 0)
+	err := Db.Table("withdrawals").Where("status = ? AND created_at <= ?", WithdrawalPending, now.Add(-time.Minute)).
+		Order("id asc").Limit(100).Find(&data).Error
+	if err != nil {
+		return err
+	}
+
+	for _, withdrawal := range data {
+
+		//Only a provider that has no record of the transfer tells us it was never sent. Any other
+		//error leaves it pending until we hear for sure
+		transfer, err := Provider.VerifyTransfer(withdrawal.Reference)
+		if err != nil {
+			if err == payments.ErrTransferNotFound && now.Sub(withdrawal.CreatedAt) > withdrawalGracePeriod {
+				err = SettleWithdrawal(withdrawal.Reference, payments.StatusFailed, "The transfer could not be sent.")
+			}
+
+			if err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+
+		err = SettleWithdrawal(withdrawal.Reference, transfer.Status, "")
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	return nil
+}
+
+func WithdrawalWorker() {
+
+	for {
+
+		time.Sleep(2 * time.Minute)
+		err := PollPendingWithdrawals()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
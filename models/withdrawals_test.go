@@ -0,0 +1,361 @@
+package models
+
+import (
+	"litepay/payments"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Swap in a fake payment provider for the length of the test
+func useFakeProvider(t *testing.T) *payments.FakeProvider {
+
+	t.Helper()
+	fake := payments.NewFakeProvider()
+	previous := Provider
+	Provider = fake
+	t.Cleanup(func() {
+		Provider = previous
+	})
+
+	return fake
+}
+
+//A fake provider that cannot be reached when asked about a transfer
+type unreachableTransfers struct {
+	*payments.FakeProvider
+}
+
+func (p *unreachableTransfers) VerifyTransfer(reference string) (*payments.Transfer, error) {
+	return nil, errors.New("dial tcp: i/o timeout")
+}
+
+func newTestBeneficiary(t *testing.T, fake *payments.FakeProvider, user uint) *BankBeneficiary {
+
+	t.Helper()
+	number := fmt.Sprintf("%010d", time.Now().UnixNano() % 10000000000)
+	fake.AddBankAccount(number, "058", "ADA OBI")
+
+	beneficiary, err := AddBeneficiary(user, &BeneficiaryPayload{BankCode: "058", AccountNumber: number})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return beneficiary
+}
+
+func newTestWithdrawal(t *testing.T, user, beneficiary uint, amount string) *Withdrawal {
+
+	t.Helper()
+	withdrawal, err := CreateWithdrawal(user, &WithdrawalPayload{BeneficiaryId: beneficiary, Amount: json.Number(amount), Pin: testPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return withdrawal
+}
+
+func withdrawalStatus(t *testing.T, ref string) string {
+
+	t.Helper()
+	withdrawal := getWithdrawalByReference(ref)
+	if withdrawal == nil {
+		t.Fatalf("withdrawal %s not found", ref)
+	}
+
+	return withdrawal.Status
+}
+
+func holdStatus(t *testing.T, withdrawal *Withdrawal) string {
+
+	t.Helper()
+	hold := &Hold{}
+	err := Db.Table("holds").Where("withdrawal_id = ?", withdrawal.ID).First(hold).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hold.Status
+}
+
+func transferEvent(event, ref string) error {
+
+	data, err := json.Marshal(&paystackTransferData{Reference: ref})
+	if err != nil {
+		return err
+	}
+
+	return handleTransferEvent(event, data)
+}
+
+func TestResolveAndAddBeneficiary(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 0)
+	fake.AddBankAccount("0123456789", "058", "ADA OBI")
+
+	resolved, err := ResolveBankAccount("0123456789", "058")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved.AccountName != "ADA OBI" {
+		t.Errorf("resolved name %q, want ADA OBI", resolved.AccountName)
+	}
+
+	_, err = ResolveBankAccount("0123456780", "058")
+	if err == nil {
+		t.Error("an account the bank does not know resolved")
+	}
+
+	beneficiary, err := AddBeneficiary(account.ID, &BeneficiaryPayload{BankCode: "058", AccountNumber: "0123456789"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if beneficiary.AccountName != "ADA OBI" || beneficiary.RecipientCode == "" {
+		t.Errorf("beneficiary saved as %q with recipient %q", beneficiary.AccountName, beneficiary.RecipientCode)
+	}
+}
+
+func TestWithdrawalIsHeldUntilPaidOut(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 100000)
+	beneficiary := newTestBeneficiary(t, fake, account.ID)
+
+	withdrawal := newTestWithdrawal(t, account.ID, beneficiary.ID, "300")
+	if withdrawal.Status != WithdrawalPending {
+		t.Fatalf("withdrawal is %s, want pending", withdrawal.Status)
+	}
+
+	held := withdrawal.Amount.Add(withdrawal.Fee)
+	wallet := walletOf(t, account.ID)
+	if wallet.Balance.Kobo != 100000 || wallet.Held.Kobo != held.Kobo {
+		t.Fatalf("wallet holds %s with %s held, want 1000 with %s held", wallet.Balance, wallet.Held, held)
+	}
+
+	if fake.GetTransfer(withdrawal.Reference) == nil {
+		t.Fatal("the transfer was never sent")
+	}
+
+	fake.SettleTransfer(withdrawal.Reference, payments.StatusSuccess)
+	err := SettleWithdrawal(withdrawal.Reference, payments.StatusSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withdrawalStatus(t, withdrawal.Reference) != WithdrawalSuccess || holdStatus(t, withdrawal) != HoldCaptured {
+		t.Fatal("a paid out withdrawal should be successful with its hold captured")
+	}
+
+	wallet = walletOf(t, account.ID)
+	if wallet.Balance.Kobo != 100000 - held.Kobo || wallet.Held.Kobo != 0 {
+		t.Errorf("wallet holds %s with %s held after payout", wallet.Balance, wallet.Held)
+	}
+
+	requireReconciled(t, account.ID)
+}
+
+func TestFailedWithdrawalReleasesHold(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 100000)
+	beneficiary := newTestBeneficiary(t, fake, account.ID)
+
+	withdrawal := newTestWithdrawal(t, account.ID, beneficiary.ID, "300")
+	fake.SettleTransfer(withdrawal.Reference, payments.StatusFailed)
+	err := SettleWithdrawal(withdrawal.Reference, payments.StatusFailed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withdrawalStatus(t, withdrawal.Reference) != WithdrawalFailed || holdStatus(t, withdrawal) != HoldReleased {
+		t.Fatal("a failed withdrawal should be failed with its hold released")
+	}
+
+	wallet := walletOf(t, account.ID)
+	if wallet.Balance.Kobo != 100000 || wallet.Held.Kobo != 0 {
+		t.Errorf("wallet holds %s with %s held after failure, want 1000 with nothing held", wallet.Balance, wallet.Held)
+	}
+
+	requireReconciled(t, account.ID)
+}
+
+func TestReversedWithdrawalReturnsMoney(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 100000)
+	beneficiary := newTestBeneficiary(t, fake, account.ID)
+
+	withdrawal := newTestWithdrawal(t, account.ID, beneficiary.ID, "300")
+	err := SettleWithdrawal(withdrawal.Reference, payments.StatusSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SettleWithdrawal(withdrawal.Reference, payments.StatusReversed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withdrawalStatus(t, withdrawal.Reference) != WithdrawalReversed {
+		t.Fatal("withdrawal should be reversed")
+	}
+
+	//The fee is kept, the amount comes back
+	if balance := balanceOf(t, account.ID); balance != 100000 - withdrawal.Fee.Kobo {
+		t.Errorf("balance %d after reversal, want %d", balance, 100000 - withdrawal.Fee.Kobo)
+	}
+
+	requireReconciled(t, account.ID)
+}
+
+//The webhook, the poller and the same event delivered twice must pay a withdrawal out once
+func TestWithdrawalSettlesOnceFromWebhookAndPoller(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 100000)
+	beneficiary := newTestBeneficiary(t, fake, account.ID)
+
+	withdrawal := newTestWithdrawal(t, account.ID, beneficiary.ID, "300")
+	fake.SettleTransfer(withdrawal.Reference, payments.StatusSuccess)
+
+	//Old enough for the poller to pick it up
+	err := Db.Table("withdrawals").Where("id = ?", withdrawal.ID).
+		UpdateColumn("created_at", time.Now().Add(-5 * time.Minute)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := transferEvent("transfer.success", withdrawal.Reference); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := PollPendingWithdrawals(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = transferEvent("transfer.success", withdrawal.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withdrawalStatus(t, withdrawal.Reference) != WithdrawalSuccess {
+		t.Fatal("withdrawal should be successful")
+	}
+
+	held := withdrawal.Amount.Add(withdrawal.Fee)
+	if balance := balanceOf(t, account.ID); balance != 100000 - held.Kobo {
+		t.Errorf("balance %d, want %d. The withdrawal was paid out more than once", balance, 100000 - held.Kobo)
+	}
+
+	requireReconciled(t, account.ID)
+}
+
+//The poller gives up on a withdrawal only when the provider has no record of it
+func TestPollerFailsOnlyUnknownTransfers(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	account := newTestAccount(t, 100000)
+	beneficiary := newTestBeneficiary(t, fake, account.ID)
+
+	withdrawal := newTestWithdrawal(t, account.ID, beneficiary.ID, "300")
+	err := Db.Table("withdrawals").Where("id = ?", withdrawal.ID).
+		UpdateColumn("created_at", time.Now().Add(-withdrawalGracePeriod - time.Minute)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Provider = &unreachableTransfers{fake}
+	err = PollPendingWithdrawals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := withdrawalStatus(t, withdrawal.Reference); status != WithdrawalPending {
+		t.Fatalf("withdrawal is %s after the provider could not be reached, want pending", status)
+	}
+
+	//A provider that never got the transfer
+	Provider = payments.NewFakeProvider()
+	err = PollPendingWithdrawals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := withdrawalStatus(t, withdrawal.Reference); status != WithdrawalFailed {
+		t.Fatalf("withdrawal is %s after the provider had no record of it, want failed", status)
+	}
+
+	//It went out after all. The wallet pays for it even though the hold is gone
+	err = transferEvent("transfer.success", withdrawal.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := withdrawalStatus(t, withdrawal.Reference); status != WithdrawalSuccess {
+		t.Fatalf("withdrawal is %s after a late success, want success", status)
+	}
+
+	if balance := balanceOf(t, account.ID); balance != 100000 - withdrawal.Amount.Kobo {
+		t.Errorf("balance %d after a late success, want %d", balance, 100000 - withdrawal.Amount.Kobo)
+	}
+
+	requireReconciled(t, account.ID)
+}
+
+//A transfer the bank has paid is taken from the wallet even when a forced reversal has since left it
+//short of what it held
+func TestWithdrawalPaysOutOfShortWallet(t *testing.T) {
+
+	requireDb(t)
+	fake := useFakeProvider(t)
+	payer := newTestAccount(t, 100000)
+	account := newTestAccount(t, 0)
+	beneficiary := newTestBeneficiary(t, fake, account.ID)
+
+	token := payWithToken(t, payer, account, 50000)
+	withdrawal := newTestWithdrawal(t, account.ID, beneficiary.ID, "300")
+
+	_, err := ForceReversal(payer.ID, &RefundPayload{Token: token.Token, Reason: "Disputed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SettleWithdrawal(withdrawal.Reference, payments.StatusSuccess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withdrawalStatus(t, withdrawal.Reference) != WithdrawalSuccess || holdStatus(t, withdrawal) != HoldCaptured {
+		t.Fatal("a paid out withdrawal should be successful with its hold captured")
+	}
+
+	held := withdrawal.Amount.Add(withdrawal.Fee)
+	wallet := walletOf(t, account.ID)
+	if wallet.Balance.Kobo != -held.Kobo || wallet.Held.Kobo != 0 {
+		t.Errorf("wallet holds %s with %s held, want -%s with nothing held", wallet.Balance, wallet.Held, held)
+	}
+
+	requireReconciled(t, account.ID)
+}
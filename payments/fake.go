@@ -0,0 +1,312 @@
+package payments
+
+import (
+	"fmt"
+	"sync"
+)
+
+//In-memory PaymentProvider for tests and local development. Nothing leaves the process and the
+//outcome of every call is decided by the state set up through its helper methods, never by chance
+type FakeProvider struct {
+
+	//When set, a pending transaction succeeds the first time it is verified and
+	//transfers succeed as soon as they are sent, as if a user had completed them
+	AutoSucceed bool
+
+	mu sync.Mutex
+	transactions map[string] *Transaction
+	refunded map[string] int64
+	transfers map[string] *Transfer
+	declined map[string] bool
+	accounts map[string] string
+	recipients map[string] *RecipientRequest
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		transactions: make(map[string] *Transaction),
+		refunded: make(map[string] int64),
+		transfers: make(map[string] *Transfer),
+		declined: make(map[string] bool),
+		accounts: make(map[string] string),
+		recipients: make(map[string] *RecipientRequest),
+	}
+}
+
+func (f *FakeProvider) Name() string {
+	return "fake"
+}
+
+//The card authorization handed out for a reference. Fixed so that tests can predict it
+func FakeAuthorization(reference string) Authorization {
+	return Authorization{
+		AuthorizationCode: "AUTH_" + reference,
+		Bin: "408408",
+		Last4: "4081",
+		ExpMonth: "12",
+		ExpYear: "2030",
+		CardType: "visa",
+		Brand: "visa",
+		Bank: "Test Bank",
+		Signature: "SIG_fake",
+		Reusable: true,
+	}
+}
+
+func (f *FakeProvider) Initialize(req *InitializeRequest) (*InitializeResponse, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.transactions[req.Reference]; ok {
+		return nil, fmt.Errorf("duplicate transaction reference %s", req.Reference)
+	}
+
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %d", req.Amount)
+	}
+
+	f.transactions[req.Reference] = &Transaction{
+		Reference: req.Reference,
+		Status: StatusPending,
+		Amount: req.Amount,
+		Currency: req.Currency,
+	}
+
+	return &InitializeResponse{
+		Reference: req.Reference,
+		AccessCode: "ACCESS_" + req.Reference,
+		AuthorizationURL: "https://checkout.fake.local/" + req.Reference,
+	}, nil
+}
+
+func (f *FakeProvider) Verify(reference string) (*Transaction, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	txn, ok := f.transactions[reference]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not found", reference)
+	}
+
+	if txn.Status == StatusPending && f.AutoSucceed {
+		f.succeed(txn)
+	}
+
+	result := *txn
+	return &result, nil
+}
+
+func (f *FakeProvider) ChargeAuthorization(req *ChargeRequest) (*Transaction, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.transactions[req.Reference]; ok {
+		return nil, &RejectedError{Message: fmt.Sprintf("duplicate transaction reference %s", req.Reference)}
+	}
+
+	txn := &Transaction{
+		Reference: req.Reference,
+		Amount: req.Amount,
+		Currency: req.Currency,
+	}
+	f.transactions[req.Reference] = txn
+
+	if f.declined[req.AuthorizationCode] {
+		txn.Status = StatusFailed
+		txn.GatewayResponse = "Declined"
+	} else {
+		txn.Status = StatusSuccess
+		txn.GatewayResponse = "Approved"
+		txn.Authorization = FakeAuthorization(req.Reference)
+		txn.Authorization.AuthorizationCode = req.AuthorizationCode
+	}
+
+	result := *txn
+	return &result, nil
+}
+
+func (f *FakeProvider) Refund(req *RefundRequest) (*Refund, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	txn, ok := f.transactions[req.Transaction]
+	if !ok || txn.Status != StatusSuccess {
+		return nil, fmt.Errorf("transaction %s cannot be refunded", req.Transaction)
+	}
+
+	amount := req.Amount
+	if amount == 0 {
+		amount = txn.Amount - f.refunded[req.Transaction]
+	}
+
+	if amount <= 0 || f.refunded[req.Transaction] + amount > txn.Amount {
+		return nil, fmt.Errorf("refund of %d exceeds what is left of transaction %s", amount, req.Transaction)
+	}
+
+	f.refunded[req.Transaction] += amount
+	return &Refund{Transaction: req.Transaction, Status: StatusSuccess, Amount: amount, Currency: txn.Currency}, nil
+}
+
+func (f *FakeProvider) Transfer(req *TransferRequest) (*Transfer, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.transfers[req.Reference]; ok {
+		return nil, fmt.Errorf("duplicate transfer reference %s", req.Reference)
+	}
+
+	if _, ok := f.recipients[req.Recipient]; req.Amount <= 0 || !ok {
+		return nil, fmt.Errorf("invalid transfer request")
+	}
+
+	transfer := &Transfer{
+		Reference: req.Reference,
+		TransferCode: "TRF_" + req.Reference,
+		Status: StatusPending,
+		Amount: req.Amount,
+		Currency: req.Currency,
+	}
+
+	if f.AutoSucceed {
+		transfer.Status = StatusSuccess
+	}
+
+	f.transfers[req.Reference] = transfer
+	result := *transfer
+	return &result, nil
+}
+
+func (f *FakeProvider) succeed(txn *Transaction) {
+	txn.Status = StatusSuccess
+	txn.GatewayResponse = "Approved"
+	txn.Authorization = FakeAuthorization(txn.Reference)
+}
+
+//Mark a pending transaction as paid, as if the customer completed checkout
+func (f *FakeProvider) CompleteTransaction(reference string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if txn, ok := f.transactions[reference]; ok {
+		f.succeed(txn)
+	}
+}
+
+//Mark a pending transaction as failed
+func (f *FakeProvider) FailTransaction(reference string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if txn, ok := f.transactions[reference]; ok {
+		txn.Status = StatusFailed
+		txn.GatewayResponse = "Declined"
+	}
+}
+
+//Change what the provider will report for a transaction, e.g to simulate a tampered amount
+func (f *FakeProvider) SetTransaction(txn *Transaction) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := *txn
+	f.transactions[txn.Reference] = &result
+}
+
+//Make every future charge of an authorization code fail
+func (f *FakeProvider) DeclineAuthorization(code string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.declined[code] = true
+}
+
+//Move a sent transfer to its final status, as the bank would
+func (f *FakeProvider) SettleTransfer(reference, status string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if transfer, ok := f.transfers[reference]; ok {
+		transfer.Status = status
+	}
+}
+
+func (f *FakeProvider) GetTransfer(reference string) *Transfer {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transfer, ok := f.transfers[reference]
+	if !ok {
+		return nil
+	}
+
+	result := *transfer
+	return &result
+}
+
+func (f *FakeProvider) VerifyTransfer(reference string) (*Transfer, error) {
+
+	transfer := f.GetTransfer(reference)
+	if transfer == nil {
+		return nil, ErrTransferNotFound
+	}
+
+	return transfer, nil
+}
+
+func fakeAccountKey(accountNumber, bankCode string) string {
+	return bankCode + ":" + accountNumber
+}
+
+//Resolves accounts set up with AddBankAccount. With AutoSucceed, any other 10 digit account number
+//resolves to a test name so the whole flow can be tried locally
+func (f *FakeProvider) ResolveAccount(accountNumber, bankCode string) (*BankAccount, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name, ok := f.accounts[fakeAccountKey(accountNumber, bankCode)]
+	if !ok && f.AutoSucceed && len(accountNumber) == 10 && bankCode != "" {
+		name, ok = "TEST ACCOUNT " + accountNumber[6:], true
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("could not resolve account %s at bank %s", accountNumber, bankCode)
+	}
+
+	return &BankAccount{AccountNumber: accountNumber, AccountName: name, BankCode: bankCode}, nil
+}
+
+func (f *FakeProvider) CreateRecipient(req *RecipientRequest) (*Recipient, error) {
+
+	if _, err := f.ResolveAccount(req.AccountNumber, req.BankCode); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code := "RCP_" + fakeAccountKey(req.AccountNumber, req.BankCode)
+	result := *req
+	f.recipients[code] = &result
+	return &Recipient{RecipientCode: code, Name: req.Name}, nil
+}
+
+//Make a bank account resolvable to name
+func (f *FakeProvider) AddBankAccount(accountNumber, bankCode, name string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.accounts[fakeAccountKey(accountNumber, bankCode)] = name
+}
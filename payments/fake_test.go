@@ -0,0 +1,186 @@
+package payments
+
+import (
+	"testing"
+)
+
+var _ PaymentProvider = (*FakeProvider)(nil)
+var _ PaymentProvider = (*PaystackProvider)(nil)
+
+func TestFakeCheckout(t *testing.T) {
+
+	fake := NewFakeProvider()
+	_, err := fake.Initialize(&InitializeRequest{Reference: "LP-TOPUP-1", Amount: 50000, Currency: "NGN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fake.Initialize(&InitializeRequest{Reference: "LP-TOPUP-1", Amount: 50000, Currency: "NGN"})
+	if err == nil {
+		t.Error("a reference was initialized twice")
+	}
+
+	_, err = fake.Initialize(&InitializeRequest{Reference: "LP-TOPUP-2", Amount: 0, Currency: "NGN"})
+	if err == nil {
+		t.Error("a checkout for nothing was initialized")
+	}
+
+	txn, err := fake.Verify("LP-TOPUP-1")
+	if err != nil || txn.Status != StatusPending {
+		t.Fatalf("unpaid checkout verified as %+v with %v", txn, err)
+	}
+
+	fake.CompleteTransaction("LP-TOPUP-1")
+	txn, err = fake.Verify("LP-TOPUP-1")
+	if err != nil || !txn.Successful() || txn.Amount != 50000 || !txn.Authorization.Reusable {
+		t.Errorf("paid checkout verified as %+v with %v", txn, err)
+	}
+
+	_, err = fake.Verify("LP-TOPUP-3")
+	if err == nil {
+		t.Error("an unknown reference verified")
+	}
+}
+
+func TestFakeAutoSucceed(t *testing.T) {
+
+	fake := NewFakeProvider()
+	fake.AutoSucceed = true
+	fake.Initialize(&InitializeRequest{Reference: "LP-TOPUP-1", Amount: 50000, Currency: "NGN"})
+
+	txn, err := fake.Verify("LP-TOPUP-1")
+	if err != nil || !txn.Successful() {
+		t.Errorf("checkout verified as %+v with %v, want paid", txn, err)
+	}
+
+	fake.Initialize(&InitializeRequest{Reference: "LP-TOPUP-2", Amount: 50000, Currency: "NGN"})
+	fake.FailTransaction("LP-TOPUP-2")
+	if txn, _ := fake.Verify("LP-TOPUP-2"); txn.Status != StatusFailed {
+		t.Errorf("failed checkout verified as %s", txn.Status)
+	}
+}
+
+func TestFakeChargeAuthorization(t *testing.T) {
+
+	fake := NewFakeProvider()
+	txn, err := fake.ChargeAuthorization(&ChargeRequest{Reference: "LP-TOPUP-1", AuthorizationCode: "AUTH_1", Amount: 50000})
+	if err != nil || !txn.Successful() || txn.Authorization.AuthorizationCode != "AUTH_1" {
+		t.Errorf("charge %+v with %v", txn, err)
+	}
+
+	_, err = fake.ChargeAuthorization(&ChargeRequest{Reference: "LP-TOPUP-1", AuthorizationCode: "AUTH_1", Amount: 50000})
+	if !IsRejected(err) {
+		t.Errorf("charging a reference twice returned %v, want a rejection", err)
+	}
+
+	fake.DeclineAuthorization("AUTH_1")
+	txn, err = fake.ChargeAuthorization(&ChargeRequest{Reference: "LP-TOPUP-2", AuthorizationCode: "AUTH_1", Amount: 50000})
+	if err != nil || txn.Status != StatusFailed {
+		t.Errorf("charging a declined card gave %+v with %v", txn, err)
+	}
+}
+
+func TestFakeRefund(t *testing.T) {
+
+	fake := NewFakeProvider()
+	fake.ChargeAuthorization(&ChargeRequest{Reference: "LP-TOPUP-1", AuthorizationCode: "AUTH_1", Amount: 50000})
+
+	cases := []struct {
+		amount int64
+		refunded int64
+		ok bool
+	}{
+		{20000, 20000, true},
+		{40000, 0, false},
+		{0, 30000, true},
+		{1, 0, false},
+	}
+
+	for i, c := range cases {
+		refund, err := fake.Refund(&RefundRequest{Transaction: "LP-TOPUP-1", Amount: c.amount})
+		if (err == nil) != c.ok {
+			t.Errorf("refund %d of %d returned %v", i + 1, c.amount, err)
+			continue
+		}
+
+		if c.ok && refund.Amount != c.refunded {
+			t.Errorf("refund %d gave back %d, want %d", i + 1, refund.Amount, c.refunded)
+		}
+	}
+
+	_, err := fake.Refund(&RefundRequest{Transaction: "LP-TOPUP-2"})
+	if err == nil {
+		t.Error("an unknown transaction was refunded")
+	}
+}
+
+func TestFakeTransfer(t *testing.T) {
+
+	fake := NewFakeProvider()
+	_, err := fake.CreateRecipient(&RecipientRequest{Name: "Ada", AccountNumber: "0123456789", BankCode: "058"})
+	if err == nil {
+		t.Error("a recipient was created for an account the bank does not know")
+	}
+
+	fake.AddBankAccount("0123456789", "058", "ADA LOVELACE")
+	account, err := fake.ResolveAccount("0123456789", "058")
+	if err != nil || account.AccountName != "ADA LOVELACE" {
+		t.Fatalf("resolved %+v with %v", account, err)
+	}
+
+	recipient, err := fake.CreateRecipient(&RecipientRequest{Name: "Ada", AccountNumber: "0123456789", BankCode: "058"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fake.Transfer(&TransferRequest{Reference: "LP-WD-1", Recipient: "RCP_unknown", Amount: 30000})
+	if err == nil {
+		t.Error("a transfer went to an unregistered recipient")
+	}
+
+	transfer, err := fake.Transfer(&TransferRequest{Reference: "LP-WD-1", Recipient: recipient.RecipientCode, Amount: 30000})
+	if err != nil || transfer.Status != StatusPending {
+		t.Fatalf("transfer %+v with %v", transfer, err)
+	}
+
+	_, err = fake.Transfer(&TransferRequest{Reference: "LP-WD-1", Recipient: recipient.RecipientCode, Amount: 30000})
+	if err == nil {
+		t.Error("a reference was transferred twice")
+	}
+
+	fake.SettleTransfer("LP-WD-1", StatusSuccess)
+	transfer, err = fake.VerifyTransfer("LP-WD-1")
+	if err != nil || transfer.Status != StatusSuccess {
+		t.Errorf("settled transfer verified as %+v with %v", transfer, err)
+	}
+
+	_, err = fake.VerifyTransfer("LP-WD-2")
+	if err != ErrTransferNotFound {
+		t.Errorf("verifying an unsent transfer returned %v, want ErrTransferNotFound", err)
+	}
+}
+
+func TestNewProviderFromEnv(t *testing.T) {
+
+	cases := map[string] string {
+		"" : "paystack",
+		"paystack" : "paystack",
+		" Fake " : "fake",
+		"stripe" : "",
+	}
+
+	for env, name := range cases {
+		t.Setenv("PAYMENT_PROVIDER", env)
+		provider, err := NewProviderFromEnv()
+		if name == "" {
+			if err == nil {
+				t.Errorf("PAYMENT_PROVIDER=%q built %s", env, provider.Name())
+			}
+			continue
+		}
+
+		if err != nil || provider.Name() != name {
+			t.Errorf("PAYMENT_PROVIDER=%q built %v with %v, want %s", env, provider, err, name)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package payments
+
+import (
+	"github.com/mitchellh/mapstructure"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"io/ioutil"
+	"strings"
+	"bytes"
+	"time"
+	"fmt"
+	"io"
+)
+
+const paystackBaseURL = "https://api.paystack.co"
+
+//PaymentProvider backed by the paystack API. Requests are sent by hand rather than through paystack-go so
+//that amounts go over the wire as integers instead of float32, and so that paystack's reason for refusing
+//a request reaches us. paystack-go drops the body of error responses
+type PaystackProvider struct {
+	key string
+	baseURL string
+	client *http.Client
+}
+
+func NewPaystackProvider(key string) *PaystackProvider {
+	return newPaystackProvider(key, paystackBaseURL)
+}
+
+func newPaystackProvider(key, baseURL string) *PaystackProvider {
+	return &PaystackProvider{key: key, baseURL: baseURL, client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+//paystack answered, but not with success. StatusCode is the HTTP status, which is 200 for a false
+//status, and Message is paystack's own explanation
+type PaystackError struct {
+	StatusCode int
+	Message string
+}
+
+func (e *PaystackError) Error() string {
+	return fmt.Sprintf("paystack answered %d. %s", e.StatusCode, e.Message)
+}
+
+//Every paystack answer comes wrapped in this
+type paystackResponse struct {
+	Status bool `json:"status"`
+	Message string `json:"message"`
+	Data interface{} `json:"data"`
+}
+
+//Send a request to paystack and decode the data of its answer into v. Answers other than success come
+//back as a *PaystackError
+func (p *PaystackProvider) call(method, path string, body, v interface{}) error {
+
+	var buf io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		buf = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, p.baseURL + path, buf)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer " + p.key)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	//Gateways in front of paystack answer errors with html, so a body that is not json is not an error
+	//of its own when the status already is one
+	r := &paystackResponse{}
+	err = json.Unmarshal(data, r)
+	if resp.StatusCode >= 400 || (err == nil && !r.Status) {
+		message := r.Message
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		return &PaystackError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	//Weakly typed, as paystack sends some numbers as strings and the other way round
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{Result: v, TagName: "json", WeaklyTypedInput: true})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(r.Data)
+}
+
+//paystack answers requests it refuses with a 4xx or a false status. Server errors and failed
+//connections say nothing about whether the request took effect, so they are passed on as they are
+func rejected(err error) error {
+
+	psErr, ok := err.(*PaystackError)
+	if !ok || psErr.StatusCode >= 500 {
+		return err
+	}
+
+	return &RejectedError{Message: psErr.Message}
+}
+
+//paystack answers a lookup of a reference it has never seen with a 404, or a 400 saying so
+func transferNotFound(err error) bool {
+
+	psErr, ok := err.(*PaystackError)
+	if !ok {
+		return false
+	}
+
+	return psErr.StatusCode == 404 || (psErr.StatusCode == 400 &&
+		strings.Contains(strings.ToLower(psErr.Message), "not found"))
+}
+
+func (p *PaystackProvider) Name() string {
+	return "paystack"
+}
+
+func (p *PaystackProvider) Initialize(req *InitializeRequest) (*InitializeResponse, error) {
+
+	resp := &InitializeResponse{}
+	err := p.call("POST", "/transaction/initialize", req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.AccessCode == "" {
+		return nil, fmt.Errorf("paystack did not return an access code for %s", req.Reference)
+	}
+
+	return resp, nil
+}
+
+func (p *PaystackProvider) Verify(reference string) (*Transaction, error) {
+
+	txn := &Transaction{}
+	err := p.call("GET", "/transaction/verify/" + reference, nil, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	return txn, nil
+}
+
+func (p *PaystackProvider) ChargeAuthorization(req *ChargeRequest) (*Transaction, error) {
+
+	txn := &Transaction{}
+	err := p.call("POST", "/transaction/charge_authorization", req, txn)
+	if err != nil {
+		return nil, rejected(err)
+	}
+
+	return txn, nil
+}
+
+func (p *PaystackProvider) Refund(req *RefundRequest) (*Refund, error) {
+
+	resp := &struct {
+		Status string `json:"status"`
+		Amount int64 `json:"amount"`
+		Currency string `json:"currency"`
+	}{}
+	err := p.call("POST", "/refund", req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	//paystack reports processed refunds as 'processed'
+	status := resp.Status
+	if status == "processed" {
+		status = StatusSuccess
+	}
+
+	return &Refund{Transaction: req.Transaction, Status: status, Amount: resp.Amount, Currency: resp.Currency}, nil
+}
+
+func (p *PaystackProvider) Transfer(req *TransferRequest) (*Transfer, error) {
+
+	body := map[string] interface{} {
+		"source" : "balance",
+		"reference" : req.Reference,
+		"recipient" : req.Recipient,
+		"amount" : req.Amount,
+		"currency" : req.Currency,
+		"reason" : req.Reason,
+	}
+
+	transfer := &Transfer{}
+	err := p.call("POST", "/transfer", body, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+func (p *PaystackProvider) VerifyTransfer(reference string) (*Transfer, error) {
+
+	transfer := &Transfer{}
+	err := p.call("GET", "/transfer/verify/" + url.PathEscape(reference), nil, transfer)
+	if transferNotFound(err) {
+		return nil, ErrTransferNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+func (p *PaystackProvider) ResolveAccount(accountNumber, bankCode string) (*BankAccount, error) {
+
+	query := url.Values{}
+	query.Set("account_number", accountNumber)
+	query.Set("bank_code", bankCode)
+
+	account := &BankAccount{}
+	err := p.call("GET", "/bank/resolve?" + query.Encode(), nil, account)
+	if err != nil {
+		return nil, err
+	}
+
+	account.BankCode = bankCode
+	return account, nil
+}
+
+func (p *PaystackProvider) CreateRecipient(req *RecipientRequest) (*Recipient, error) {
+
+	body := map[string] interface{} {
+		"type" : "nuban",
+		"name" : req.Name,
+		"account_number" : req.AccountNumber,
+		"bank_code" : req.BankCode,
+		"currency" : req.Currency,
+	}
+
+	recipient := &Recipient{}
+	err := p.call("POST", "/transferrecipient", body, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipient.RecipientCode == "" {
+		return nil, fmt.Errorf("paystack did not return a recipient code for %s", req.AccountNumber)
+	}
+
+	return recipient, nil
+}
@@ -0,0 +1,130 @@
+package payments
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//A paystack stand in that answers every request with status and body
+func paystackServer(t *testing.T, status int, body string) (*PaystackProvider, *http.Request) {
+
+	t.Helper()
+	seen := &http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen = *r
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return newPaystackProvider("sk_test", server.URL), seen
+}
+
+func TestVerifyTransferNotFound(t *testing.T) {
+
+	cases := []struct {
+		name string
+		status int
+		body string
+		notFound bool
+	}{
+		{"400 saying so", 400, `{"status":false,"message":"Transfer not found"}`, true},
+		{"404", 404, `{"status":false,"message":"Not found"}`, true},
+		{"404 without a body", 404, ``, true},
+		{"400 for something else", 400, `{"status":false,"message":"Invalid key"}`, false},
+		{"server error", 500, `{"status":false,"message":"Transfer not found"}`, false},
+		{"gateway error page", 502, `<html>Bad Gateway</html>`, false},
+	}
+
+	for _, c := range cases {
+		provider, _ := paystackServer(t, c.status, c.body)
+		_, err := provider.VerifyTransfer("LP-WD-1")
+		if err == nil {
+			t.Errorf("%s: no error", c.name)
+			continue
+		}
+
+		if (err == ErrTransferNotFound) != c.notFound {
+			t.Errorf("%s: got %v, want not found %v", c.name, err, c.notFound)
+		}
+	}
+}
+
+func TestVerifyTransfer(t *testing.T) {
+
+	provider, req := paystackServer(t, 200, `{"status":true,"message":"Transfer retrieved",
+		"data":{"reference":"LP-WD-1","transfer_code":"TRF_1","status":"success","amount":"30000","currency":"NGN"}}`)
+
+	transfer, err := provider.VerifyTransfer("LP-WD-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transfer.Status != StatusSuccess || transfer.Amount != 30000 || transfer.TransferCode != "TRF_1" {
+		t.Errorf("decoded %+v", transfer)
+	}
+
+	if req.URL.Path != "/transfer/verify/LP-WD-1" || req.Header.Get("Authorization") != "Bearer sk_test" {
+		t.Errorf("sent %s %s with authorization %q", req.Method, req.URL.Path, req.Header.Get("Authorization"))
+	}
+}
+
+func TestChargeAuthorizationRejected(t *testing.T) {
+
+	cases := []struct {
+		name string
+		status int
+		body string
+		rejected bool
+		message string
+	}{
+		{"refused", 400, `{"status":false,"message":"Invalid authorization code"}`, true, "Invalid authorization code"},
+		{"false status", 200, `{"status":false,"message":"Duplicate reference"}`, true, "Duplicate reference"},
+		{"server error", 503, `{"status":false,"message":"Try again"}`, false, ""},
+	}
+
+	for _, c := range cases {
+		provider, _ := paystackServer(t, c.status, c.body)
+		_, err := provider.ChargeAuthorization(&ChargeRequest{Reference: "LP-TOPUP-1", AuthorizationCode: "AUTH_1", Amount: 50000})
+		if IsRejected(err) != c.rejected {
+			t.Errorf("%s: got %v, want rejected %v", c.name, err, c.rejected)
+			continue
+		}
+
+		if c.rejected && err.Error() != c.message {
+			t.Errorf("%s: rejected with %q, want %q", c.name, err.Error(), c.message)
+		}
+	}
+}
+
+//Amounts go over the wire as integers
+func TestChargeAuthorizationSendsKobo(t *testing.T) {
+
+	var body map[string] interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Write([]byte(`{"status":true,"message":"Charge attempted","data":{"reference":"LP-TOPUP-1",
+			"status":"success","amount":50001,"currency":"NGN","authorization":{"authorization_code":"AUTH_1",
+			"exp_month":12,"reusable":true}}}`))
+	}))
+	defer server.Close()
+
+	txn, err := newPaystackProvider("sk_test", server.URL).ChargeAuthorization(&ChargeRequest{
+		Reference: "LP-TOPUP-1", AuthorizationCode: "AUTH_1", Amount: 50001, Currency: "NGN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body["amount"] != float64(50001) {
+		t.Errorf("sent amount %v, want 50001", body["amount"])
+	}
+
+	if !txn.Successful() || txn.Amount != 50001 || txn.Authorization.ExpMonth != "12" || !txn.Authorization.Reusable {
+		t.Errorf("decoded %+v", txn)
+	}
+}
@@ -0,0 +1,164 @@
+package payments
+
+import (
+	"errors"
+	"os"
+	"fmt"
+	"strings"
+)
+
+//Statuses reported by providers for transactions, refunds and transfers
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailed = "failed"
+	StatusAbandoned = "abandoned"
+	StatusReversed = "reversed"
+)
+
+//Everything LitePay needs from a card/bank payment processor. Amounts are in minor units (kobo)
+type PaymentProvider interface {
+	Name() string
+	Initialize(req *InitializeRequest) (*InitializeResponse, error)
+	Verify(reference string) (*Transaction, error)
+	ChargeAuthorization(req *ChargeRequest) (*Transaction, error)
+	Refund(req *RefundRequest) (*Refund, error)
+	Transfer(req *TransferRequest) (*Transfer, error)
+	VerifyTransfer(reference string) (*Transfer, error)
+	ResolveAccount(accountNumber, bankCode string) (*BankAccount, error)
+	CreateRecipient(req *RecipientRequest) (*Recipient, error)
+}
+
+type InitializeRequest struct {
+	Reference string `json:"reference"`
+	Email string `json:"email"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+type InitializeResponse struct {
+	Reference string `json:"reference"`
+	AccessCode string `json:"access_code"`
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+//A reusable card authorization returned with successful charges
+type Authorization struct {
+	AuthorizationCode string `json:"authorization_code"`
+	Bin string `json:"bin"`
+	Last4 string `json:"last4"`
+	ExpMonth string `json:"exp_month"`
+	ExpYear string `json:"exp_year"`
+	CardType string `json:"card_type"`
+	Brand string `json:"brand"`
+	Bank string `json:"bank"`
+	Signature string `json:"signature"`
+	Reusable bool `json:"reusable"`
+}
+
+type Transaction struct {
+	Reference string `json:"reference"`
+	Status string `json:"status"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+	GatewayResponse string `json:"gateway_response"`
+	Authorization Authorization `json:"authorization"`
+}
+
+func (t *Transaction) Successful() bool {
+	return t.Status == StatusSuccess
+}
+
+type ChargeRequest struct {
+	Reference string `json:"reference"`
+	Email string `json:"email"`
+	AuthorizationCode string `json:"authorization_code"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+//Refund all or part of a successful transaction. A zero amount refunds it in full
+type RefundRequest struct {
+	Transaction string `json:"transaction"`
+	Amount int64 `json:"amount,omitempty"`
+	Currency string `json:"currency,omitempty"`
+}
+
+type Refund struct {
+	Transaction string `json:"transaction"`
+	Status string `json:"status"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+//Send money to a transfer recipient previously registered with the provider
+type TransferRequest struct {
+	Reference string `json:"reference"`
+	Recipient string `json:"recipient"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+	Reason string `json:"reason"`
+}
+
+type Transfer struct {
+	Reference string `json:"reference"`
+	TransferCode string `json:"transfer_code"`
+	Status string `json:"status"`
+	Amount int64 `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+//The holder of a bank account, as the bank knows them
+type BankAccount struct {
+	AccountNumber string `json:"account_number"`
+	AccountName string `json:"account_name"`
+	BankCode string `json:"bank_code"`
+}
+
+//Register a bank account that transfers can be sent to
+type RecipientRequest struct {
+	Name string `json:"name"`
+	AccountNumber string `json:"account_number"`
+	BankCode string `json:"bank_code"`
+	Currency string `json:"currency"`
+}
+
+type Recipient struct {
+	RecipientCode string `json:"recipient_code"`
+	Name string `json:"name"`
+}
+
+//The provider answered and turned the request down, so it had no effect. Any other error from a
+//provider, a timeout say, leaves the outcome of the request unknown
+type RejectedError struct {
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Message
+}
+
+func IsRejected(err error) bool {
+	_, ok := err.(*RejectedError)
+	return ok
+}
+
+//The provider has no transfer with the reference asked about, so it was never sent
+var ErrTransferNotFound = errors.New("transfer not found")
+
+//Build the provider named by PAYMENT_PROVIDER. Defaults to paystack
+func NewProviderFromEnv() (PaymentProvider, error) {
+
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER")))
+	switch name {
+	case "", "paystack":
+		return NewPaystackProvider(os.Getenv("PS_KEY")), nil
+	case "fake":
+		fake := NewFakeProvider()
+		fake.AutoSucceed = true
+		return fake, nil
+	}
+
+	return nil, fmt.Errorf("unknown payment provider '%s'", name)
+}